@@ -1,4 +1,4 @@
-import { initialState, type Action, type Block, type State } from "./types";
+import { initialState, type Action, type Block, type ChatEntry, type Reaction, type ReactionEvent, type State } from "./types";
 import { randomName } from "./utils";
 
 export enum MessageType {
@@ -8,16 +8,76 @@ export enum MessageType {
     Challenge = 'challenge',
     ResolveDeath = 'resolve_death',
     CommitTurn = 'commit_turn',
-    EndTurn = 'end_turn'
+    EndTurn = 'end_turn',
+    Ready = 'ready',
+    Unready = 'unready',
+    Chat = 'chat',
+    React = 'react',
+    MutePlayer = 'mute_player',
+    UnmutePlayer = 'unmute_player',
+    KickPlayer = 'kick_player',
+    BanPlayer = 'ban_player',
+    ReorderSeats = 'reorder_seats',
+    TransferOwner = 'transfer_owner'
 }
 
 export interface Message {
     type: MessageType,
     payload?: Record<string, any>;
+    /**
+     * Unique per command. The server acknowledges each request ID, and ignores repeats.
+     */
+    requestId?: string;
+    /**
+     * The state version the command was issued against. Commands sent against an
+     * outdated state are rejected.
+     */
+    baseVersion?: number;
+}
+
+/**
+ * Types of message sent by the server. State updates are sent unwrapped with the `state` type.
+ */
+export enum ServerMessageType {
+    Hello = 'hello',
+    State = 'state',
+    Announcement = 'announcement',
+    Ack = 'ack',
+    Countdown = 'countdown',
+    Chat = 'chat',
+    ChatHistory = 'chat_history',
+    Reaction = 'reaction',
+    Kicked = 'kicked',
+}
+
+export interface ServerMessage {
+    type: ServerMessageType,
+    payload?: Record<string, any>;
 }
 
 const WS_TIMEOUT = 5000;
 
+/**
+ * The protocol version this client speaks, advertised to the server as a websocket subprotocol.
+ * The server publishes the full message schema at `/schema`.
+ */
+export const PROTOCOL_VERSION = 1;
+
+/**
+ * The session storage key holding the session token for a game.
+ */
+function sessionKey(url: URL) {
+    return `revolt-session:${url.pathname}`;
+}
+
+/**
+ * Stores a session token to be resumed when connecting to a game, such as the owner's session
+ * sent when creating a game.
+ */
+export function storeSession(uri: string, token: string) {
+    sessionStorage.setItem(sessionKey(new URL(uri)), token);
+}
+
 export enum ClientStatus {
     Default = "default",
     Connecting = "connecting",
@@ -30,7 +90,11 @@ export class Client {
     state: State = initialState;
     status: ClientStatus = ClientStatus.Default;
 
-    constructor(private onStateUpdate: (state: State) => void) { };
+    constructor(
+        private onStateUpdate: (state: State) => void,
+        private onChat: (messages: ChatEntry[], replace: boolean) => void = () => { },
+        private onReaction: (reaction: ReactionEvent) => void = () => { },
+    ) { };
 
     async connect(uri: string, playerName?: string) {
         this.status = ClientStatus.Connecting;
@@ -42,7 +106,13 @@ export class Client {
 
         const url = new URL(uri);
         url.searchParams.set('name', playerName);
-        const socket = new WebSocket(url);
+
+        // Resume an earlier session in this game, if there is one.
+        const token = sessionStorage.getItem(sessionKey(url));
+        if (token) {
+            url.searchParams.set('token', token);
+        }
+        const socket = new WebSocket(url, [`revolt.v${PROTOCOL_VERSION}`]);
 
         return new Promise<void>(resolve => {
             const timeout = setTimeout(() => {
@@ -53,7 +123,7 @@ export class Client {
                 this.status = ClientStatus.Connected;
 
                 clearTimeout(timeout);
-                socket.onmessage = e => this.handleMessage(e);
+                socket.onmessage = e => this.handleMessage(e, url);
                 this.socket = socket;
                 resolve();
             };
@@ -136,20 +206,137 @@ export class Client {
         });
     }
 
+    /**
+     * Marks this player as ready or not ready to start.
+     */
+    setReady(ready: boolean) {
+        this.sendMessage({
+            type: ready ? MessageType.Ready : MessageType.Unready,
+        });
+    }
+
+    /**
+     * Sends a chat message. Players talk to the table, and spectators to other spectators.
+     */
+    chat(text: string) {
+        this.sendMessage({
+            type: MessageType.Chat,
+            payload: {
+                text
+            }
+        });
+    }
+
+    /**
+     * Fires a reaction at a peer, or at the pending action if no peer is given.
+     */
+    react(reaction: Reaction, target?: string) {
+        this.sendMessage({
+            type: MessageType.React,
+            payload: target ? { reaction, target } : { reaction, pendingAction: true }
+        });
+    }
+
+    /**
+     * Mutes or unmutes a player's chat. Owner only.
+     */
+    mutePlayer(playerId: string, muted = true) {
+        this.sendMessage({
+            type: muted ? MessageType.MutePlayer : MessageType.UnmutePlayer,
+            payload: {
+                playerId
+            }
+        });
+    }
+
+    /**
+     * Removes a player from the lobby. Banned players can't rejoin with their account, so only
+     * players who joined as an account can be banned. Owner only.
+     */
+    kickPlayer(playerId: string, ban = false) {
+        this.sendMessage({
+            type: ban ? MessageType.BanPlayer : MessageType.KickPlayer,
+            payload: {
+                playerId
+            }
+        });
+    }
+
+    /**
+     * Sets the seat order, which must list every player. Owner only.
+     */
+    reorderSeats(order: string[]) {
+        this.sendMessage({
+            type: MessageType.ReorderSeats,
+            payload: {
+                order
+            }
+        });
+    }
+
+    /**
+     * Makes another player the owner. Owner only.
+     */
+    transferOwner(playerId: string) {
+        this.sendMessage({
+            type: MessageType.TransferOwner,
+            payload: {
+                playerId
+            }
+        });
+    }
+
     private sendMessage(message: Message) {
         if (!this.socket) {
             return;
         }
+        message.requestId = crypto.randomUUID();
+        message.baseVersion = this.state.version || undefined;
         console.log('sending message', JSON.stringify(message, undefined, 2));
         this.socket.send(JSON.stringify(message));
     }
 
-    private handleMessage(event: MessageEvent) {
+    private handleMessage(event: MessageEvent, url: URL) {
         if (!event.data) {
             return;
         }
-        const message = JSON.parse(event.data) as State;
-        this.state = message;
+        const message = JSON.parse(event.data) as ServerMessage | State;
+        switch (message.type) {
+            case ServerMessageType.Hello:
+                if (message.payload?.warning) {
+                    console.warn('server warning:', message.payload.warning);
+                }
+                if (message.payload?.token) {
+                    sessionStorage.setItem(sessionKey(url), message.payload.token);
+                }
+                return;
+            case ServerMessageType.Ack:
+                if (message.payload?.status === 'rejected') {
+                    console.warn('command rejected:', message.payload.error);
+                }
+                return;
+            case ServerMessageType.Countdown:
+                // The state's `startsAt` holds the deadline, so this is only informational.
+                console.info(message.payload?.cancelled ? 'countdown cancelled' : `starting in ${message.payload?.remaining}`);
+                return;
+            case ServerMessageType.Chat:
+                this.onChat([message.payload as ChatEntry], false);
+                return;
+            case ServerMessageType.ChatHistory:
+                this.onChat(message.payload?.messages ?? [], true);
+                return;
+            case ServerMessageType.Reaction:
+                this.onReaction(message.payload as ReactionEvent);
+                return;
+            case ServerMessageType.Kicked:
+                console.info(message.payload?.banned ? 'banned from game' : 'kicked from game');
+                sessionStorage.removeItem(sessionKey(url));
+                return;
+            case ServerMessageType.Announcement:
+                console.info('announcement:', message.payload?.message);
+                return;
+        }
+        this.state = message as State;
         this.onStateUpdate(this.state);
         console.log('received state update:', JSON.stringify(this.state, undefined, 2));
     }
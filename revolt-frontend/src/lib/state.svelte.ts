@@ -1,5 +1,5 @@
 import { Client, ClientStatus } from "./client";
-import { initialState, type State } from "./types";
+import { initialState, type ChatEntry, type State } from "./types";
 
 /**
  * Utility class allowing the entire app to react to state update messages - the `global` object
@@ -10,8 +10,12 @@ import { initialState, type State } from "./types";
 class GlobalStore {
     state: State = $state(initialState);
     status = $state(ClientStatus.Default);
-    // The callback here is used to trigger rerenders when the game state updates.
-    client = new Client(update => (this.state = update));
+    chat: ChatEntry[] = $state([]);
+    // The callbacks here are used to trigger rerenders when the game state or chat updates.
+    client = new Client(
+        update => (this.state = update),
+        (messages, replace) => (this.chat = replace ? messages : [...this.chat, ...messages]),
+    );
 }
 
 export const global = new GlobalStore();
\ No newline at end of file
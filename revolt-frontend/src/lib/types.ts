@@ -85,27 +85,93 @@ export interface Peer {
     cards: CardState[];
     credits: number;
     leading: boolean;
+    /**
+     * Whether the player is ready to start. Only meaningful in the lobby.
+     */
+    ready?: boolean;
+    muted?: boolean;
     /**
      * Allowed actions - should only appear on `self`.
      */
     allowedActions?: ActionType[];
+    /**
+     * Set for players who joined as a registered account.
+     */
+    account?: {
+        id: string;
+        avatar: string;
+    };
+}
+
+/**
+ * A chat message. Table talk is visible to everyone, while spectators talk among themselves.
+ */
+export interface ChatEntry {
+    channel: "table" | "spectators";
+    from: string;
+    name: string;
+    text: string;
+    timestamp: string;
+}
+
+/**
+ * Quick reactions players can fire at a peer or the pending action.
+ */
+export type Reaction = "doubt" | "nice_bluff" | "thinking" | "well_played";
+
+/**
+ * A reaction sent by a player. Reactions aren't part of the game state.
+ */
+export interface ReactionEvent {
+    from: string;
+    reaction: Reaction;
+    /**
+     * The peer reacted to. Reactions to the pending action target the player taking it.
+     */
+    target: string;
+    action?: Action;
+    timestamp: string;
 }
 
 export interface CreateGameResponse {
     id: string;
+    /**
+     * A short code players can join with in place of the game ID.
+     */
+    inviteCode: string;
+    playerId: string;
+    /**
+     * The creator's session, which makes them the game's owner when they connect.
+     */
+    token: string;
 }
 
 /**
  * A state update received from the server.
  */
 export interface State {
+    type?: "state",
+    /**
+     * Increases with every update. Sent back with commands as their base version.
+     */
+    version: number,
     timestamp: string,
     gameId: string,
+    inviteCode: string,
     ownerId: string;
     winner: string;
     self: Peer;
     peers: Peer[];
     status: GameStatus,
+    /**
+     * When cards will be dealt, while the game is counting down to starting.
+     */
+    startsAt?: string,
+    /**
+     * Set if this client is watching rather than playing.
+     */
+    spectator?: boolean,
+    spectators: number,
     nextDeath: string,
     turnState: TurnState,
     pendingAction: Action;
@@ -114,8 +180,10 @@ export interface State {
 }
 
 export const initialState: State = {
+    version: 0,
     timestamp: "",
     gameId: "",
+    inviteCode: "",
     ownerId: "",
     winner: "",
     nextDeath: "",
@@ -128,6 +196,7 @@ export const initialState: State = {
     },
     peers: [],
     status: GameStatus.Default,
+    spectators: 0,
     turnState: TurnState.Default,
     pendingAction: {
         type: ActionType.Empty,
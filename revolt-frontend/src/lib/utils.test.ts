@@ -1,6 +1,6 @@
 import { describe, expect, it } from 'vitest';
 import { ActionType, Card } from "./types";
-import { formatCard, getCurrentActionBlockers } from "./utils";
+import { apiUrl, formatCard, getCurrentActionBlockers, socketUrl } from "./utils";
 
 describe('formatCard', () => {
     it('should format a card in title case', () => {
@@ -45,4 +45,16 @@ describe('getCurrentActionBlockers', () => {
         } as any);
         expect(result).toStrictEqual([]);
     });
-});
\ No newline at end of file
+});
+describe('server URLs', () => {
+    it('should address the server the page was loaded from', () => {
+        const location = { protocol: 'http:', host: 'localhost:5173' };
+        expect(apiUrl('/create', location)).toBe('http://localhost:5173/api/create');
+        expect(socketUrl('/1234', location)).toBe('ws://localhost:5173/ws/1234');
+    });
+
+    it('should use secure websockets for pages loaded over HTTPS', () => {
+        const location = { protocol: 'https:', host: 'revolt.example' };
+        expect(socketUrl('/1234', location)).toBe('wss://revolt.example/ws/1234');
+    });
+});
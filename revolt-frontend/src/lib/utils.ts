@@ -126,4 +126,22 @@ export function formatCurrentBlock(state: State): string {
     const blocker = getPlayerById(state, state.pendingBlock.initiator);
     const card = formatCard(state.pendingBlock.card);
     return `${blocker} has blocked your action with their ${card}.`;
-}
\ No newline at end of file
+}
+
+type ServerLocation = Pick<Location, 'protocol' | 'host'>;
+
+/**
+ * Returns the URL of an HTTP endpoint on the server the page was loaded from.
+ */
+export function apiUrl(path: string, location: ServerLocation = window.location): string {
+    return `${location.protocol}//${location.host}/api${path}`;
+}
+
+/**
+ * Returns the URL of a websocket endpoint on the server the page was loaded from, connecting
+ * securely if the page was loaded over HTTPS.
+ */
+export function socketUrl(path: string, location: ServerLocation = window.location): string {
+    const protocol = location.protocol === 'https:' ? 'wss:' : 'ws:';
+    return `${protocol}//${location.host}/ws${path}`;
+}
@@ -3,5 +3,13 @@ import { defineConfig } from 'vite';
 
 // https://vite.dev/config/
 export default defineConfig({
-    plugins: [svelte()]
+    plugins: [svelte()],
+    server: {
+        // In development the Go server runs separately, so API and websocket requests are
+        // passed through to it.
+        proxy: {
+            '/api': 'http://localhost:8080',
+            '/ws': { target: 'ws://localhost:8080', ws: true },
+        },
+    },
 });
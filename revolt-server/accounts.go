@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"revolt/game"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The query parameter clients use to join a game as an account, holding an account token.
+const AccountKey = "account"
+
+var (
+	ErrUsernameTaken      = errors.New("username is taken")
+	ErrInvalidUsername    = errors.New("usernames must be 3 to 20 letters, numbers, underscores or hyphens")
+	ErrInvalidPassword    = errors.New("passwords must be 8 to 72 bytes long")
+	ErrInvalidCredentials = errors.New("incorrect username or password")
+	ErrAccountNotFound    = errors.New("account not found")
+	ErrInvalidProfile     = errors.New("invalid profile")
+)
+
+// Avatars players can choose for their profile.
+var Avatars = []string{"duke", "assassin", "ambassador", "captain", "contessa"}
+
+// The longest display name allowed, in characters.
+const MaxDisplayNameLength = 32
+
+// The bcrypt cost used to hash passwords.
+var passwordCost = bcrypt.DefaultCost
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{3,20}$`)
+
+// A registered player.
+type Account struct {
+	Id           string    `json:"id"`
+	Username     string    `json:"username"`
+	PasswordHash []byte    `json:"passwordHash"`
+	Created      time.Time `json:"created"`
+	Profile      Profile   `json:"profile"`
+}
+
+// The parts of an account players can change, and which others can see.
+type Profile struct {
+	DisplayName string      `json:"displayName"`
+	Avatar      string      `json:"avatar"`
+	Preferences Preferences `json:"preferences"`
+}
+
+// Connection defaults used when joining a game as an account.
+// Options given when connecting take precedence.
+type Preferences struct {
+	UpdateMode UpdateMode `json:"updateMode,omitempty"`
+	Encoding   string     `json:"encoding,omitempty"`
+}
+
+// Checks a profile's values are allowed.
+func (p Profile) Validate() error {
+	length := utf8.RuneCountInString(p.DisplayName)
+	if strings.TrimSpace(p.DisplayName) == "" || length > MaxDisplayNameLength {
+		return ErrInvalidProfile
+	}
+	if p.Avatar != "" && !slices.Contains(Avatars, p.Avatar) {
+		return ErrInvalidProfile
+	}
+	if p.Preferences.UpdateMode != "" && p.Preferences.UpdateMode != FullUpdates && p.Preferences.UpdateMode != DeltaUpdates {
+		return ErrInvalidProfile
+	}
+	if p.Preferences.Encoding != "" && p.Preferences.Encoding != jsonCodec.Name() && p.Preferences.Encoding != msgpackCodec.Name() {
+		return ErrInvalidProfile
+	}
+	return nil
+}
+
+// An account's public details, as seen by other players in a game.
+type PlayerAccount struct {
+	Id     string `json:"id"`
+	Avatar string `json:"avatar"`
+}
+
+var (
+	accountsBucket  = []byte("accounts")
+	usernamesBucket = []byte("usernames")
+)
+
+// Stores accounts in a local bbolt database. Accounts are keyed by ID, with a separate
+// index of lowercased usernames so names are unique regardless of case.
+type AccountStore struct {
+	db *bolt.DB
+}
+
+// Global account store. Nil if accounts are disabled.
+var accounts *AccountStore
+
+// Opens the account database at `path`, creating it if needed.
+func OpenAccountStore(path string) (*AccountStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{accountsBucket, usernamesBucket, statsBucket, historyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &AccountStore{db: db}, nil
+}
+
+func (s *AccountStore) Close() error {
+	return s.db.Close()
+}
+
+// Registers a new account. The display name defaults to the username.
+func (s *AccountStore) Create(username string, password string) (Account, error) {
+	if !usernamePattern.MatchString(username) {
+		return Account{}, ErrInvalidUsername
+	}
+	if len(password) < 8 || len(password) > 72 {
+		return Account{}, ErrInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return Account{}, err
+	}
+
+	account := Account{
+		Id:           game.Id(),
+		Username:     username,
+		PasswordHash: hash,
+		Created:      time.Now().UTC(),
+		Profile:      Profile{DisplayName: username},
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		usernames := tx.Bucket(usernamesBucket)
+		key := []byte(strings.ToLower(username))
+		if usernames.Get(key) != nil {
+			return ErrUsernameTaken
+		}
+		if err := usernames.Put(key, []byte(account.Id)); err != nil {
+			return err
+		}
+		return putAccount(tx, account)
+	})
+	if err != nil {
+		return Account{}, err
+	}
+	return account, nil
+}
+
+// Checks a username and password, returning the matching account.
+func (s *AccountStore) Login(username string, password string) (Account, error) {
+	var account Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(usernamesBucket).Get([]byte(strings.ToLower(username)))
+		if id == nil {
+			return ErrInvalidCredentials
+		}
+		var err error
+		account, err = getAccount(tx, string(id))
+		return err
+	})
+	if errors.Is(err, ErrInvalidCredentials) {
+		// Hash anyway, so unknown usernames take as long to reject as wrong passwords.
+		bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+		return Account{}, err
+	}
+	if err != nil {
+		return Account{}, err
+	}
+	if bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)) != nil {
+		return Account{}, ErrInvalidCredentials
+	}
+	return account, nil
+}
+
+// Looks up an account by ID.
+func (s *AccountStore) Get(id string) (Account, error) {
+	var account Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		account, err = getAccount(tx, id)
+		return err
+	})
+	return account, err
+}
+
+// Replaces an account's profile.
+func (s *AccountStore) UpdateProfile(id string, profile Profile) (Account, error) {
+	if err := profile.Validate(); err != nil {
+		return Account{}, err
+	}
+	var account Account
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		account, err = getAccount(tx, id)
+		if err != nil {
+			return err
+		}
+		account.Profile = profile
+		return putAccount(tx, account)
+	})
+	return account, err
+}
+
+func getAccount(tx *bolt.Tx, id string) (Account, error) {
+	data := tx.Bucket(accountsBucket).Get([]byte(id))
+	if data == nil {
+		return Account{}, ErrAccountNotFound
+	}
+	var account Account
+	err := json.Unmarshal(data, &account)
+	return account, err
+}
+
+func putAccount(tx *bolt.Tx, account Account) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(accountsBucket).Put([]byte(account.Id), data)
+}
+
+// Request body for registering and logging in.
+type CredentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// An account as returned to its owner, without the password hash.
+type AccountResponse struct {
+	Id       string    `json:"id"`
+	Username string    `json:"username"`
+	Created  time.Time `json:"created"`
+	Profile  Profile   `json:"profile"`
+}
+
+// Sent in response to registering or logging in.
+type LoginResponse struct {
+	Account AccountResponse `json:"account"`
+	// Authenticates requests made as the account, and joins games as the account when
+	// passed as `?account=` when connecting.
+	Token string `json:"token"`
+}
+
+func accountResponse(account Account) AccountResponse {
+	return AccountResponse{
+		Id:       account.Id,
+		Username: account.Username,
+		Created:  account.Created,
+		Profile:  account.Profile,
+	}
+}
+
+// Looks up the account whose token is in `token`.
+func authenticateAccount(token string) (Account, error) {
+	session, err := sessions.Verify(token)
+	if err != nil {
+		return Account{}, err
+	}
+	if session.AccountId == "" {
+		return Account{}, ErrInvalidToken
+	}
+	return accounts.Get(session.AccountId)
+}
+
+// Registers an account at `POST /accounts`.
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	var request CredentialsRequest
+	if !readCredentials(w, r, &request) {
+		return
+	}
+	account, err := accounts.Create(request.Username, request.Password)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrUsernameTaken) {
+			status = http.StatusConflict
+		} else if !errors.Is(err, ErrInvalidUsername) && !errors.Is(err, ErrInvalidPassword) {
+			slog.Error("failed to create account", "error", err)
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	slog.Info("account registered", "account", account.Id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, LoginResponse{Account: accountResponse(account), Token: sessions.IssueAccount(account.Id)})
+}
+
+// Exchanges a username and password for an account token at `POST /login`.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	var request CredentialsRequest
+	if !readCredentials(w, r, &request) {
+		return
+	}
+	account, err := accounts.Login(request.Username, request.Password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		} else {
+			slog.Error("failed to log in", "error", err)
+			http.Error(w, "failed to log in", http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, LoginResponse{Account: accountResponse(account), Token: sessions.IssueAccount(account.Id)})
+}
+
+func readCredentials(w http.ResponseWriter, r *http.Request, request *CredentialsRequest) bool {
+	if r.Method != "POST" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := decodeBody(r, jsonCodec, request); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// Reads and updates the profile of the account whose token is given as a bearer token,
+// at `GET` and `PUT /accounts/me`.
+func accountHandler(w http.ResponseWriter, r *http.Request) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	account, err := authenticateAccount(token)
+	if err != nil {
+		http.Error(w, "unauthorised", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, accountResponse(account))
+	case "PUT":
+		var profile Profile
+		if err := decodeBody(r, jsonCodec, &profile); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		account, err = accounts.UpdateProfile(account.Id, profile)
+		if err != nil {
+			if errors.Is(err, ErrInvalidProfile) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			} else {
+				slog.Error("failed to update profile", "error", err)
+				http.Error(w, "failed to update profile", http.StatusInternalServerError)
+			}
+			return
+		}
+		writeJSON(w, accountResponse(account))
+	default:
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+	}
+}
+
+// Adds the account routes to `mux`. No routes are added if accounts are disabled.
+func registerAccountRoutes(mux *http.ServeMux) {
+	if accounts == nil {
+		return
+	}
+	mux.Handle("/accounts", http.HandlerFunc(registerHandler))
+	mux.Handle("/accounts/me", http.HandlerFunc(accountHandler))
+	mux.Handle("/login", http.HandlerFunc(loginHandler))
+	mux.Handle("/leaderboard", http.HandlerFunc(leaderboardHandler))
+	mux.Handle("/players/{id}/stats", http.HandlerFunc(playerStatsHandler))
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAccountStore(t *testing.T) {
+	passwordCost = bcrypt.MinCost
+	setup := func(t *testing.T) *AccountStore {
+		store, err := OpenAccountStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+
+	t.Run("should log in with the password an account was created with", func(t *testing.T) {
+		store := setup(t)
+		created, err := store.Create("player", "password1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		account, err := store.Login("Player", "password1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account.Id != created.Id || account.Profile.DisplayName != "player" {
+			t.Errorf("expected created account, got %+v", account)
+		}
+
+		_, err = store.Login("player", "password2")
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("expected invalid credentials, got %v", err)
+		}
+		_, err = store.Login("nobody", "password1")
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("expected invalid credentials, got %v", err)
+		}
+	})
+
+	t.Run("should not store plain text passwords", func(t *testing.T) {
+		store := setup(t)
+		account, _ := store.Create("player", "password1")
+
+		if strings.Contains(string(account.PasswordHash), "password1") {
+			t.Error("expected password to be hashed")
+		}
+	})
+
+	t.Run("should reject duplicate usernames regardless of case", func(t *testing.T) {
+		store := setup(t)
+		store.Create("player", "password1")
+
+		_, err := store.Create("PLAYER", "password1")
+		if !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("expected username taken, got %v", err)
+		}
+	})
+
+	t.Run("should validate usernames and passwords", func(t *testing.T) {
+		store := setup(t)
+
+		if _, err := store.Create("a b", "password1"); !errors.Is(err, ErrInvalidUsername) {
+			t.Errorf("expected invalid username, got %v", err)
+		}
+		if _, err := store.Create("player", "short"); !errors.Is(err, ErrInvalidPassword) {
+			t.Errorf("expected invalid password, got %v", err)
+		}
+	})
+
+	t.Run("should update and validate profiles", func(t *testing.T) {
+		store := setup(t)
+		account, _ := store.Create("player", "password1")
+
+		profile := Profile{DisplayName: "Player", Avatar: "duke", Preferences: Preferences{UpdateMode: DeltaUpdates}}
+		_, err := store.UpdateProfile(account.Id, profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		account, _ = store.Get(account.Id)
+		if account.Profile != profile {
+			t.Errorf("expected profile %+v, got %+v", profile, account.Profile)
+		}
+
+		for _, invalid := range []Profile{
+			{DisplayName: ""},
+			{DisplayName: strings.Repeat("a", MaxDisplayNameLength+1)},
+			{DisplayName: "Player", Avatar: "unknown"},
+			{DisplayName: "Player", Preferences: Preferences{Encoding: "xml"}},
+		} {
+			if _, err := store.UpdateProfile(account.Id, invalid); !errors.Is(err, ErrInvalidProfile) {
+				t.Errorf("expected %+v to be invalid, got %v", invalid, err)
+			}
+		}
+	})
+
+	t.Run("should keep accounts when reopened", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		store, _ := OpenAccountStore(path)
+		created, _ := store.Create("player", "password1")
+		store.Close()
+
+		store, err := OpenAccountStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		if _, err := store.Get(created.Id); err != nil {
+			t.Errorf("expected account to persist, got %v", err)
+		}
+	})
+}
+
+func TestAccountHandlers(t *testing.T) {
+	passwordCost = bcrypt.MinCost
+	setup := func(t *testing.T) *httptest.Server {
+		store, err := OpenAccountStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts = store
+		initInstanceManager()
+
+		mux := http.NewServeMux()
+		registerAccountRoutes(mux)
+		mux.Handle("/{id}", http.HandlerFunc(websocketHandler))
+		server := httptest.NewServer(mux)
+		t.Cleanup(func() {
+			server.Close()
+			store.Close()
+			accounts = nil
+		})
+		return server
+	}
+
+	post := func(t *testing.T, url string, body string) (*http.Response, LoginResponse) {
+		t.Helper()
+		res, err := http.Post(url, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var login LoginResponse
+		json.NewDecoder(res.Body).Decode(&login)
+		return res, login
+	}
+
+	t.Run("should register and log in", func(t *testing.T) {
+		server := setup(t)
+
+		res, registered := post(t, server.URL+"/accounts", `{"username":"player","password":"password1"}`)
+		if res.StatusCode != http.StatusCreated || registered.Token == "" {
+			t.Fatalf("expected account to be created, got %d", res.StatusCode)
+		}
+		res, _ = post(t, server.URL+"/accounts", `{"username":"player","password":"password1"}`)
+		if res.StatusCode != http.StatusConflict {
+			t.Errorf("expected status 409, got %d", res.StatusCode)
+		}
+
+		res, login := post(t, server.URL+"/login", `{"username":"player","password":"password1"}`)
+		if res.StatusCode != http.StatusOK || login.Account.Id != registered.Account.Id {
+			t.Errorf("expected to log in, got %d", res.StatusCode)
+		}
+		res, _ = post(t, server.URL+"/login", `{"username":"player","password":"wrong"}`)
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("should update the logged in account's profile", func(t *testing.T) {
+		server := setup(t)
+		_, login := post(t, server.URL+"/accounts", `{"username":"player","password":"password1"}`)
+
+		req, _ := http.NewRequest("PUT", server.URL+"/accounts/me", strings.NewReader(`{"displayName":"Player One","avatar":"captain"}`))
+		req.Header.Set("Authorization", "Bearer "+login.Token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var account AccountResponse
+		json.NewDecoder(res.Body).Decode(&account)
+		res.Body.Close()
+		if account.Profile.DisplayName != "Player One" || account.Profile.Avatar != "captain" {
+			t.Errorf("expected updated profile, got %+v", account.Profile)
+		}
+
+		req, _ = http.NewRequest("GET", server.URL+"/accounts/me", nil)
+		req.Header.Set("Authorization", "Bearer "+sessions.Issue("game", "player"))
+		res, _ = http.DefaultClient.Do(req)
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected game tokens to be refused, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("should join games as an account", func(t *testing.T) {
+		server := setup(t)
+		_, login := post(t, server.URL+"/accounts", `{"username":"player","password":"password1"}`)
+		accounts.UpdateProfile(login.Account.Id, Profile{DisplayName: "Player One", Avatar: "duke"})
+
+		instance := NewGameInstance("")
+		im.RegisterInstance(instance)
+		go instance.Run()
+		defer instance.Stop()
+
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/" + instance.GameId + "?account=" + login.Token
+		join := func() string {
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { conn.Close() })
+
+			for {
+				var state ClientStateBroadcast
+				if err := conn.ReadJSON(&state); err != nil {
+					t.Fatal(err)
+				}
+				if state.Type != StateMessage {
+					continue
+				}
+				if state.Self.Name != "Player One" || state.Self.Account == nil || state.Self.Account.Avatar != "duke" {
+					t.Errorf("expected to play as the account, got %+v", state.Self)
+				}
+				return state.Self.Id
+			}
+		}
+
+		first := join()
+		if second := join(); second != first {
+			t.Errorf("expected account to rejoin as %s, got %s", first, second)
+		}
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"revolt/game"
+	"strings"
+)
+
+// A summary of an instance, as listed by the admin API.
+type InstanceSummary struct {
+	Id        string         `json:"id"`
+	OwnerId   string         `json:"ownerId"`
+	Status    GameStatus     `json:"status"`
+	TurnState game.TurnState `json:"turnState"`
+	Players   int            `json:"players"`
+	Clients   int            `json:"clients"`
+}
+
+// The full server-side state of an instance, including hidden cards and the deck.
+type InstanceDetail struct {
+	InstanceSummary
+	ConnectedClients []ClientSummary `json:"connectedClients"`
+	Game             game.Game       `json:"game"`
+}
+
+type ClientSummary struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type AnnounceRequest struct {
+	Message string `json:"message"`
+}
+
+// Must be called on the instance goroutine.
+func (gi *GameInstance) summary() InstanceSummary {
+	return InstanceSummary{
+		Id:        gi.GameId,
+		OwnerId:   gi.OwnerId,
+		Status:    gi.Status,
+		TurnState: gi.Game.TurnState,
+		Players:   len(gi.Game.Players),
+		Clients:   len(gi.Clients),
+	}
+}
+
+// Must be called on the instance goroutine.
+func (gi *GameInstance) detail() InstanceDetail {
+	clients := []ClientSummary{}
+	for _, client := range gi.Clients {
+		clients = append(clients, ClientSummary{Id: client.Id, Name: client.Name})
+	}
+	return InstanceDetail{
+		InstanceSummary:  gi.summary(),
+		ConnectedClients: clients,
+		Game:             gi.Game,
+	}
+}
+
+// Wraps a handler so it can only be called with the admin token as a bearer token.
+func requireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			http.Error(w, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Registers the admin API on `mux`. Does nothing if no admin token is configured.
+func registerAdminRoutes(mux *http.ServeMux, token string) {
+	if token == "" {
+		return
+	}
+	mux.Handle("/admin/instances", requireAdmin(token, adminInstancesHandler))
+	mux.Handle("/admin/instances/{id}", requireAdmin(token, adminInstanceHandler))
+	mux.Handle("/admin/instances/{id}/end", requireAdmin(token, adminEndInstanceHandler))
+	mux.Handle("/admin/announce", requireAdmin(token, adminAnnounceHandler))
+}
+
+// Lists all instances with their status and player counts.
+func adminInstancesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	summaries := []InstanceSummary{}
+	for _, instance := range im.ListInstances() {
+		instance.Do(func() {
+			summaries = append(summaries, instance.summary())
+		})
+	}
+	writeJSON(w, summaries)
+}
+
+// Returns the full state of an instance on GET, or stops and removes it on DELETE.
+func adminInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	instance, ok := im.GetInstance(id)
+	if !ok {
+		http.Error(w, "instance not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		var detail InstanceDetail
+		if !instance.Do(func() { detail = instance.detail() }) {
+			http.Error(w, "instance not found", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, detail)
+
+	case "DELETE":
+		if !im.DeleteInstance(id) {
+			http.Error(w, "instance not found", http.StatusNotFound)
+			return
+		}
+		instance.Logger.Info("instance deleted by admin")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+	}
+}
+
+// Ends an instance as if it had finished, recording its result and broadcasting the final state,
+// without disconnecting clients. Players still in the game are placed by seat.
+func adminEndInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	instance, ok := im.GetInstance(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "instance not found", http.StatusNotFound)
+		return
+	}
+
+	var summary InstanceSummary
+	ended := instance.Do(func() {
+		if instance.Status != Complete {
+			instance.complete()
+		}
+		instance.broadcast()
+		summary = instance.summary()
+	})
+	if !ended {
+		http.Error(w, "instance not found", http.StatusNotFound)
+		return
+	}
+	instance.Logger.Info("instance ended by admin")
+	writeJSON(w, summary)
+}
+
+// Sends an announcement to every connected client.
+func adminAnnounceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request AnnounceRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Message == "" {
+		http.Error(w, "invalid announcement", http.StatusBadRequest)
+		return
+	}
+
+	message := ServerMessage{
+		Type:    AnnouncementMessage,
+		Payload: AnnouncementPayload{Message: request.Message},
+	}
+	for _, instance := range im.ListInstances() {
+		instance.Do(func() {
+			instance.SendAll(message)
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Writes `v` to a response as JSON.
+func writeJSON(w http.ResponseWriter, v any) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"revolt/game"
+	"strings"
+	"testing"
+)
+
+const testAdminToken = "secret"
+
+func TestAdminAPI(t *testing.T) {
+	setup := func() (*http.ServeMux, *GameInstance) {
+		initInstanceManager()
+		instance := NewGameInstance("")
+		im.RegisterInstance(instance)
+		go instance.Run()
+
+		mux := http.NewServeMux()
+		registerAdminRoutes(mux, testAdminToken)
+		return mux, instance
+	}
+
+	request := func(mux *http.ServeMux, method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("should reject requests without the admin token", func(t *testing.T) {
+		mux, instance := setup()
+		defer instance.Stop()
+
+		req := httptest.NewRequest("GET", "/admin/instances", nil)
+		req.Header.Set("Authorization", "Bearer wrong")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %v", status)
+		}
+	})
+
+	t.Run("should not register routes without a token", func(t *testing.T) {
+		mux := http.NewServeMux()
+		registerAdminRoutes(mux, "")
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/instances", nil))
+
+		if status := rr.Code; status != http.StatusNotFound {
+			t.Errorf("expected status 404, got %v", status)
+		}
+	})
+
+	t.Run("should list instances", func(t *testing.T) {
+		mux, instance := setup()
+		defer instance.Stop()
+		instance.Join(NewClient(nil, "Player One", slog.Default()))
+
+		rr := request(mux, "GET", "/admin/instances", "")
+
+		var summaries []InstanceSummary
+		err := json.Unmarshal(rr.Body.Bytes(), &summaries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(summaries) != 1 || summaries[0].Id != instance.GameId || summaries[0].Players != 1 {
+			t.Errorf("expected one instance with one player, got %+v", summaries)
+		}
+	})
+
+	t.Run("should include hidden state in instance details", func(t *testing.T) {
+		mux, instance := setup()
+		defer instance.Stop()
+
+		rr := request(mux, "GET", "/admin/instances/"+instance.GameId, "")
+
+		var detail InstanceDetail
+		err := json.Unmarshal(rr.Body.Bytes(), &detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(detail.Game.Deck) != len(instance.Game.Deck) {
+			t.Errorf("expected the deck to be included, got %+v", detail)
+		}
+	})
+
+	t.Run("should force-end an instance", func(t *testing.T) {
+		mux, instance := setup()
+		defer instance.Stop()
+		client := NewClient(nil, "Player One", slog.Default())
+		instance.Do(func() {
+			instance.Game.AddPlayer(client.Id, client.Name)
+			instance.Game.AddPlayer("two", "Player Two")
+			instance.Ready[client.Id] = true
+			instance.Ready["two"] = true
+			instance.start()
+		})
+		completed := 0
+		instance.OnComplete = func(*GameInstance) { completed++ }
+
+		rr := request(mux, "POST", "/admin/instances/"+instance.GameId+"/end", "")
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("expected status 200, got %v", status)
+		}
+
+		var status GameStatus
+		var err error
+		instance.Do(func() {
+			status = instance.Status
+			payload := AttemptActionPayload{Action: game.Action{Type: game.Income}}
+			err = instance.HandleMessage(client, Message{Type: AttemptActionMessage, Payload: NewPayload(payload)})
+		})
+		if status != Complete {
+			t.Errorf("expected instance to be complete, got %s", status)
+		}
+		if !rejectedWith(err, RejectNotInProgress) {
+			t.Errorf("expected moves to be rejected once ended, got %v", err)
+		}
+		// Tournaments are told about the table finishing, just as if it had been won.
+		request(mux, "POST", "/admin/instances/"+instance.GameId+"/end", "")
+		if completed != 1 {
+			t.Errorf("expected the game to complete once, completed %d times", completed)
+		}
+	})
+
+	t.Run("should delete an instance", func(t *testing.T) {
+		mux, instance := setup()
+		client := NewClient(nil, "Player One", slog.Default())
+		instance.Join(client)
+
+		rr := request(mux, "DELETE", "/admin/instances/"+instance.GameId, "")
+		if status := rr.Code; status != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %v", status)
+		}
+
+		if _, ok := im.GetInstance(instance.GameId); ok {
+			t.Error("expected instance to be removed")
+		}
+		if !client.Closed() {
+			t.Error("expected client to be disconnected")
+		}
+		if instance.Do(func() {}) {
+			t.Error("expected instance to be stopped")
+		}
+	})
+
+	t.Run("should send announcements to every client", func(t *testing.T) {
+		mux, instance := setup()
+		defer instance.Stop()
+		client := NewClient(nil, "Player One", slog.Default())
+		instance.Join(client)
+
+		rr := request(mux, "POST", "/admin/announce", `{"message":"restarting soon"}`)
+		if status := rr.Code; status != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %v", status)
+		}
+
+		expected := `{"type":"announcement","payload":{"message":"restarting soon"}}`
+		if message := string(<-client.send); message != expected {
+			t.Errorf("expected %s, got %s", expected, message)
+		}
+	})
+}
+
+func TestHealthHandlers(t *testing.T) {
+	t.Run("should report readiness once the server is ready", func(t *testing.T) {
+		ready.Store(false)
+		rr := httptest.NewRecorder()
+		readyHandler(rr, httptest.NewRequest("GET", "/readyz", nil))
+		if status := rr.Code; status != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503, got %v", status)
+		}
+
+		ready.Store(true)
+		rr = httptest.NewRecorder()
+		readyHandler(rr, httptest.NewRequest("GET", "/readyz", nil))
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("expected status 200, got %v", status)
+		}
+	})
+	t.Run("should not be ready while the cluster is unreachable", func(t *testing.T) {
+		initInstanceManager()
+		defer initInstanceManager()
+		ready.Store(true)
+		bus := NewMemoryBus()
+		NewCluster("node", bus, &im)
+		bus.Close()
+
+		rr := httptest.NewRecorder()
+		readyHandler(rr, httptest.NewRequest("GET", "/readyz", nil))
+		if status := rr.Code; status != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503, got %v", status)
+		}
+	})
+}
@@ -2,12 +2,20 @@ package main
 
 import (
 	"revolt/game"
+	"time"
 )
 
 // A message, receivable by the server.
 type Message struct {
 	Type    MessageType `json:"type"`
-	Payload interface{} `json:"payload"`
+	Payload Payload     `json:"payload"`
+
+	// Optional client-chosen ID. Commands with an ID are acknowledged, and resending the same
+	// ID is acknowledged again without re-applying the command.
+	RequestId string `json:"requestId,omitempty"`
+	// Optional version of the state the command was issued against. Commands based on an
+	// older state are rejected as stale.
+	BaseVersion int `json:"baseVersion,omitempty"`
 }
 
 // Represents a message type.
@@ -23,11 +31,151 @@ const (
 	ResolveDeathMessage  MessageType = "resolve_death"
 	CommitTurnMessage    MessageType = "commit_turn"
 	EndTurnMessage       MessageType = "end_turn"
+
+	// Marks the sending player as ready or not ready to start.
+	ReadyMessage   MessageType = "ready"
+	UnreadyMessage MessageType = "unready"
+
+	// Lobby management messages, only accepted from the owner while the game is in the lobby.
+	KickPlayerMessage    MessageType = "kick_player"
+	BanPlayerMessage     MessageType = "ban_player"
+	ReorderSeatsMessage  MessageType = "reorder_seats"
+	TransferOwnerMessage MessageType = "transfer_owner"
+
+	// Sends a chat message to the sender's channel.
+	SendChatMessage MessageType = "chat"
+	// Fires a quick reaction at a peer or the pending action.
+	ReactMessage MessageType = "react"
+	// Mutes or unmutes a player's chat. Only accepted from the owner.
+	MutePlayerMessage   MessageType = "mute_player"
+	UnmutePlayerMessage MessageType = "unmute_player"
+
+	// Asks for a full state snapshot, for clients receiving delta updates.
+	ResyncMessage MessageType = "resync"
 )
 
-// Sent to the client on initial connection.
+// Every message type a client can send.
+var MessageTypes = []MessageType{
+	StartGameMessage, AttemptActionMessage, AttemptBlockMessage, ChallengeMessage,
+	ResolveDeathMessage, CommitTurnMessage, EndTurnMessage, ReadyMessage, UnreadyMessage,
+	KickPlayerMessage, BanPlayerMessage, ReorderSeatsMessage, TransferOwnerMessage,
+	SendChatMessage, ReactMessage, MutePlayerMessage, UnmutePlayerMessage, ResyncMessage,
+}
+
+// Whether messages of this type never change the game state. Transient messages aren't checked
+// against the client's state version, and aren't followed by a state broadcast.
+func (t MessageType) Transient() bool {
+	return t == SendChatMessage || t == ReactMessage
+}
+
+// Whether messages of this type are moves in the game, only accepted while it is in progress.
+func (t MessageType) Move() bool {
+	switch t {
+	case AttemptActionMessage, AttemptBlockMessage, ChallengeMessage, ResolveDeathMessage,
+		CommitTurnMessage, EndTurnMessage:
+		return true
+	}
+	return false
+}
+
+// A message sent by the server. State broadcasts are sent as a `ClientStateBroadcast` with
+// the `state` type; every other message is wrapped in this envelope.
+type ServerMessage struct {
+	Type    ServerMessageType `json:"type"`
+	Payload interface{}       `json:"payload"`
+}
+
+// Represents a server message type.
+type ServerMessageType string
+
+const (
+	HelloMessage        ServerMessageType = "hello"
+	StateMessage        ServerMessageType = "state"
+	StateDeltaMessage   ServerMessageType = "state_delta"
+	AnnouncementMessage ServerMessageType = "announcement"
+	AckMessage          ServerMessageType = "ack"
+	// Sent every second while a game counts down to starting, and when a countdown is cancelled.
+	CountdownMessage ServerMessageType = "countdown"
+	// A chat message, and the chat history sent to clients when they join.
+	ChatMessage        ServerMessageType = "chat"
+	ChatHistoryMessage ServerMessageType = "chat_history"
+	// A player's reaction. Reactions aren't part of the game state, so late joiners never see them.
+	ReactionMessage ServerMessageType = "reaction"
+	// Sent to a player removed from the game by its owner, before they are disconnected.
+	KickedMessage ServerMessageType = "kicked"
+
+	// Sent over the matchmaking connection.
+	QueuedMessage     ServerMessageType = "queued"
+	MatchFoundMessage ServerMessageType = "match_found"
+
+	// Sent over a tournament feed.
+	TournamentMessage ServerMessageType = "tournament"
+)
+
+// Encodes a server message for the wire.
+func (m *ServerMessage) Serialise(codec Codec) ([]byte, error) {
+	return codec.Marshal(m)
+}
+
+// The payload type of each inbound message type, or nil if the message takes no payload.
+// Used to generate the protocol schema, so every message type must be listed here.
+var MessagePayloads = map[MessageType]any{
+	StartGameMessage:     nil,
+	AttemptActionMessage: AttemptActionPayload{},
+	AttemptBlockMessage:  AttemptBlockPayload{},
+	ChallengeMessage:     ChallengePayload{},
+	ResolveDeathMessage:  ResolveDeathPayload{},
+	CommitTurnMessage:    nil,
+	EndTurnMessage:       nil,
+	ReadyMessage:         nil,
+	UnreadyMessage:       nil,
+	KickPlayerMessage:    PlayerPayload{},
+	BanPlayerMessage:     PlayerPayload{},
+	ReorderSeatsMessage:  ReorderSeatsPayload{},
+	TransferOwnerMessage: PlayerPayload{},
+	SendChatMessage:      ChatPayload{},
+	ReactMessage:         ReactPayload{},
+	MutePlayerMessage:    PlayerPayload{},
+	UnmutePlayerMessage:  PlayerPayload{},
+	ResyncMessage:        nil,
+}
+
+// The payload type of each outbound message type. State broadcasts aren't wrapped in an
+// envelope, so the state entry describes the whole message.
+var ServerMessagePayloads = map[ServerMessageType]any{
+	HelloMessage:        HelloPayload{},
+	StateMessage:        ClientStateBroadcast{},
+	StateDeltaMessage:   StateDeltaPayload{},
+	AnnouncementMessage: AnnouncementPayload{},
+	AckMessage:          AckPayload{},
+	CountdownMessage:    CountdownPayload{},
+	ChatMessage:         ChatEntry{},
+	ChatHistoryMessage:  ChatHistoryPayload{},
+	ReactionMessage:     ReactionPayload{},
+	KickedMessage:       KickedPayload{},
+	QueuedMessage:       QueuedPayload{},
+	MatchFoundMessage:   MatchFoundPayload{},
+	TournamentMessage:   TournamentSnapshot{},
+}
+
+// Sent in response to a game being created. The creator owns the game, and joins it by
+// connecting with `?token=`.
 type ConnectionResponse struct {
-	Id string `json:"id"`
+	Id         string `json:"id"`
+	InviteCode string `json:"inviteCode"`
+	PlayerId   string `json:"playerId"`
+	Token      string `json:"token"`
+}
+
+// Sent to the client on initial connection.
+type HelloPayload struct {
+	ProtocolVersion    int    `json:"protocolVersion"`
+	MinProtocolVersion int    `json:"minProtocolVersion"`
+	ClientId           string `json:"clientId"`
+	// Authenticates requests made on the client's behalf over HTTP.
+	Token      string     `json:"token"`
+	UpdateMode UpdateMode `json:"updateMode"`
+	Warning    string     `json:"warning,omitempty"`
 }
 
 type RejoinGamePayload struct {
@@ -50,3 +198,87 @@ type ChallengePayload struct {
 type ResolveDeathPayload struct {
 	Card int `json:"card"`
 }
+
+// Names the player a lobby management message applies to.
+type PlayerPayload struct {
+	PlayerId string `json:"playerId"`
+}
+
+type ReorderSeatsPayload struct {
+	// Every player's ID, in the new seat order.
+	Order []string `json:"order"`
+}
+
+type CountdownPayload struct {
+	// Whole seconds until cards are dealt.
+	Remaining int `json:"remaining"`
+	// Set if the countdown was stopped because a player left or stopped being ready.
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+type ChatPayload struct {
+	Text string `json:"text"`
+}
+
+type ChatHistoryPayload struct {
+	// The most recent messages in the channels the client can read, oldest first.
+	Messages []ChatEntry `json:"messages"`
+}
+
+// Aims a reaction at either a peer or the pending action.
+type ReactPayload struct {
+	Reaction      Reaction `json:"reaction"`
+	Target        string   `json:"target,omitempty"`
+	PendingAction bool     `json:"pendingAction,omitempty"`
+}
+
+type ReactionPayload struct {
+	From     string   `json:"from"`
+	Reaction Reaction `json:"reaction"`
+	// The peer reacted to. Reactions to the pending action target the player taking it.
+	Target string `json:"target"`
+	// Set if the reaction was aimed at the pending action.
+	Action    *game.Action `json:"action,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+type KickedPayload struct {
+	// Set if the player can't rejoin.
+	Banned bool `json:"banned"`
+}
+
+type AnnouncementPayload struct {
+	Message string `json:"message"`
+}
+
+// The outcome of a command with a request ID.
+type AckStatus string
+
+const (
+	AckApplied   AckStatus = "applied"
+	AckRejected  AckStatus = "rejected"
+	AckDuplicate AckStatus = "duplicate"
+)
+
+// Acknowledges a command sent with a request ID.
+type AckPayload struct {
+	RequestId string    `json:"requestId"`
+	Status    AckStatus `json:"status"`
+	// The state version after the command was handled.
+	Version int    `json:"version"`
+	Reason  string `json:"reason,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Sent when a player enters the matchmaking queue.
+type QueuedPayload struct {
+	Preferences QueuePreferences `json:"preferences"`
+}
+
+// Sent when the matchmaker has found a game for a player. The player joins it by connecting
+// to the game with `?token=`.
+type MatchFoundPayload struct {
+	GameId   string `json:"gameId"`
+	PlayerId string `json:"playerId"`
+	Token    string `json:"token"`
+}
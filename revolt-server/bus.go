@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// Carries messages between server nodes. A message published to a subject is delivered to every
+// current subscriber of that subject, including subscribers on the publishing node, in the order
+// it was published.
+type Bus interface {
+	Publish(subject string, message []byte) error
+	// Calls `handler` with each message published to `subject` until the returned function is
+	// called. Handlers are called from the bus's own goroutines, so must not block.
+	Subscribe(subject string, handler func(message []byte)) (unsubscribe func(), err error)
+	// Reports whether the bus can currently reach other nodes.
+	Connected() bool
+	Close() error
+}
+
+var ErrBusClosed = errors.New("bus closed")
+
+// Opens the bus at `address`, such as `redis://localhost:6379`.
+func OpenBus(address string) (Bus, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis":
+		return DialRedis(u.Host)
+	default:
+		return nil, fmt.Errorf("unsupported bus: %s", address)
+	}
+}
+
+// A subscription to a subject, identified so it can be removed.
+type subscription struct {
+	id      int
+	handler func([]byte)
+}
+
+// The subscribers to each subject on a bus.
+type subscriptions struct {
+	lock     sync.Mutex
+	nextId   int
+	subjects map[string][]subscription
+}
+
+// Adds a handler for `subject`, reporting whether it is the subject's first.
+func (s *subscriptions) add(subject string, handler func([]byte)) (int, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.subjects == nil {
+		s.subjects = make(map[string][]subscription)
+	}
+	s.nextId++
+	first := len(s.subjects[subject]) == 0
+	s.subjects[subject] = append(s.subjects[subject], subscription{id: s.nextId, handler: handler})
+	return s.nextId, first
+}
+
+// Removes a handler, reporting whether the subject has no handlers left.
+func (s *subscriptions) remove(subject string, id int) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	remaining := []subscription{}
+	for _, sub := range s.subjects[subject] {
+		if sub.id != id {
+			remaining = append(remaining, sub)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(s.subjects, subject)
+		return true
+	}
+	s.subjects[subject] = remaining
+	return false
+}
+
+// Returns every subject with at least one handler.
+func (s *subscriptions) names() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := make([]string, 0, len(s.subjects))
+	for subject := range s.subjects {
+		names = append(names, subject)
+	}
+	return names
+}
+
+// Calls every handler for `subject` with `message`, outside the lock so handlers can subscribe.
+func (s *subscriptions) deliver(subject string, message []byte) {
+	s.lock.Lock()
+	handlers := make([]func([]byte), 0, len(s.subjects[subject]))
+	for _, sub := range s.subjects[subject] {
+		handlers = append(handlers, sub.handler)
+	}
+	s.lock.Unlock()
+	for _, handler := range handlers {
+		handler(message)
+	}
+}
+
+// A bus connecting nodes in the same process, used to run a single node or to test clusters.
+// Messages are delivered on the publisher's goroutine.
+type MemoryBus struct {
+	subscriptions subscriptions
+	closed        atomic.Bool
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+func (b *MemoryBus) Publish(subject string, message []byte) error {
+	if b.closed.Load() {
+		return ErrBusClosed
+	}
+	b.subscriptions.deliver(subject, message)
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(subject string, handler func([]byte)) (func(), error) {
+	if b.closed.Load() {
+		return nil, ErrBusClosed
+	}
+	id, _ := b.subscriptions.add(subject, handler)
+	return func() { b.subscriptions.remove(subject, id) }, nil
+}
+
+func (b *MemoryBus) Connected() bool {
+	return !b.closed.Load()
+}
+
+func (b *MemoryBus) Close() error {
+	b.closed.Store(true)
+	return nil
+}
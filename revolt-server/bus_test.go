@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A stand-in for a Redis server, supporting just the pub/sub commands the Redis bus uses.
+type fakeRedis struct {
+	listener net.Listener
+	lock     sync.Mutex
+	// Subscribed connections for each channel.
+	channels map[string]map[*fakeRedisClient]bool
+	// Every open connection.
+	conns map[net.Conn]bool
+}
+
+type fakeRedisClient struct {
+	lock sync.Mutex
+	conn net.Conn
+}
+
+// Writes a raw reply, which may be pushed while another is being written.
+func (c *fakeRedisClient) write(reply string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.conn.Write([]byte(reply))
+}
+
+func bulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func startFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeRedis{
+		listener: listener,
+		channels: make(map[string]map[*fakeRedisClient]bool),
+		conns:    make(map[net.Conn]bool),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeRedis) Address() string {
+	return s.listener.Addr().String()
+}
+
+// Closes every open connection, as though the server had restarted.
+func (s *fakeRedis) dropConnections() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	client := &fakeRedisClient{conn: conn}
+	reader := newRedisConn(conn)
+	s.lock.Lock()
+	s.conns[conn] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		for _, subscribers := range s.channels {
+			delete(subscribers, client)
+		}
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		value, err := reader.ReadValue()
+		if err != nil {
+			return
+		}
+		args := []string{}
+		for _, arg := range value.([]any) {
+			args = append(args, arg.(string))
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			client.write("+PONG\r\n")
+		case "PUBLISH":
+			s.lock.Lock()
+			subscribers := []*fakeRedisClient{}
+			for subscriber := range s.channels[args[1]] {
+				subscribers = append(subscribers, subscriber)
+			}
+			s.lock.Unlock()
+			for _, subscriber := range subscribers {
+				subscriber.write("*3\r\n" + bulk("message") + bulk(args[1]) + bulk(args[2]))
+			}
+			client.write(":" + strconv.Itoa(len(subscribers)) + "\r\n")
+		case "SUBSCRIBE", "UNSUBSCRIBE":
+			kind := strings.ToLower(args[0])
+			for _, channel := range args[1:] {
+				s.lock.Lock()
+				if s.channels[channel] == nil {
+					s.channels[channel] = make(map[*fakeRedisClient]bool)
+				}
+				if kind == "subscribe" {
+					s.channels[channel][client] = true
+				} else {
+					delete(s.channels[channel], client)
+				}
+				s.lock.Unlock()
+				client.write("*3\r\n" + bulk(kind) + bulk(channel) + ":1\r\n")
+			}
+		default:
+			client.write("-ERR unknown command '" + args[0] + "'\r\n")
+		}
+	}
+}
+
+// Returns functions creating buses which can reach each other, for each bus implementation.
+func busImplementations() map[string]func(t *testing.T) func() Bus {
+	return map[string]func(t *testing.T) func() Bus{
+		"memory": func(t *testing.T) func() Bus {
+			bus := NewMemoryBus()
+			return func() Bus { return bus }
+		},
+		"redis": func(t *testing.T) func() Bus {
+			server := startFakeRedis(t)
+			return func() Bus {
+				bus, err := DialRedis(server.Address())
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { bus.Close() })
+				return bus
+			}
+		},
+	}
+}
+
+func TestBus(t *testing.T) {
+	// Collects messages delivered to a handler.
+	type received struct {
+		lock     sync.Mutex
+		messages []string
+	}
+	collect := func(r *received) func([]byte) {
+		return func(message []byte) {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			r.messages = append(r.messages, string(message))
+		}
+	}
+	waitFor := func(t *testing.T, r *received, expected string) {
+		t.Helper()
+		for range 100 {
+			r.lock.Lock()
+			messages := strings.Join(r.messages, ",")
+			r.lock.Unlock()
+			if messages == expected {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		t.Fatalf("expected messages %q, got %q", expected, strings.Join(r.messages, ","))
+	}
+
+	for name, implementation := range busImplementations() {
+		t.Run(name, func(t *testing.T) {
+			t.Run("should deliver messages in order to every subscriber of a subject", func(t *testing.T) {
+				newBus := implementation(t)
+				publisher, subscriber := newBus(), newBus()
+
+				first, second, other := &received{}, &received{}, &received{}
+				subscriber.Subscribe("subject", collect(first))
+				publisher.Subscribe("subject", collect(second))
+				subscriber.Subscribe("other", collect(other))
+
+				for _, message := range []string{"one", "two", "three"} {
+					if err := publisher.Publish("subject", []byte(message)); err != nil {
+						t.Fatal(err)
+					}
+				}
+				waitFor(t, first, "one,two,three")
+				waitFor(t, second, "one,two,three")
+				waitFor(t, other, "")
+			})
+
+			t.Run("should stop delivering messages once unsubscribed", func(t *testing.T) {
+				newBus := implementation(t)
+				publisher, subscriber := newBus(), newBus()
+
+				kept, removed := &received{}, &received{}
+				subscriber.Subscribe("subject", collect(kept))
+				unsubscribe, err := subscriber.Subscribe("subject", collect(removed))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				publisher.Publish("subject", []byte("one"))
+				waitFor(t, removed, "one")
+				unsubscribe()
+				publisher.Publish("subject", []byte("two"))
+				waitFor(t, kept, "one,two")
+				waitFor(t, removed, "one")
+			})
+
+			t.Run("should deliver binary messages unchanged", func(t *testing.T) {
+				newBus := implementation(t)
+				bus := newBus()
+
+				r := &received{}
+				bus.Subscribe("subject", collect(r))
+				message := "\x00\r\n\xff$3\r\n"
+				bus.Publish("subject", []byte(message))
+				waitFor(t, r, message)
+			})
+
+			t.Run("should refuse messages once closed", func(t *testing.T) {
+				newBus := implementation(t)
+				bus := newBus()
+				bus.Close()
+				if err := bus.Publish("subject", []byte("message")); err != ErrBusClosed {
+					t.Errorf("expected ErrBusClosed, got %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestRedisBus(t *testing.T) {
+	t.Run("should reconnect and resubscribe after losing its connection", func(t *testing.T) {
+		server := startFakeRedis(t)
+		bus, err := DialRedis(server.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer bus.Close()
+
+		received := make(chan string, 10)
+		bus.Subscribe("subject", func(message []byte) { received <- string(message) })
+		reconnected := make(chan struct{}, 1)
+		bus.OnReconnect(func() { reconnected <- struct{}{} })
+		server.dropConnections()
+
+		// Keep publishing until the bus has reconnected and resubscribed.
+		timeout := time.After(5 * time.Second)
+		for {
+			bus.Publish("subject", []byte("message"))
+			select {
+			case <-received:
+				if !bus.Connected() {
+					t.Error("expected the bus to report being connected")
+				}
+				select {
+				case <-reconnected:
+				case <-time.After(time.Second):
+					t.Error("expected the reconnect handler to be called")
+				}
+				return
+			case <-time.After(50 * time.Millisecond):
+			case <-timeout:
+				t.Fatal("expected the bus to reconnect")
+			}
+		}
+	})
+
+	t.Run("should report being disconnected once closed", func(t *testing.T) {
+		server := startFakeRedis(t)
+		bus, err := DialRedis(server.Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bus.Connected() {
+			t.Error("expected the bus to be connected")
+		}
+		bus.Close()
+		if bus.Connected() {
+			t.Error("expected a closed bus to be disconnected")
+		}
+	})
+}
+
+func TestRedisConn(t *testing.T) {
+	t.Run("should read every reply type", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer server.Close()
+		go server.Write([]byte("+OK\r\n:42\r\n$-1\r\n*2\r\n$3\r\nfoo\r\n:1\r\n-ERR broken\r\n"))
+		conn := newRedisConn(client)
+
+		expected := []string{"OK", "42", "<nil>", "[foo 1]"}
+		for _, want := range expected {
+			value, err := conn.ReadValue()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprint(value); got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		}
+		if _, err := conn.ReadValue(); err == nil || !strings.Contains(err.Error(), "broken") {
+			t.Errorf("expected an error reply, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// The longest chat message accepted, in characters.
+	MaxChatLength = 280
+	// The number of messages kept in each channel's history for players who join later.
+	ChatHistoryLimit = 50
+	// Clients can send a burst of `ChatBurst` messages, then one every `ChatInterval`.
+	ChatBurst    = 5
+	ChatInterval = time.Second
+)
+
+// A chat channel. Everyone in a game can read table talk, but only players can write to it.
+// Spectators talk among themselves, so they can't pass information to the players.
+type ChatChannel string
+
+const (
+	TableChannel     ChatChannel = "table"
+	SpectatorChannel ChatChannel = "spectators"
+)
+
+// Every chat channel.
+var ChatChannels = []ChatChannel{TableChannel, SpectatorChannel}
+
+// A single chat message, as sent to clients.
+type ChatEntry struct {
+	Channel   ChatChannel `json:"channel"`
+	From      string      `json:"from"`
+	Name      string      `json:"name"`
+	Text      string      `json:"text"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Checks chat messages before they are sent, returning the text to send or an error if the
+// message should be refused.
+type ChatFilter interface {
+	Filter(text string) (string, error)
+}
+
+// A chat filter which masks listed words, ignoring case.
+type WordFilter struct {
+	pattern *regexp.Regexp
+}
+
+// Creates a filter masking `words`. Filters with no words leave messages unchanged.
+func NewWordFilter(words []string) *WordFilter {
+	quoted := []string{}
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return &WordFilter{}
+	}
+	return &WordFilter{pattern: regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)}
+}
+
+func (f *WordFilter) Filter(text string) (string, error) {
+	if f.pattern == nil {
+		return text, nil
+	}
+	return f.pattern.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	}), nil
+}
+
+// The filter given to new instances, set from the server's configuration.
+var chatFilter ChatFilter = NewWordFilter(nil)
+
+// Sends a chat message from a client to its channel, after checking its length, the client's
+// rate limit and whether the client has been muted. Must be called on the instance goroutine.
+func (gi *GameInstance) chat(client *Client, text string) error {
+	if gi.Muted[client.Id] {
+		return rejectCommand(RejectMuted, "you have been muted by the owner")
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return rejectCommand(RejectInvalidPayload, "chat message is empty")
+	}
+	if utf8.RuneCountInString(text) > MaxChatLength {
+		return rejectCommand(RejectInvalidPayload, "chat messages must be at most %d characters", MaxChatLength)
+	}
+	if !client.chatLimit.Allow() {
+		return rejectCommand(RejectRateLimited, "sending chat messages too quickly")
+	}
+	text, err := gi.ChatFilter.Filter(text)
+	if err != nil {
+		return rejectCommand(RejectInvalidPayload, "chat message refused: %w", err)
+	}
+
+	channel := TableChannel
+	if gi.isSpectator(client) {
+		channel = SpectatorChannel
+	}
+	entry := ChatEntry{
+		Channel:   channel,
+		From:      client.Id,
+		Name:      client.Name,
+		Text:      text,
+		Timestamp: time.Now(),
+	}
+	history := append(gi.chatHistory[channel], entry)
+	if len(history) > ChatHistoryLimit {
+		history = history[len(history)-ChatHistoryLimit:]
+	}
+	gi.chatHistory[channel] = history
+
+	message := ServerMessage{Type: ChatMessage, Payload: entry}
+	for _, spectator := range gi.Spectators {
+		spectator.Send(message)
+	}
+	if channel == TableChannel {
+		for _, player := range gi.Clients {
+			player.Send(message)
+		}
+	}
+	return nil
+}
+
+// Sends a joining client the history of the channels they can read.
+func (gi *GameInstance) sendChatHistory(client *Client) {
+	messages := slices.Clone(gi.chatHistory[TableChannel])
+	if gi.isSpectator(client) {
+		messages = append(messages, gi.chatHistory[SpectatorChannel]...)
+		slices.SortStableFunc(messages, func(a, b ChatEntry) int {
+			return a.Timestamp.Compare(b.Timestamp)
+		})
+	}
+	if len(messages) == 0 {
+		return
+	}
+	client.Send(ServerMessage{Type: ChatHistoryMessage, Payload: ChatHistoryPayload{Messages: messages}})
+}
+
+// Mutes or unmutes a player or spectator. Only the owner can mute, at any point in the game.
+func (gi *GameInstance) setMuted(client *Client, id string, muted bool) error {
+	if client.Id != gi.OwnerId {
+		return rejectCommand(RejectNotOwner, "only the owner can mute players (owned by %s)", gi.OwnerId)
+	}
+	if id == client.Id {
+		return rejectCommand(RejectInvalidMove, "the owner can't mute themselves")
+	}
+	_, player := gi.Game.Players[id]
+	_, spectator := gi.Spectators[id]
+	if !player && !spectator {
+		return rejectCommand(RejectNotInGame, "player with id %s not found", id)
+	}
+	gi.Muted[id] = muted
+	gi.Logger.Info("set muted", "player", id, "muted", muted)
+	return nil
+}
+
+// Whether the client is watching rather than playing.
+func (gi *GameInstance) isSpectator(client *Client) bool {
+	_, ok := gi.Spectators[client.Id]
+	return ok
+}
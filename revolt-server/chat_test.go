@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWordFilter(t *testing.T) {
+	t.Run("should mask listed words regardless of case", func(t *testing.T) {
+		filter := NewWordFilter([]string{"duke", " bad word ", ""})
+		text, err := filter.Filter("The DUKE said a bad word, not dukes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != "The **** said a ********, not dukes" {
+			t.Errorf("unexpected filtered text: %s", text)
+		}
+	})
+
+	t.Run("should leave text unchanged without words", func(t *testing.T) {
+		text, _ := NewWordFilter(nil).Filter("anything")
+		if text != "anything" {
+			t.Errorf("expected text to be unchanged, got %s", text)
+		}
+	})
+}
+
+func TestChat(t *testing.T) {
+	// Creates a game owned by the first of two players, with one spectator.
+	setup := func() (*GameInstance, []*Client, *Client) {
+		i, players := newTestGame(2)
+		return i, players, addTestSpectator(i)
+	}
+
+	t.Run("should send table talk to players and spectators", func(t *testing.T) {
+		i, players, spectator := setup()
+
+		err := sendMessage(i, players[1], SendChatMessage, ChatPayload{Text: "  I have the duke  "})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, client := range []*Client{players[0], players[1], spectator} {
+			entries := received[ChatEntry](client, ChatMessage)
+			if len(entries) != 1 || entries[0].Text != "I have the duke" || entries[0].Channel != TableChannel {
+				t.Errorf("expected %s to receive table talk, got %+v", client.Id, entries)
+			}
+		}
+	})
+
+	t.Run("should keep spectator chat from the players", func(t *testing.T) {
+		i, players, spectator := setup()
+
+		err := sendMessage(i, spectator, SendChatMessage, ChatPayload{Text: "they're bluffing"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entries := received[ChatEntry](spectator, ChatMessage); len(entries) != 1 || entries[0].Channel != SpectatorChannel {
+			t.Errorf("expected spectator chat, got %+v", entries)
+		}
+		for _, player := range players {
+			if entries := received[ChatEntry](player, ChatMessage); len(entries) != 0 {
+				t.Errorf("expected players not to see spectator chat, got %+v", entries)
+			}
+		}
+	})
+
+	t.Run("should reject empty and overlong messages", func(t *testing.T) {
+		i, players, _ := setup()
+
+		if err := sendMessage(i, players[0], SendChatMessage, ChatPayload{Text: "   "}); !rejectedWith(err, RejectInvalidPayload) {
+			t.Errorf("expected empty message to be rejected, got %v", err)
+		}
+		long := strings.Repeat("é", MaxChatLength+1)
+		if err := sendMessage(i, players[0], SendChatMessage, ChatPayload{Text: long}); !rejectedWith(err, RejectInvalidPayload) {
+			t.Errorf("expected overlong message to be rejected, got %v", err)
+		}
+		if err := sendMessage(i, players[0], SendChatMessage, ChatPayload{Text: long[2:]}); err != nil {
+			t.Errorf("expected message at the limit to be sent, got %v", err)
+		}
+	})
+
+	t.Run("should rate limit each client", func(t *testing.T) {
+		i, players, _ := setup()
+
+		for range ChatBurst {
+			if err := sendMessage(i, players[0], SendChatMessage, ChatPayload{Text: "hi"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sendMessage(i, players[0], SendChatMessage, ChatPayload{Text: "hi"}); !rejectedWith(err, RejectRateLimited) {
+			t.Errorf("expected message to be rate limited, got %v", err)
+		}
+		if err := sendMessage(i, players[1], SendChatMessage, ChatPayload{Text: "hi"}); err != nil {
+			t.Errorf("expected other clients not to be limited, got %v", err)
+		}
+	})
+
+	t.Run("should filter messages", func(t *testing.T) {
+		i, players, _ := setup()
+		i.ChatFilter = NewWordFilter([]string{"rude"})
+
+		sendMessage(i, players[0], SendChatMessage, ChatPayload{Text: "how rude"})
+		if entries := received[ChatEntry](players[1], ChatMessage); len(entries) != 1 || entries[0].Text != "how ****" {
+			t.Errorf("expected filtered message, got %+v", entries)
+		}
+	})
+
+	t.Run("should let the owner mute players", func(t *testing.T) {
+		i, players, spectator := setup()
+
+		if err := sendMessage(i, players[1], MutePlayerMessage, PlayerPayload{PlayerId: "0"}); !rejectedWith(err, RejectNotOwner) {
+			t.Errorf("expected only the owner to mute, got %v", err)
+		}
+		for _, id := range []string{"1", "s"} {
+			if err := sendMessage(i, players[0], MutePlayerMessage, PlayerPayload{PlayerId: id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, client := range []*Client{players[1], spectator} {
+			if err := sendMessage(i, client, SendChatMessage, ChatPayload{Text: "hi"}); !rejectedWith(err, RejectMuted) {
+				t.Errorf("expected muted client to be refused, got %v", err)
+			}
+		}
+
+		sendMessage(i, players[0], UnmutePlayerMessage, PlayerPayload{PlayerId: "1"})
+		if err := sendMessage(i, players[1], SendChatMessage, ChatPayload{Text: "hi"}); err != nil {
+			t.Errorf("expected unmuted player to chat, got %v", err)
+		}
+	})
+
+	t.Run("should send chat history to late joiners, spectators and rejoining players", func(t *testing.T) {
+		i := NewGameInstance("")
+		i.Countdown = 0
+		go i.Run()
+		defer i.Stop()
+
+		first := NewClient(nil, "One", slog.Default())
+		i.Join(first)
+		i.Submit(first, Message{Type: SendChatMessage, Payload: NewPayload(ChatPayload{Text: "anyone here?"})})
+
+		readHistory := func(t *testing.T, client *Client) []ChatEntry {
+			t.Helper()
+			for {
+				select {
+				case bytes := <-client.send:
+					var message struct {
+						Type    ServerMessageType  `json:"type"`
+						Payload ChatHistoryPayload `json:"payload"`
+					}
+					json.Unmarshal(bytes, &message)
+					if message.Type == ChatHistoryMessage {
+						return message.Payload.Messages
+					}
+				case <-time.After(time.Second):
+					t.Fatal("expected chat history")
+				}
+			}
+		}
+
+		late := NewClient(nil, "Two", slog.Default())
+		i.Join(late)
+		if history := readHistory(t, late); len(history) != 1 || history[0].Text != "anyone here?" {
+			t.Errorf("expected history for a late joiner, got %+v", history)
+		}
+
+		watcher := NewClient(nil, "Watcher", slog.Default())
+		watcher.Spectator = true
+		i.Join(watcher)
+		i.Submit(watcher, Message{Type: SendChatMessage, Payload: NewPayload(ChatPayload{Text: "hello table"})})
+		readHistory(t, watcher)
+
+		rejoining := NewClient(nil, "One", slog.Default())
+		rejoining.Id = first.Id
+		i.Join(rejoining)
+		if history := readHistory(t, rejoining); len(history) != 1 {
+			t.Errorf("expected a player's history to hold only table talk, got %+v", history)
+		}
+	})
+
+	t.Run("should make clients joining a started game spectators who can only chat", func(t *testing.T) {
+		i := NewGameInstance("")
+		i.Status = InProgress
+		go i.Run()
+		defer i.Stop()
+
+		client := NewClient(nil, "Late", slog.Default())
+		i.Join(client)
+		var version int
+		i.Do(func() {
+			if _, ok := i.Game.Players[client.Id]; ok || !i.isSpectator(client) {
+				t.Error("expected client to join as a spectator")
+			}
+			version = i.Version
+		})
+
+		i.Submit(client, Message{Type: EndTurnMessage, RequestId: "a"})
+		i.Submit(client, Message{Type: SendChatMessage, RequestId: "b", BaseVersion: 99, Payload: NewPayload(ChatPayload{Text: "hi"})})
+		acks := map[string]AckPayload{}
+		for len(acks) < 2 {
+			var message struct {
+				Type    ServerMessageType `json:"type"`
+				Payload AckPayload        `json:"payload"`
+			}
+			json.Unmarshal(<-client.send, &message)
+			if message.Type == AckMessage {
+				acks[message.Payload.RequestId] = message.Payload
+			}
+		}
+		if acks["a"].Reason != RejectNotInGame {
+			t.Errorf("expected spectator's game command to be rejected, got %+v", acks["a"])
+		}
+		// Chat isn't checked against the state version, and doesn't change it.
+		if acks["b"].Status != AckApplied || acks["b"].Version != version {
+			t.Errorf("expected chat to be applied without a broadcast, got %+v", acks["b"])
+		}
+	})
+}
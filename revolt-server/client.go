@@ -1,43 +1,243 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"log/slog"
 	"revolt/game"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// The number of non-state messages that can be queued for a client before new ones are dropped.
+	SendQueueSize = 32
+	// The number of consecutive dropped messages after which a client is disconnected.
+	MaxDroppedMessages = 8
+	// The maximum time allowed to write a single message to a client.
+	WriteTimeout = 10 * time.Second
+)
 
-	"github.com/gorilla/websocket"
+// Global counters for messages which never reached a client.
+var (
+	// Messages dropped because a client's queue was full.
+	droppedMessages atomic.Int64
+	// State broadcasts replaced by a newer state before they were written.
+	coalescedStates atomic.Int64
 )
 
 // Represents the state of a connected client.
 type Client struct {
-	Id         string
-	Name       string
-	Connection *websocket.Conn
-	Send       chan []byte
+	Id        string
+	Name      string
+	Transport Transport
+	Logger    *slog.Logger // Logger carrying the client's ID and game context.
+	// Signed session token proving a request comes from this client, used to reconnect and
+	// to authenticate HTTP requests. Only ever sent to the client itself.
+	Token string
+	// The account the client joined as, if any.
+	Account *PlayerAccount
+	// Set if the client asked to watch the game rather than play.
+	Spectator bool
+	// The IP address the client connected from, if known.
+	Address string
+	// How the client receives state updates. Must be set before the writer is started.
+	UpdateMode UpdateMode
+	// Encodes messages sent to the client. Must be set before the writer is started.
+	Codec Codec
+
+	// Bounded queue of outbound messages.
+	send chan []byte
+
+	// Holds the latest unsent state broadcast. Only the most recent state matters, so
+	// a newer state replaces an older one rather than queueing behind it.
+	stateLock  sync.Mutex
+	state      []byte
+	stateReady chan struct{}
+
+	// Outcomes of the client's most recent requests, used to detect duplicates.
+	// Only accessed on the instance goroutine.
+	requests recentRequests
+
+	// Encodes states as deltas for clients in delta mode. Only used by the writer.
+	encoder deltaEncoder
+	// Set when the client has asked for a full snapshot in place of the next delta.
+	resync atomic.Bool
+
+	// Limit how often the client can send messages, chat and react.
+	messageLimit  *tokenBucket
+	chatLimit     *tokenBucket
+	reactionLimit *tokenBucket
+
+	// Consecutive messages dropped because the queue was full.
+	dropped atomic.Int32
+
+	// Closed when the client is disconnected, stopping its writer.
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func NewClient(conn *websocket.Conn, name string) Client {
-	return Client{
-		Id:         game.Id(),
-		Name:       name,
-		Connection: conn,
-		Send:       make(chan []byte),
+// Creates a client, deriving its logger from `logger` with the client's ID and name attached.
+func NewClient(transport Transport, name string, logger *slog.Logger) *Client {
+	id := game.Id()
+	return &Client{
+		Id:            id,
+		Name:          name,
+		Transport:     transport,
+		Logger:        logger.With("client", id, "name", name),
+		UpdateMode:    FullUpdates,
+		Codec:         jsonCodec,
+		send:          make(chan []byte, SendQueueSize),
+		stateReady:    make(chan struct{}, 1),
+		messageLimit:  newTokenBucket(MessageBurst, MessageRate),
+		chatLimit:     newTokenBucket(ChatBurst, float64(time.Second)/float64(ChatInterval)),
+		reactionLimit: newTokenBucket(ReactionBurst, float64(time.Second)/float64(ReactionInterval)),
+		done:          make(chan struct{}),
 	}
 }
 
+// The number of request IDs remembered per client.
+const RecentRequestLimit = 64
+
+// A bounded record of request IDs and their acknowledgements, forgetting the oldest first.
+type recentRequests struct {
+	acks  map[string]AckPayload
+	order []string
+}
+
+func (r *recentRequests) Get(id string) (AckPayload, bool) {
+	ack, ok := r.acks[id]
+	return ack, ok
+}
+
+func (r *recentRequests) Add(id string, ack AckPayload) {
+	if r.acks == nil {
+		r.acks = make(map[string]AckPayload)
+	}
+	if len(r.order) >= RecentRequestLimit {
+		delete(r.acks, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.acks[id] = ack
+	r.order = append(r.order, id)
+}
+
+// Writes queued messages to the client's transport until the client is closed.
 func (c *Client) HandleMessages() {
-	defer c.Connection.Close()
-	for message := range c.Send {
-		if err := c.Connection.WriteMessage(websocket.TextMessage, message); err != nil {
-			c.Log("error writing message: %s", err)
+	defer c.Transport.Close()
+	for {
+		var message []byte
+		// Queued messages go out before any pending state, so they arrive in the order sent.
+		select {
+		case message = <-c.send:
+		default:
+			select {
+			case <-c.done:
+				c.Logger.Debug("client handler stopped")
+				return
+			case message = <-c.send:
+			case <-c.stateReady:
+				message = c.takeState()
+				if message == nil {
+					continue
+				}
+				if c.UpdateMode == DeltaUpdates {
+					var err error
+					message, err = c.encoder.Encode(c.Codec, message, c.resync.Swap(false))
+					if err != nil {
+						c.Logger.Error("failed to encode state delta", "error", err)
+						continue
+					}
+				}
+			}
+		}
+
+		if err := c.Transport.Write(c.Codec.FrameType(), message); err != nil {
+			c.Logger.Warn("error writing message", "error", err)
+			c.Close()
 			return
 		}
 	}
-	c.Log("client handler stopped")
 }
 
-// Utility function for logging events that happen in the context of a client.
-func (c *Client) Log(format string, v ...any) {
-	message := fmt.Sprintf(format, v...)
-	log.Printf("%s (client: %s)", message, c.Id)
+// Encodes a server message with the client's codec and queues it.
+func (c *Client) Send(message ServerMessage) bool {
+	bytes, err := message.Serialise(c.Codec)
+	if err != nil {
+		c.Logger.Error("failed to serialise message", "type", message.Type, "error", err)
+		return false
+	}
+	return c.Enqueue(bytes)
+}
+
+// Queues a message for the client without blocking. If the queue is full the message is
+// dropped, and a client which keeps falling behind is disconnected.
+// Returns false if the message was not queued.
+func (c *Client) Enqueue(message []byte) bool {
+	if c.Closed() {
+		return false
+	}
+	select {
+	case c.send <- message:
+		c.dropped.Store(0)
+		return true
+	default:
+		droppedMessages.Add(1)
+		if c.dropped.Add(1) >= MaxDroppedMessages {
+			c.Logger.Warn("client fell too far behind, disconnecting")
+			c.Close()
+		} else {
+			c.Logger.Debug("send queue full, dropped message")
+		}
+		return false
+	}
+}
+
+// Sets the state broadcast to be written to the client next, replacing any unsent state.
+func (c *Client) EnqueueState(state []byte) {
+	if c.Closed() {
+		return
+	}
+	c.stateLock.Lock()
+	if c.state != nil {
+		coalescedStates.Add(1)
+	}
+	c.state = state
+	c.stateLock.Unlock()
+
+	// Wake the writer if it isn't already due to send a state.
+	select {
+	case c.stateReady <- struct{}{}:
+	default:
+	}
+}
+
+// Makes the next state update a full snapshot, for clients in delta mode.
+func (c *Client) RequestSnapshot() {
+	c.resync.Store(true)
+}
+
+// Removes and returns the pending state broadcast, if any.
+func (c *Client) takeState() []byte {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	state := c.state
+	c.state = nil
+	return state
+}
+
+// Stops the client's writer. Safe to call more than once, and from any goroutine.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// Reports whether the client has been closed.
+func (c *Client) Closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,438 @@
+/*
+Package client is a Go client for the game's websocket protocol, for bots, tools and tests.
+
+A client joins one game. Commands are sent with typed methods, which wait for the server to
+acknowledge them, and state updates and other events arrive on channels. If the connection
+drops, the client reconnects and resumes its session.
+
+	c, err := client.Dial(ctx, "ws://localhost:8080/ws/ABCDEF", client.Options{Name: "bot"})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	for state := range c.States() {
+		...
+	}
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// The protocol version this client speaks, advertised as a websocket subprotocol.
+	ProtocolVersion = 1
+
+	// How long to wait for the server to accept a connection and say hello.
+	DialTimeout = 10 * time.Second
+	// How long to wait for a command to be written.
+	WriteTimeout = 10 * time.Second
+
+	// The delay before the first reconnection attempt, doubling with each failure.
+	DefaultReconnectDelay = 500 * time.Millisecond
+	// The longest delay between reconnection attempts.
+	MaxReconnectDelay = 10 * time.Second
+	// How many reconnection attempts are made in a row before giving up.
+	DefaultReconnectAttempts = 10
+
+	// How many state updates are held for a slow reader. Once full, the oldest is dropped, as
+	// every update holds the whole state.
+	StateBufferSize = 16
+	// How many events are held for a slow reader. Once full, new events are dropped.
+	EventBufferSize = 64
+)
+
+var (
+	// Returned once the client has been closed.
+	ErrClosed = errors.New("client closed")
+	// Returned for commands which were sent, but not acknowledged before the connection
+	// dropped. They may or may not have been applied.
+	ErrDisconnected = errors.New("disconnected")
+	// Returned once the client's player has been kicked or banned from the game.
+	ErrKicked = errors.New("kicked from the game")
+)
+
+// How to join a game.
+type Options struct {
+	// The name to play under.
+	Name string
+	// The game's password, if it has one.
+	Password string
+	// Watches the game rather than playing it.
+	Spectate bool
+	// Resumes an earlier session, such as the one returned when creating a game.
+	Token string
+	// Joins as an account, with an account token.
+	Account string
+
+	// The delay before the first reconnection attempt. Defaults to DefaultReconnectDelay.
+	ReconnectDelay time.Duration
+	// How many reconnection attempts to make before giving up. Defaults to
+	// DefaultReconnectAttempts, and a negative number disables reconnecting.
+	ReconnectAttempts int
+	// Used to open connections. Defaults to websocket.DefaultDialer.
+	Dialer *websocket.Dialer
+}
+
+// A connection to a game.
+type Client struct {
+	address string
+	options Options
+
+	// Guards the fields below, and writes to the connection.
+	lock sync.Mutex
+	// The current connection, or nil while reconnecting.
+	conn  *websocket.Conn
+	id    string
+	token string
+	state *State
+	// Commands awaiting acknowledgement, by request ID.
+	pending  map[string]chan ack
+	requests int
+	err      error
+
+	states chan State
+	events chan Event
+	// Cancelled when the client is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Closed once the client has stopped.
+	done chan struct{}
+}
+
+// Joins the game at `address`, such as `ws://localhost:8080/ws/ABCDEF`, returning once the
+// server has accepted the connection.
+func Dial(ctx context.Context, address string, options Options) (*Client, error) {
+	if _, err := url.Parse(address); err != nil {
+		return nil, err
+	}
+	if options.ReconnectDelay <= 0 {
+		options.ReconnectDelay = DefaultReconnectDelay
+	}
+	if options.ReconnectAttempts == 0 {
+		options.ReconnectAttempts = DefaultReconnectAttempts
+	}
+	if options.Dialer == nil {
+		options.Dialer = websocket.DefaultDialer
+	}
+
+	c := &Client{
+		address: address,
+		options: options,
+		token:   options.Token,
+		pending: make(map[string]chan ack),
+		states:  make(chan State, StateBufferSize),
+		events:  make(chan Event, EventBufferSize),
+		done:    make(chan struct{}),
+	}
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run(conn)
+	return c, nil
+}
+
+// Delivers every state update. Closed once the client stops.
+func (c *Client) States() <-chan State {
+	return c.states
+}
+
+// Delivers chat, reactions, countdowns and other messages, along with reconnections. Closed
+// once the client stops.
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Returns the latest state, or nil if none has been received yet.
+func (c *Client) State() *State {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+// Returns the ID of the client's player.
+func (c *Client) Id() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.id
+}
+
+// Returns the session token, which resumes the client's session when given as Options.Token.
+func (c *Client) Token() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.token
+}
+
+// Closed once the client has stopped, either because it was closed or because it couldn't
+// reconnect.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Returns why the client stopped, or nil if it is still running.
+func (c *Client) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// Leaves the game and stops the client.
+func (c *Client) Close() error {
+	c.cancel()
+	c.lock.Lock()
+	if c.conn != nil {
+		message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		c.conn.Close()
+	}
+	c.lock.Unlock()
+	<-c.done
+	return nil
+}
+
+// Opens a connection, resuming the session if there is one, and waits for the server's hello.
+func (c *Client) connect(ctx context.Context) (*websocket.Conn, error) {
+	u, _ := url.Parse(c.address)
+	query := u.Query()
+	set := func(key string, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	set("name", c.options.Name)
+	set("password", c.options.Password)
+	set("account", c.options.Account)
+	set("token", c.token)
+	if c.options.Spectate {
+		query.Set("spectate", "true")
+	}
+	u.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(ctx, DialTimeout)
+	defer cancel()
+	dialer := *c.options.Dialer
+	dialer.Subprotocols = []string{"revolt.v" + strconv.Itoa(ProtocolVersion)}
+	conn, res, err := dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		if res != nil {
+			return nil, fmt.Errorf("couldn't connect: %s", res.Status)
+		}
+		return nil, err
+	}
+
+	// The server closes connections it refuses before saying hello.
+	deadline, _ := ctx.Deadline()
+	conn.SetReadDeadline(deadline)
+	var message serverMessage
+	var payload hello
+	_, data, err := conn.ReadMessage()
+	if err == nil {
+		err = json.Unmarshal(data, &message)
+	}
+	if err == nil && message.Type != "hello" {
+		err = fmt.Errorf("expected hello, got %s", message.Type)
+	}
+	if err == nil {
+		err = json.Unmarshal(message.Payload, &payload)
+	}
+	if err != nil {
+		conn.Close()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return nil, fmt.Errorf("connection refused: %s", closeErr.Text)
+		}
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	c.lock.Lock()
+	c.conn = conn
+	c.id = payload.ClientId
+	c.token = payload.Token
+	c.lock.Unlock()
+	return conn, nil
+}
+
+// Reads from the connection until it drops, then reconnects, until the client is closed or
+// runs out of attempts.
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.done)
+	defer close(c.events)
+	defer close(c.states)
+
+	for {
+		err := c.read(conn)
+
+		// Commands in flight can't be acknowledged on another connection.
+		c.lock.Lock()
+		c.conn = nil
+		for id, pending := range c.pending {
+			close(pending)
+			delete(c.pending, id)
+		}
+		c.lock.Unlock()
+
+		if c.ctx.Err() != nil {
+			err = ErrClosed
+		} else if !errors.Is(err, ErrKicked) && !websocket.IsCloseError(err, websocket.CloseProtocolError) {
+			c.emit(Disconnected{Err: err})
+			conn, err = c.reconnect()
+			if err == nil {
+				c.emit(Reconnected{})
+				continue
+			}
+		}
+
+		c.lock.Lock()
+		c.err = err
+		c.lock.Unlock()
+		return
+	}
+}
+
+// Tries to connect again, backing off after each failure.
+func (c *Client) reconnect() (*websocket.Conn, error) {
+	delay := c.options.ReconnectDelay
+	err := ErrDisconnected
+	for range c.options.ReconnectAttempts {
+		select {
+		case <-time.After(delay):
+		case <-c.ctx.Done():
+			return nil, ErrClosed
+		}
+		delay = min(delay*2, MaxReconnectDelay)
+
+		var conn *websocket.Conn
+		conn, err = c.connect(c.ctx)
+		if c.ctx.Err() != nil {
+			if conn != nil {
+				conn.Close()
+			}
+			return nil, ErrClosed
+		}
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("couldn't reconnect: %w", err)
+}
+
+// Handles messages from a connection until it fails.
+func (c *Client) read(conn *websocket.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := c.handle(data); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+}
+
+// Handles a single message from the server.
+func (c *Client) handle(data []byte) error {
+	var message serverMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return fmt.Errorf("couldn't read message: %w", err)
+	}
+
+	var event Event
+	var err error
+	switch message.Type {
+	case "state":
+		var state State
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("couldn't read state: %w", err)
+		}
+		c.lock.Lock()
+		c.state = &state
+		c.lock.Unlock()
+		c.deliver(state)
+		return nil
+
+	case "ack":
+		var payload ack
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			return err
+		}
+		c.lock.Lock()
+		if pending, ok := c.pending[payload.RequestId]; ok {
+			pending <- payload
+			delete(c.pending, payload.RequestId)
+		}
+		c.lock.Unlock()
+		return nil
+
+	case "kicked":
+		event, err = decode[Kicked](message.Payload)
+		if err == nil {
+			c.emit(event)
+			err = ErrKicked
+		}
+		return err
+
+	case "chat":
+		event, err = decode[ChatEntry](message.Payload)
+	case "chat_history":
+		event, err = decode[ChatHistory](message.Payload)
+	case "reaction":
+		event, err = decode[Reaction](message.Payload)
+	case "countdown":
+		event, err = decode[Countdown](message.Payload)
+	case "announcement":
+		event, err = decode[Announcement](message.Payload)
+	default:
+		// Newer servers may send messages this client doesn't know about.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.emit(event)
+	return nil
+}
+
+// Decodes the payload of an event.
+func decode[T Event](payload json.RawMessage) (Event, error) {
+	var event T
+	err := json.Unmarshal(payload, &event)
+	return event, err
+}
+
+// Queues a state update, dropping the oldest queued update if the reader has fallen behind.
+func (c *Client) deliver(state State) {
+	for {
+		select {
+		case c.states <- state:
+			return
+		default:
+		}
+		select {
+		case <-c.states:
+		default:
+		}
+	}
+}
+
+// Queues an event, dropping it if the reader has fallen behind.
+func (c *Client) emit(event Event) {
+	select {
+	case c.events <- event:
+	default:
+	}
+}
@@ -0,0 +1,293 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"revolt/game"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A server connection accepted by a fake server.
+type fakeConn struct {
+	*websocket.Conn
+	query url.Values
+}
+
+// Reads the next command sent by the client.
+func (c *fakeConn) command(t *testing.T) Message {
+	t.Helper()
+	var message Message
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if err := c.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func (c *fakeConn) send(t *testing.T, message string) {
+	t.Helper()
+	if err := c.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Starts a server which says hello to every connection, issuing a new token each time, and
+// passes the connection to the test.
+func fakeServer(t *testing.T) (*httptest.Server, chan *fakeConn) {
+	conns := make(chan *fakeConn, 10)
+	issued := atomic.Int32{}
+	upgrader := websocket.Upgrader{Subprotocols: []string{"revolt.v1"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		token := "token-" + strconv.Itoa(int(issued.Add(1)))
+		conn.WriteMessage(websocket.TextMessage,
+			[]byte(`{"type":"hello","payload":{"protocolVersion":1,"clientId":"alice","token":"`+token+`"}}`))
+		conns <- &fakeConn{conn, r.URL.Query()}
+	}))
+	t.Cleanup(server.Close)
+	return server, conns
+}
+
+func dial(t *testing.T, server *httptest.Server, options Options) *Client {
+	t.Helper()
+	options.ReconnectDelay = 10 * time.Millisecond
+	c, err := Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http")+"/ws/game", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func accept(t *testing.T, conns chan *fakeConn) *fakeConn {
+	t.Helper()
+	select {
+	case conn := <-conns:
+		return conn
+	case <-time.After(time.Second):
+		t.Fatal("expected the client to connect")
+		return nil
+	}
+}
+
+func nextEvent(t *testing.T, c *Client) Event {
+	t.Helper()
+	select {
+	case event := <-c.Events():
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+		return nil
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Run("should join with the given options", func(t *testing.T) {
+		server, conns := fakeServer(t)
+		c := dial(t, server, Options{Name: "Alice", Password: "secret", Token: "resume"})
+		conn := accept(t, conns)
+
+		if conn.query.Get("name") != "Alice" || conn.query.Get("password") != "secret" || conn.query.Get("token") != "resume" {
+			t.Errorf("expected options in the query, got %v", conn.query)
+		}
+		if c.Id() != "alice" || c.Token() != "token-1" {
+			t.Errorf("expected the session from the hello, got %s %s", c.Id(), c.Token())
+		}
+	})
+
+	t.Run("should wait for commands to be acknowledged", func(t *testing.T) {
+		server, conns := fakeServer(t)
+		c := dial(t, server, Options{})
+		conn := accept(t, conns)
+
+		result := make(chan error)
+		go func() {
+			result <- c.AttemptAction(context.Background(), game.Action{Type: game.Steal, TargetPlayer: "bob"})
+		}()
+		command := conn.command(t)
+		payload := command.Payload.(map[string]any)["action"].(map[string]any)
+		if command.Type != AttemptActionMessage || payload["type"] != "steal" || payload["target"] != "bob" {
+			t.Errorf("expected a steal from bob, got %+v", command)
+		}
+		conn.send(t, `{"type":"ack","payload":{"requestId":"`+command.RequestId+`","status":"applied"}}`)
+		if err := <-result; err != nil {
+			t.Errorf("expected the command to be applied, got %v", err)
+		}
+
+		go func() { result <- c.EndTurn(context.Background()) }()
+		command = conn.command(t)
+		conn.send(t, `{"type":"ack","payload":{"requestId":"`+command.RequestId+
+			`","status":"rejected","reason":"invalid_move","error":"turn not finished"}}`)
+		var commandErr *CommandError
+		if err := <-result; !errors.As(err, &commandErr) || commandErr.Reason != "invalid_move" {
+			t.Errorf("expected the command to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("should deliver states and events", func(t *testing.T) {
+		server, conns := fakeServer(t)
+		c := dial(t, server, Options{})
+		conn := accept(t, conns)
+
+		conn.send(t, `{"type":"state","version":2,"status":"in_progress","turnState":"action_pending",
+			"self":{"id":"alice","name":"Alice","credits":3},"peers":[{"id":"bob","name":"Bob","leading":true}]}`)
+		conn.send(t, `{"type":"chat","payload":{"from":"bob","name":"Bob","text":"hi"}}`)
+		conn.send(t, `{"type":"mystery","payload":{}}`)
+		conn.send(t, `{"type":"countdown","payload":{"remaining":3}}`)
+
+		select {
+		case state := <-c.States():
+			if leader, _ := state.Leader(); state.Version != 2 || state.TurnState != game.ActionPending || leader.Name != "Bob" {
+				t.Errorf("unexpected state %+v", state)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected a state")
+		}
+		if c.State() == nil || c.State().Self.Credits != 3 {
+			t.Error("expected the latest state to be kept")
+		}
+		if event, ok := nextEvent(t, c).(ChatEntry); !ok || event.Text != "hi" {
+			t.Errorf("expected a chat message, got %#v", event)
+		}
+		if event, ok := nextEvent(t, c).(Countdown); !ok || event.Remaining != 3 {
+			t.Errorf("expected a countdown, got %#v", event)
+		}
+	})
+
+	t.Run("should reconnect and resume the session", func(t *testing.T) {
+		server, conns := fakeServer(t)
+		c := dial(t, server, Options{Name: "Alice", Token: "original"})
+		conn := accept(t, conns)
+
+		result := make(chan error)
+		go func() { result <- c.CommitTurn(context.Background()) }()
+		conn.command(t)
+		conn.Close()
+
+		if err := <-result; !errors.Is(err, ErrDisconnected) {
+			t.Errorf("expected the command to fail, got %v", err)
+		}
+		if _, ok := nextEvent(t, c).(Disconnected); !ok {
+			t.Error("expected a disconnected event")
+		}
+		conn = accept(t, conns)
+		if conn.query.Get("token") != "token-1" {
+			t.Errorf("expected the latest token to be used, got %s", conn.query.Get("token"))
+		}
+		if _, ok := nextEvent(t, c).(Reconnected); !ok {
+			t.Error("expected a reconnected event")
+		}
+
+		go func() { result <- c.Ready(context.Background()) }()
+		command := conn.command(t)
+		conn.send(t, `{"type":"ack","payload":{"requestId":"`+command.RequestId+`","status":"applied"}}`)
+		if err := <-result; err != nil {
+			t.Errorf("expected commands to work after reconnecting, got %v", err)
+		}
+	})
+
+	t.Run("should stop once it can't reconnect", func(t *testing.T) {
+		server, conns := fakeServer(t)
+		c := dial(t, server, Options{ReconnectAttempts: 2})
+		conn := accept(t, conns)
+		server.Close()
+		conn.Close()
+
+		select {
+		case <-c.Done():
+		case <-time.After(time.Second):
+			t.Fatal("expected the client to stop")
+		}
+		if c.Err() == nil || errors.Is(c.Err(), ErrClosed) {
+			t.Errorf("expected a reconnection error, got %v", c.Err())
+		}
+		if err := c.Ready(context.Background()); !errors.Is(err, ErrDisconnected) {
+			t.Errorf("expected commands to fail, got %v", err)
+		}
+	})
+
+	t.Run("should not reconnect once kicked", func(t *testing.T) {
+		server, conns := fakeServer(t)
+		c := dial(t, server, Options{})
+		conn := accept(t, conns)
+		conn.send(t, `{"type":"kicked","payload":{"banned":true}}`)
+
+		if event, ok := nextEvent(t, c).(Kicked); !ok || !event.Banned {
+			t.Errorf("expected a kicked event, got %#v", event)
+		}
+		<-c.Done()
+		if !errors.Is(c.Err(), ErrKicked) {
+			t.Errorf("expected the client to stop as kicked, got %v", c.Err())
+		}
+		select {
+		case <-conns:
+			t.Error("expected the client not to reconnect")
+		default:
+		}
+	})
+
+	t.Run("should report why a connection was refused", func(t *testing.T) {
+		upgrader := websocket.Upgrader{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, _ := upgrader.Upgrade(w, r, nil)
+			conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "instance not found"))
+			conn.Close()
+		}))
+		defer server.Close()
+
+		_, err := Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http")+"/ws/game", Options{})
+		if err == nil || !strings.HasSuffix(err.Error(), ": instance not found") {
+			t.Errorf("expected the server's reason, got %v", err)
+		}
+	})
+
+	t.Run("should close cleanly", func(t *testing.T) {
+		server, conns := fakeServer(t)
+		c := dial(t, server, Options{})
+		conn := accept(t, conns)
+		c.Close()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err := conn.ReadMessage()
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Errorf("expected a normal closure, got %v", err)
+		}
+		if _, ok := <-c.States(); ok {
+			t.Error("expected the states channel to be closed")
+		}
+		if err := c.Ready(context.Background()); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected commands to fail, got %v", err)
+		}
+	})
+}
+
+func TestGameURL(t *testing.T) {
+	t.Run("should build websocket addresses", func(t *testing.T) {
+		cases := map[string]string{
+			"http://localhost:8080":         "ws://localhost:8080/ws/ABCDEF",
+			"https://revolt.example/":       "wss://revolt.example/ws/ABCDEF",
+			"https://revolt.example/revolt": "wss://revolt.example/revolt/ws/ABCDEF",
+		}
+		for server, expected := range cases {
+			if actual, err := GameURL(server, "ABCDEF"); err != nil || actual != expected {
+				t.Errorf("expected %s, got %s %v", expected, actual, err)
+			}
+		}
+		if _, err := GameURL("ftp://revolt.example", "ABCDEF"); err == nil {
+			t.Error("expected unsupported schemes to be refused")
+		}
+	})
+}
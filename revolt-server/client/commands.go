@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"revolt/game"
+	"strconv"
+	"time"
+)
+
+// Returned when the server rejects a command.
+type CommandError struct {
+	Type MessageType
+	// Why the command was rejected, such as `invalid_move` or `not_owner`.
+	Reason string
+	// The server's description of the problem.
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return string(e.Type) + " rejected: " + e.Message
+}
+
+// Sends a command and waits for the server to acknowledge it. Returns a *CommandError if the
+// server rejects it, or ErrDisconnected if the connection dropped before it was acknowledged.
+func (c *Client) Send(ctx context.Context, message Message) error {
+	if c.ctx.Err() != nil {
+		return ErrClosed
+	}
+	c.lock.Lock()
+	if c.conn == nil {
+		c.lock.Unlock()
+		return ErrDisconnected
+	}
+	c.requests++
+	message.RequestId = strconv.Itoa(c.requests)
+	acknowledged := make(chan ack, 1)
+	c.pending[message.RequestId] = acknowledged
+	c.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	err := c.conn.WriteJSON(message)
+	if err != nil {
+		delete(c.pending, message.RequestId)
+	}
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case result, ok := <-acknowledged:
+		if !ok {
+			return ErrDisconnected
+		}
+		if result.Status == "rejected" {
+			return &CommandError{Type: message.Type, Reason: result.Reason, Message: result.Error}
+		}
+		return nil
+	case <-ctx.Done():
+		c.lock.Lock()
+		delete(c.pending, message.RequestId)
+		c.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Starts the game. Only the owner can start a game, once at least two players are ready. Players
+// who aren't ready watch the game as spectators.
+func (c *Client) StartGame(ctx context.Context) error {
+	return c.Send(ctx, Message{Type: StartGameMessage})
+}
+
+// Marks the client's player as ready to start.
+func (c *Client) Ready(ctx context.Context) error {
+	return c.Send(ctx, Message{Type: ReadyMessage})
+}
+
+// Marks the client's player as not ready to start.
+func (c *Client) Unready(ctx context.Context) error {
+	return c.Send(ctx, Message{Type: UnreadyMessage})
+}
+
+// Takes an action on the client's turn. Actions against another player name them as the
+// action's target.
+func (c *Client) AttemptAction(ctx context.Context, action game.Action) error {
+	return c.Send(ctx, Message{Type: AttemptActionMessage, Payload: map[string]any{"action": action}})
+}
+
+// Blocks the pending action, claiming to hold `card`.
+func (c *Client) AttemptBlock(ctx context.Context, card game.Card) error {
+	block := game.Block{Card: card}
+	return c.Send(ctx, Message{Type: AttemptBlockMessage, Payload: map[string]any{"block": block}})
+}
+
+// Challenges the pending action or block.
+func (c *Client) Challenge(ctx context.Context) error {
+	challenge := game.Challenge{}
+	return c.Send(ctx, Message{Type: ChallengeMessage, Payload: map[string]any{"challenge": challenge}})
+}
+
+// Loses the card at index `card` of the client's hand, after losing a challenge or being
+// targeted by an assassination or revolt.
+func (c *Client) ResolveDeath(ctx context.Context, card int) error {
+	return c.Send(ctx, Message{Type: ResolveDeathMessage, Payload: map[string]any{"card": card}})
+}
+
+// Lets the pending action or block go ahead unchallenged.
+func (c *Client) CommitTurn(ctx context.Context) error {
+	return c.Send(ctx, Message{Type: CommitTurnMessage})
+}
+
+// Ends a finished turn, passing play to the next player.
+func (c *Client) EndTurn(ctx context.Context) error {
+	return c.Send(ctx, Message{Type: EndTurnMessage})
+}
+
+// Sends a chat message to the client's channel.
+func (c *Client) Chat(ctx context.Context, text string) error {
+	return c.Send(ctx, Message{Type: ChatMessage, Payload: map[string]any{"text": text}})
+}
+
+// Reacts to a peer, or to the pending action if `target` is empty.
+func (c *Client) React(ctx context.Context, reaction string, target string) error {
+	payload := map[string]any{"reaction": reaction}
+	if target != "" {
+		payload["target"] = target
+	} else {
+		payload["pendingAction"] = true
+	}
+	return c.Send(ctx, Message{Type: ReactMessage, Payload: payload})
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A game created with CreateGame. Its creator owns it, and joins it with Token.
+type CreatedGame struct {
+	Id         string `json:"id"`
+	InviteCode string `json:"inviteCode"`
+	PlayerId   string `json:"playerId"`
+	Token      string `json:"token"`
+}
+
+// Creates a game on the server at `server`, such as `http://localhost:8080`. New players must
+// give `password` to join, if it is set.
+func CreateGame(ctx context.Context, server string, password string) (CreatedGame, error) {
+	body, _ := json.Marshal(map[string]string{"password": password})
+	address := strings.TrimSuffix(server, "/") + "/api/create"
+	req, err := http.NewRequestWithContext(ctx, "POST", address, bytes.NewReader(body))
+	if err != nil {
+		return CreatedGame{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return CreatedGame{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return CreatedGame{}, fmt.Errorf("couldn't create game: %s", res.Status)
+	}
+
+	var created CreatedGame
+	err = json.NewDecoder(res.Body).Decode(&created)
+	return created, err
+}
+
+// Returns the websocket address of a game, by ID or invite code, on the server at `server`.
+func GameURL(server string, game string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "ws":
+		u.Scheme = "ws"
+	case "https", "wss":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("unsupported server address %s", server)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ws/" + url.PathEscape(game)
+	return u.String(), nil
+}
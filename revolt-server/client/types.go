@@ -0,0 +1,192 @@
+package client
+
+import (
+	"encoding/json"
+	"revolt/game"
+	"time"
+)
+
+// Represents a message type sent to the server.
+type MessageType string
+
+const (
+	StartGameMessage     MessageType = "start_game"
+	AttemptActionMessage MessageType = "attempt_action"
+	AttemptBlockMessage  MessageType = "attempt_block"
+	ChallengeMessage     MessageType = "challenge"
+	ResolveDeathMessage  MessageType = "resolve_death"
+	CommitTurnMessage    MessageType = "commit_turn"
+	EndTurnMessage       MessageType = "end_turn"
+	ReadyMessage         MessageType = "ready"
+	UnreadyMessage       MessageType = "unready"
+	KickPlayerMessage    MessageType = "kick_player"
+	BanPlayerMessage     MessageType = "ban_player"
+	ReorderSeatsMessage  MessageType = "reorder_seats"
+	TransferOwnerMessage MessageType = "transfer_owner"
+	ChatMessage          MessageType = "chat"
+	ReactMessage         MessageType = "react"
+	MutePlayerMessage    MessageType = "mute_player"
+	UnmutePlayerMessage  MessageType = "unmute_player"
+)
+
+// A command sent to the server.
+type Message struct {
+	Type    MessageType `json:"type"`
+	Payload any         `json:"payload,omitempty"`
+	// Set by the client, to match the command with its acknowledgement.
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// Any message received from the server. State broadcasts aren't wrapped in an envelope, so
+// they are decoded as a whole instead.
+type serverMessage struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// The game as seen by this client, sent whenever it changes.
+type State struct {
+	// Increases with every update.
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+
+	GameId     string `json:"gameId"`
+	InviteCode string `json:"inviteCode"`
+	OwnerId    string `json:"ownerId"`
+	// The client's own player, holding its cards. Empty for spectators.
+	Self Peer `json:"self"`
+	// The other players in seat order, holding only the cards they have lost.
+	Peers []Peer `json:"peers"`
+	// One of `lobby`, `in_progress` or `complete`.
+	Status     string `json:"status"`
+	Spectator  bool   `json:"spectator"`
+	Spectators int    `json:"spectators"`
+	// When cards will be dealt, while the game counts down to starting.
+	StartsAt *time.Time `json:"startsAt,omitempty"`
+
+	TurnState        game.TurnState `json:"turnState"`
+	NextDeath        string         `json:"nextDeath"`
+	Winner           string         `json:"winner"`
+	PendingAction    game.Action    `json:"pendingAction"`
+	PendingBlock     game.Block     `json:"pendingBlock"`
+	PendingChallenge game.Challenge `json:"pendingChallenge"`
+}
+
+type Peer struct {
+	Name    string           `json:"name"`
+	Id      string           `json:"id"`
+	Cards   []game.CardState `json:"cards"`
+	Credits int              `json:"credits"`
+	Leading bool             `json:"leading"`
+	Ready   bool             `json:"ready"`
+	// The actions the player's cards allow. Only sent for the client's own player.
+	AllowedActions []game.ActionType `json:"allowedActions"`
+	Account        *PlayerAccount    `json:"account,omitempty"`
+	Muted          bool              `json:"muted,omitempty"`
+}
+
+type PlayerAccount struct {
+	Id     string `json:"id"`
+	Avatar string `json:"avatar"`
+}
+
+// Looks up a player's name from their ID, returning the ID if they aren't in the game.
+func (s *State) Name(id string) string {
+	if id == s.Self.Id && id != "" {
+		return s.Self.Name
+	}
+	for _, peer := range s.Peers {
+		if peer.Id == id {
+			return peer.Name
+		}
+	}
+	return id
+}
+
+// Returns the player whose turn it is.
+func (s *State) Leader() (Peer, bool) {
+	if s.Self.Leading {
+		return s.Self, true
+	}
+	for _, peer := range s.Peers {
+		if peer.Leading {
+			return peer, true
+		}
+	}
+	return Peer{}, false
+}
+
+// Something other than a state update, delivered on the client's events channel.
+type Event interface {
+	event()
+}
+
+// A chat message.
+type ChatEntry struct {
+	// Either `table` or `spectators`.
+	Channel   string    `json:"channel"`
+	From      string    `json:"from"`
+	Name      string    `json:"name"`
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// The recent chat, sent whenever the client joins or rejoins the game.
+type ChatHistory struct {
+	Messages []ChatEntry `json:"messages"`
+}
+
+// A reaction aimed at a peer, or at the pending action.
+type Reaction struct {
+	From     string       `json:"from"`
+	Reaction string       `json:"reaction"`
+	Target   string       `json:"target"`
+	Action   *game.Action `json:"action,omitempty"`
+}
+
+// Sent every second while the game counts down to starting.
+type Countdown struct {
+	Remaining int  `json:"remaining"`
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// A message from the server's operators.
+type Announcement struct {
+	Message string `json:"message"`
+}
+
+// Sent when the owner removes the client's player. The client doesn't reconnect afterwards.
+type Kicked struct {
+	Banned bool `json:"banned"`
+}
+
+// Sent when the connection drops, before the client tries to reconnect.
+type Disconnected struct {
+	Err error
+}
+
+// Sent once the client has reconnected and resumed its session.
+type Reconnected struct{}
+
+func (ChatEntry) event()    {}
+func (ChatHistory) event()  {}
+func (Reaction) event()     {}
+func (Countdown) event()    {}
+func (Announcement) event() {}
+func (Kicked) event()       {}
+func (Disconnected) event() {}
+func (Reconnected) event()  {}
+
+type hello struct {
+	ProtocolVersion int    `json:"protocolVersion"`
+	ClientId        string `json:"clientId"`
+	Token           string `json:"token"`
+	Warning         string `json:"warning,omitempty"`
+}
+
+type ack struct {
+	RequestId string `json:"requestId"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestEnqueue(t *testing.T) {
+	t.Run("should drop messages once the queue is full", func(t *testing.T) {
+		c := NewClient(nil, "Test", slog.Default())
+		before := droppedMessages.Load()
+
+		for range SendQueueSize {
+			if !c.Enqueue([]byte("message")) {
+				t.Fatal("expected message to be queued")
+			}
+		}
+		if c.Enqueue([]byte("message")) {
+			t.Error("expected message to be dropped")
+		}
+
+		if dropped := droppedMessages.Load() - before; dropped != 1 {
+			t.Errorf("expected 1 dropped message, got %d", dropped)
+		}
+	})
+
+	t.Run("should disconnect clients that fall too far behind", func(t *testing.T) {
+		c := NewClient(nil, "Test", slog.Default())
+
+		for range SendQueueSize + MaxDroppedMessages {
+			c.Enqueue([]byte("message"))
+		}
+
+		if !c.Closed() {
+			t.Error("expected client to be closed")
+		}
+	})
+
+	t.Run("should not queue messages for closed clients", func(t *testing.T) {
+		c := NewClient(nil, "Test", slog.Default())
+		c.Close()
+		c.Close()
+
+		if c.Enqueue([]byte("message")) {
+			t.Error("expected message not to be queued")
+		}
+		c.EnqueueState([]byte("state"))
+	})
+}
+
+func TestEnqueueState(t *testing.T) {
+	t.Run("should only keep the latest state", func(t *testing.T) {
+		c := NewClient(nil, "Test", slog.Default())
+		before := coalescedStates.Load()
+
+		c.EnqueueState([]byte("first"))
+		c.EnqueueState([]byte("second"))
+
+		if state := c.takeState(); !bytes.Equal(state, []byte("second")) {
+			t.Errorf("expected latest state, got %s", state)
+		}
+		if state := c.takeState(); state != nil {
+			t.Errorf("expected no pending state, got %s", state)
+		}
+		if coalesced := coalescedStates.Load() - before; coalesced != 1 {
+			t.Errorf("expected 1 coalesced state, got %d", coalesced)
+		}
+	})
+}
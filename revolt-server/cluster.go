@@ -0,0 +1,499 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"revolt/game"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Subject on which nodes announce the instances they own.
+	directorySubject = "revolt.directory"
+	// Prefix of the subject each node receives proxied connection traffic on.
+	nodeSubjectPrefix = "revolt.node."
+	// The number of frames queued for a proxied connection before it is disconnected.
+	ProxyQueueSize = 2 * SendQueueSize
+)
+
+type clusterMessageType string
+
+const (
+	// Sent to every node when a node creates or deletes an instance.
+	announceMessage clusterMessageType = "announce"
+	withdrawMessage clusterMessageType = "withdraw"
+	// Sent by a starting node, asking every other node to announce its instances.
+	syncMessage clusterMessageType = "sync"
+	// Sent by a node which is shutting down.
+	nodeDownMessage clusterMessageType = "node_down"
+
+	// Sent to the owning node for connections proxied from another node.
+	joinMessage    clusterMessageType = "join"
+	commandMessage clusterMessageType = "command"
+	leaveMessage   clusterMessageType = "leave"
+	// Sent back to the node holding a proxied connection.
+	deliverMessage clusterMessageType = "deliver"
+	closeMessage   clusterMessageType = "close"
+)
+
+// A message between nodes.
+type clusterMessage struct {
+	Type clusterMessageType `json:"type"`
+	// The sending node.
+	Node string `json:"node"`
+	// The proxied connection the message is about.
+	Conn     string `json:"conn,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code,omitempty"`
+	// The request a proxied connection was opened with.
+	URL       string `json:"url,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Protocols string `json:"protocols,omitempty"`
+	// A frame read from or to be written to a proxied connection.
+	FrameType int    `json:"frameType,omitempty"`
+	Data      []byte `json:"data,omitempty"`
+	// Why a proxied connection was refused.
+	Error string `json:"error,omitempty"`
+}
+
+// Connects this node to other nodes over a bus. Each instance is owned by the node which
+// created it. Websockets opened on any other node are proxied to the owner, which runs the
+// instance as though the client had connected directly: commands are forwarded to the owner,
+// and everything the owner sends is passed back.
+type Cluster struct {
+	NodeId    string
+	bus       Bus
+	instances *InstanceManager
+
+	lock sync.Mutex
+	// The nodes owning instances elsewhere in the cluster, keyed by instance ID.
+	owners map[string]string
+	// The IDs of instances elsewhere in the cluster, keyed by invite code.
+	codes map[string]string
+	// Connections on this node to instances on other nodes, keyed by connection ID.
+	proxies map[string]*proxyConn
+	// Connections on other nodes to instances on this one, keyed by connection ID.
+	remotes     map[string]*remoteConn
+	unsubscribe []func()
+}
+
+// Creates a node owning the instances in `instances`. The node doesn't receive anything
+// until it is started.
+func NewCluster(nodeId string, bus Bus, instances *InstanceManager) *Cluster {
+	c := &Cluster{
+		NodeId:    nodeId,
+		bus:       bus,
+		instances: instances,
+		owners:    make(map[string]string),
+		codes:     make(map[string]string),
+		proxies:   make(map[string]*proxyConn),
+		remotes:   make(map[string]*remoteConn),
+	}
+	instances.cluster = c
+	return c
+}
+
+// Subscribes to the bus, then learns which instances the other nodes own and tells them about
+// this node's.
+func (c *Cluster) Start() error {
+	for subject, handler := range map[string]func([]byte){
+		directorySubject:             handleClusterMessage(c.handleDirectory),
+		nodeSubjectPrefix + c.NodeId: handleClusterMessage(c.handleNodeMessage),
+	} {
+		unsubscribe, err := c.bus.Subscribe(subject, handler)
+		if err != nil {
+			return err
+		}
+		c.unsubscribe = append(c.unsubscribe, unsubscribe)
+	}
+	// Announcements are lost while a node is disconnected, so it syncs again on reconnecting.
+	if bus, ok := c.bus.(interface{ OnReconnect(func()) }); ok {
+		bus.OnReconnect(func() {
+			if err := c.sync(); err != nil {
+				slog.Warn("couldn't resync cluster", "error", err)
+			}
+		})
+	}
+	slog.Info("joined cluster", "node", c.NodeId)
+	return c.sync()
+}
+
+// Tells the other nodes about this node's instances, and asks them about theirs.
+func (c *Cluster) sync() error {
+	c.announceAll()
+	return c.broadcast(clusterMessage{Type: syncMessage})
+}
+
+// Tells the other nodes this node is going away, and disconnects everything proxied through it.
+func (c *Cluster) Close() {
+	c.broadcast(clusterMessage{Type: nodeDownMessage})
+	for _, unsubscribe := range c.unsubscribe {
+		unsubscribe()
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, proxy := range c.proxies {
+		proxy.conn.Close()
+	}
+	for _, remote := range c.remotes {
+		remote.Leave()
+	}
+}
+
+// Decodes messages before passing them to `handle`.
+func handleClusterMessage(handle func(clusterMessage)) func([]byte) {
+	return func(bytes []byte) {
+		var message clusterMessage
+		if err := json.Unmarshal(bytes, &message); err != nil {
+			slog.Warn("invalid cluster message", "error", err)
+			return
+		}
+		handle(message)
+	}
+}
+
+// Sends a message to every node.
+func (c *Cluster) broadcast(message clusterMessage) error {
+	return c.publish(directorySubject, message)
+}
+
+// Sends a message to a single node.
+func (c *Cluster) send(node string, message clusterMessage) error {
+	return c.publish(nodeSubjectPrefix+node, message)
+}
+
+func (c *Cluster) publish(subject string, message clusterMessage) error {
+	message.Node = c.NodeId
+	bytes, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return c.bus.Publish(subject, bytes)
+}
+
+// Tells the other nodes this node owns an instance. Safe to call on a nil cluster.
+func (c *Cluster) Announce(instance *GameInstance) {
+	if c == nil {
+		return
+	}
+	c.broadcast(clusterMessage{Type: announceMessage, Instance: instance.GameId, Code: instance.InviteCode})
+}
+
+// Tells the other nodes an instance has gone. Safe to call on a nil cluster.
+func (c *Cluster) Withdraw(instance *GameInstance) {
+	if c == nil {
+		return
+	}
+	c.broadcast(clusterMessage{Type: withdrawMessage, Instance: instance.GameId, Code: instance.InviteCode})
+}
+
+func (c *Cluster) announceAll() {
+	for _, instance := range c.instances.ListInstances() {
+		c.Announce(instance)
+	}
+}
+
+// Finds the node owning an instance elsewhere in the cluster by the instance's ID or invite
+// code, returning the node and the instance's ID. Safe to call on a nil cluster.
+func (c *Cluster) Locate(key string) (string, string, bool) {
+	if c == nil {
+		return "", "", false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if node, ok := c.owners[key]; ok {
+		return node, key, true
+	}
+	id, ok := c.codes[normaliseInviteCode(key)]
+	if !ok {
+		return "", "", false
+	}
+	return c.owners[id], id, true
+}
+
+// Reports whether the node can reach the rest of the cluster. A node outside a cluster is always
+// connected. Safe to call on a nil cluster.
+func (c *Cluster) Connected() bool {
+	return c == nil || c.bus.Connected()
+}
+
+// Reports whether an instance elsewhere in the cluster uses an invite code.
+// Safe to call on a nil cluster.
+func (c *Cluster) HasCode(code string) bool {
+	if c == nil {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, ok := c.codes[code]
+	return ok
+}
+
+// Keeps track of which node owns each instance.
+func (c *Cluster) handleDirectory(message clusterMessage) {
+	if message.Node == c.NodeId {
+		return
+	}
+	switch message.Type {
+	case announceMessage:
+		c.lock.Lock()
+		c.owners[message.Instance] = message.Node
+		c.codes[message.Code] = message.Instance
+		c.lock.Unlock()
+	case withdrawMessage:
+		c.lock.Lock()
+		delete(c.owners, message.Instance)
+		delete(c.codes, message.Code)
+		c.lock.Unlock()
+	case syncMessage:
+		// Announced from another goroutine, as handlers mustn't block the bus.
+		go c.announceAll()
+	case nodeDownMessage:
+		slog.Info("node left cluster", "node", message.Node)
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		for id, node := range c.owners {
+			if node == message.Node {
+				delete(c.owners, id)
+			}
+		}
+		for code, id := range c.codes {
+			if _, ok := c.owners[id]; !ok {
+				delete(c.codes, code)
+			}
+		}
+		for _, proxy := range c.proxies {
+			if proxy.node == message.Node {
+				proxy.conn.Close()
+			}
+		}
+		for _, remote := range c.remotes {
+			if remote.node == message.Node {
+				remote.Leave()
+			}
+		}
+	}
+}
+
+// Routes proxied connection traffic to the connection it belongs to.
+func (c *Cluster) handleNodeMessage(message clusterMessage) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch message.Type {
+	case joinMessage:
+		remote := &remoteConn{
+			id:       message.Conn,
+			node:     message.Node,
+			commands: make(chan clusterMessage, ProxyQueueSize),
+			left:     make(chan struct{}),
+		}
+		c.remotes[remote.id] = remote
+		go c.serveRemote(remote, message)
+	case commandMessage:
+		if remote, ok := c.remotes[message.Conn]; ok {
+			select {
+			case remote.commands <- message:
+			default:
+				slog.Warn("dropped command from proxied connection", "conn", message.Conn)
+			}
+		}
+	case leaveMessage:
+		if remote, ok := c.remotes[message.Conn]; ok {
+			remote.Leave()
+		}
+	case deliverMessage, closeMessage:
+		if proxy, ok := c.proxies[message.Conn]; ok {
+			proxy.Enqueue(message)
+		}
+	}
+}
+
+// A connection on another node to an instance owned by this one.
+type remoteConn struct {
+	id   string
+	node string
+	// Frames received from the connection.
+	commands chan clusterMessage
+	// Closed when the connection closes.
+	left      chan struct{}
+	leaveOnce sync.Once
+}
+
+func (r *remoteConn) Leave() {
+	r.leaveOnce.Do(func() {
+		close(r.left)
+	})
+}
+
+// Joins a client connected through another node to its instance, then applies its commands
+// until it leaves. The client is set up from the request exactly as a direct connection is.
+func (c *Cluster) serveRemote(remote *remoteConn, join clusterMessage) {
+	defer func() {
+		c.lock.Lock()
+		delete(c.remotes, remote.id)
+		c.lock.Unlock()
+	}()
+	refuse := func(reason string) {
+		c.send(remote.node, clusterMessage{Type: closeMessage, Conn: remote.id, Error: reason})
+	}
+
+	instance, ok := c.instances.GetInstance(join.Instance)
+	if !ok {
+		refuse("instance not found")
+		return
+	}
+	r, err := http.NewRequest(http.MethodGet, join.URL, nil)
+	if err != nil {
+		refuse("invalid request")
+		return
+	}
+	r.RemoteAddr = join.Address
+	if join.Protocols != "" {
+		r.Header.Set("Sec-Websocket-Protocol", join.Protocols)
+	}
+	negotiation := negotiateProtocol(r)
+
+	client, err := newSessionClient(r, instance, busTransport{cluster: c, node: remote.node, conn: remote.id})
+	if err != nil {
+		refuse(err.Error())
+		return
+	}
+	if negotiation.Version == 0 {
+		client.Logger.Warn("client did not advertise a protocol version")
+	}
+	client.Send(helloMessage(client, negotiation))
+
+	if !instance.Join(client) {
+		refuse("instance not found")
+		return
+	}
+	go client.HandleMessages()
+	client.Logger.Info("client connected through another node", "node", remote.node)
+
+	for {
+		select {
+		case command := <-remote.commands:
+			var message Message
+			if err := codecForFrame(command.FrameType).Unmarshal(command.Data, &message); err != nil {
+				client.Logger.Warn("invalid message", "error", err)
+				metrics.CommandsRejected.Inc(RejectMalformed)
+				continue
+			}
+			receiveMessage(instance, client, message)
+		case <-remote.left:
+			client.Logger.Info("connection closed on another node", "node", remote.node)
+			instance.Leave(client)
+			return
+		}
+	}
+}
+
+// A connection on this node to an instance owned by another node.
+type proxyConn struct {
+	id   string
+	node string
+	conn *websocket.Conn
+	// Frames from the owner, and the owner closing the connection, in the order they were sent.
+	queue chan clusterMessage
+	// Closed when the connection has been read from for the last time.
+	done chan struct{}
+}
+
+// Queues a message from the owner without blocking, disconnecting a client that falls behind.
+func (p *proxyConn) Enqueue(message clusterMessage) {
+	select {
+	case p.queue <- message:
+	default:
+		slog.Warn("proxied connection fell too far behind, disconnecting", "conn", p.id)
+		p.conn.Close()
+	}
+}
+
+// Writes frames from the owner to the connection, until either side closes it.
+func (p *proxyConn) write() {
+	for {
+		select {
+		case message := <-p.queue:
+			if message.Type == closeMessage {
+				if message.Error != "" {
+					errorAndClose(p.conn, message.Error)
+				} else {
+					p.conn.Close()
+				}
+				return
+			}
+			p.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+			if err := p.conn.WriteMessage(message.FrameType, message.Data); err != nil {
+				p.conn.Close()
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Relays a websocket to the node owning its instance until the connection closes. Messages
+// are rate limited here, where the client's connection is.
+func (c *Cluster) proxy(conn *websocket.Conn, r *http.Request, node string, instanceId string) {
+	p := &proxyConn{
+		id:    game.Id(),
+		node:  node,
+		conn:  conn,
+		queue: make(chan clusterMessage, ProxyQueueSize),
+		done:  make(chan struct{}),
+	}
+	logger := slog.Default().With("game", instanceId, "conn", p.id, "node", node)
+	c.lock.Lock()
+	c.proxies[p.id] = p
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.proxies, p.id)
+		c.lock.Unlock()
+		close(p.done)
+	}()
+
+	err := c.send(node, clusterMessage{
+		Type:      joinMessage,
+		Conn:      p.id,
+		Instance:  instanceId,
+		URL:       r.URL.String(),
+		Address:   r.RemoteAddr,
+		Protocols: r.Header.Get("Sec-Websocket-Protocol"),
+	})
+	if err != nil {
+		logger.Error("couldn't reach owning node", "error", err)
+		errorAndClose(conn, "instance unavailable")
+		return
+	}
+	go p.write()
+	logger.Info("proxying connection to owning node")
+	metrics.ConnectedClients.Inc("")
+	defer metrics.ConnectedClients.Dec("")
+
+	limit := newTokenBucket(MessageBurst, MessageRate)
+	address := clientIP(r)
+	for {
+		frameType, bytes, err := conn.ReadMessage()
+		if err != nil {
+			logger.Info("proxied connection closed", "error", err)
+			c.send(node, clusterMessage{Type: leaveMessage, Conn: p.id})
+			return
+		}
+		if !allowFrom(limit, address) {
+			logger.Warn("client exceeded message rate limit, disconnecting")
+			metrics.RateLimited.Inc("message")
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(CloseRateLimited, "rate limited"), time.Now().Add(WriteTimeout))
+			c.send(node, clusterMessage{Type: leaveMessage, Conn: p.id})
+			conn.Close()
+			return
+		}
+		c.send(node, clusterMessage{Type: commandMessage, Conn: p.id, FrameType: frameType, Data: bytes})
+	}
+}
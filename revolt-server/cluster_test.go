@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCluster(t *testing.T) {
+	passwordCost = bcrypt.MinCost
+
+	// Two nodes: the owner, which runs instances in the global instance manager, and an edge node
+	// with no instances of its own.
+	type nodes struct {
+		owner       *httptest.Server
+		ownerNode   *Cluster
+		edge        *httptest.Server
+		edgeNode    *Cluster
+		newInstance func() *GameInstance
+	}
+
+	setup := func(t *testing.T, newBus func() Bus) nodes {
+		initInstanceManager()
+		ipMessageLimits = newIPLimiter(IPMessageBurst, IPMessageRate)
+		ownerNode := NewCluster("owner", newBus(), &im)
+		if err := ownerNode.Start(); err != nil {
+			t.Fatal(err)
+		}
+		edgeInstances := &InstanceManager{Instances: make(map[string]*GameInstance), Codes: make(map[string]string)}
+		edgeNode := NewCluster("edge", newBus(), edgeInstances)
+		if err := edgeNode.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		owner := httptest.NewServer(NewServeMux(Config{}))
+		edge := httptest.NewServer(http.StripPrefix("/ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveWebsocket(edgeInstances, w, r)
+		})))
+		t.Cleanup(func() {
+			edge.Close()
+			owner.Close()
+			edgeNode.Close()
+			ownerNode.Close()
+			for _, instance := range im.ListInstances() {
+				instance.Stop()
+			}
+			im.cluster = nil
+		})
+
+		// Creates an instance on the owner, waiting until the edge knows about it.
+		newInstance := func() *GameInstance {
+			instance := NewGameInstance("")
+			im.StartInstance(instance)
+			waitForLocation(t, edgeNode, instance.GameId, true)
+			return instance
+		}
+		return nodes{owner, ownerNode, edge, edgeNode, newInstance}
+	}
+
+	dial := func(t *testing.T, server *httptest.Server, path string) *websocket.Conn {
+		t.Helper()
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	// Reads messages until one of type `messageType` arrives, returning its payload.
+	readUntil := func(t *testing.T, conn *websocket.Conn, messageType ServerMessageType) (json.RawMessage, error) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			_, bytes, err := conn.ReadMessage()
+			if err != nil {
+				return nil, err
+			}
+			var message struct {
+				Type    ServerMessageType `json:"type"`
+				Payload json.RawMessage   `json:"payload"`
+			}
+			json.Unmarshal(bytes, &message)
+			if message.Type == messageType {
+				return message.Payload, nil
+			}
+		}
+	}
+
+	waitForPlayers := func(t *testing.T, instance *GameInstance, names string) {
+		t.Helper()
+		for range 100 {
+			found := []string{}
+			instance.Do(func() {
+				for _, id := range instance.Game.Order {
+					found = append(found, instance.Game.Players[id].Name)
+				}
+			})
+			if strings.Join(found, ",") == names {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected players %q", names)
+	}
+
+	for name, implementation := range busImplementations() {
+		t.Run(name, func(t *testing.T) {
+			t.Run("should proxy players on other nodes to the owning node", func(t *testing.T) {
+				n := setup(t, implementation(t))
+				instance := n.newInstance()
+
+				remote := dial(t, n.edge, "/ws/"+instance.GameId+"?name=Remote")
+				if _, err := readUntil(t, remote, HelloMessage); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := readUntil(t, remote, StateMessage); err != nil {
+					t.Fatal(err)
+				}
+				local := dial(t, n.owner, "/ws/"+instance.GameId+"?name=Local")
+				readUntil(t, local, StateMessage)
+				waitForPlayers(t, instance, "Remote,Local")
+
+				remote.WriteJSON(map[string]any{"type": SendChatMessage, "payload": ChatPayload{Text: "hello"}})
+				for _, conn := range []*websocket.Conn{local, remote} {
+					payload, err := readUntil(t, conn, ChatMessage)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var entry ChatEntry
+					json.Unmarshal(payload, &entry)
+					if entry.Text != "hello" || entry.Name != "Remote" {
+						t.Errorf("expected chat from the remote player, got %+v", entry)
+					}
+				}
+			})
+
+			t.Run("should find games on other nodes by invite code", func(t *testing.T) {
+				n := setup(t, implementation(t))
+				instance := n.newInstance()
+
+				remote := dial(t, n.edge, "/ws/"+strings.ToLower(instance.InviteCode)+"?name=Remote")
+				if _, err := readUntil(t, remote, StateMessage); err != nil {
+					t.Fatal(err)
+				}
+				waitForPlayers(t, instance, "Remote")
+			})
+
+			t.Run("should apply the owning node's checks to proxied players", func(t *testing.T) {
+				n := setup(t, implementation(t))
+				instance := NewGameInstance("")
+				instance.SetPassword("secret")
+				im.StartInstance(instance)
+				waitForLocation(t, n.edgeNode, instance.GameId, true)
+
+				refused := dial(t, n.edge, "/ws/"+instance.GameId+"?name=Refused")
+				if _, err := readUntil(t, refused, StateMessage); err == nil {
+					t.Error("expected a player without the password to be refused")
+				}
+				admitted := dial(t, n.edge, "/ws/"+instance.GameId+"?name=Admitted&password=secret")
+				if _, err := readUntil(t, admitted, StateMessage); err != nil {
+					t.Fatal(err)
+				}
+				waitForPlayers(t, instance, "Admitted")
+			})
+
+			t.Run("should remove players when their proxied connection closes", func(t *testing.T) {
+				n := setup(t, implementation(t))
+				instance := n.newInstance()
+
+				remote := dial(t, n.edge, "/ws/"+instance.GameId+"?name=Remote")
+				readUntil(t, remote, StateMessage)
+				waitForPlayers(t, instance, "Remote")
+				remote.Close()
+				waitForPlayers(t, instance, "")
+			})
+
+			t.Run("should disconnect proxied players when the instance is deleted", func(t *testing.T) {
+				n := setup(t, implementation(t))
+				instance := n.newInstance()
+
+				remote := dial(t, n.edge, "/ws/"+instance.GameId+"?name=Remote")
+				readUntil(t, remote, StateMessage)
+				im.DeleteInstance(instance.GameId)
+				if _, err := readUntil(t, remote, ServerMessageType("none")); err == nil {
+					t.Error("expected the connection to be closed")
+				}
+				waitForLocation(t, n.edgeNode, instance.GameId, false)
+			})
+
+			t.Run("should rate limit proxied connections where they connect", func(t *testing.T) {
+				n := setup(t, implementation(t))
+				instance := n.newInstance()
+
+				remote := dial(t, n.edge, "/ws/"+instance.GameId)
+				for range MessageBurst + 5 {
+					remote.WriteMessage(websocket.TextMessage, []byte(`{"type":"resync"}`))
+				}
+				_, err := readUntil(t, remote, ServerMessageType("none"))
+				var closeErr *websocket.CloseError
+				if !errors.As(err, &closeErr) || closeErr.Code != CloseRateLimited {
+					t.Errorf("expected close code %d, got %v", CloseRateLimited, err)
+				}
+			})
+
+			t.Run("should learn about instances created before joining", func(t *testing.T) {
+				newBus := implementation(t)
+				n := setup(t, newBus)
+				instance := n.newInstance()
+
+				late := NewCluster("late", newBus(), &InstanceManager{
+					Instances: make(map[string]*GameInstance),
+					Codes:     make(map[string]string),
+				})
+				if err := late.Start(); err != nil {
+					t.Fatal(err)
+				}
+				defer late.Close()
+				waitForLocation(t, late, instance.GameId, true)
+			})
+
+			t.Run("should forget instances owned by nodes that leave", func(t *testing.T) {
+				n := setup(t, implementation(t))
+				instance := n.newInstance()
+
+				n.ownerNode.Close()
+				waitForLocation(t, n.edgeNode, instance.GameId, false)
+				if _, _, ok := n.edgeNode.Locate(instance.InviteCode); ok {
+					t.Error("expected the invite code to be forgotten")
+				}
+			})
+		})
+	}
+}
+
+// Waits until a node does or doesn't know where an instance is.
+func waitForLocation(t *testing.T, cluster *Cluster, id string, known bool) {
+	t.Helper()
+	for range 100 {
+		if _, _, ok := cluster.Locate(id); ok == known {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected instance %s to be known: %v", id, known)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"revolt/client"
+	"revolt/game"
+	"strconv"
+	"strings"
+)
+
+// Shown by the `help` command.
+const Help = `Commands:
+  ready, unready          mark yourself ready to start
+  start                   start the game (owner only)
+  income, aid, tax        take an action
+  exchange
+  steal <player>          take an action against a player, by number or name
+  assassinate <player>
+  revolt <player>
+  block <card>            block the pending action, claiming duke, contessa, captain
+                          or ambassador
+  challenge               challenge the pending action or block
+  commit                  let the pending action or block go ahead
+  die <card>              lose one of your cards, by number
+  end                     end the turn
+  say <text>              chat to the table
+  react <reaction> [player]
+                          react to a player or the pending action, with doubt,
+                          nice_bluff, thinking or well_played
+  help                    show this help
+  quit                    leave the game`
+
+// Returned by parseCommand for the `quit` command.
+var ErrQuit = errors.New("quit")
+
+// Returned by parseCommand for the `help` command.
+var ErrHelp = errors.New("help")
+
+// Short names for actions, alongside their protocol names.
+var actionNames = map[string]game.ActionType{
+	"income":      game.Income,
+	"aid":         game.ForeignAid,
+	"foreign_aid": game.ForeignAid,
+	"revolt":      game.Revolt,
+	"tax":         game.Tax,
+	"assassinate": game.Assassinate,
+	"exchange":    game.Exchange,
+	"steal":       game.Steal,
+}
+
+// Actions which must be aimed at another player.
+var targetedActions = map[game.ActionType]bool{
+	game.Revolt:      true,
+	game.Assassinate: true,
+	game.Steal:       true,
+}
+
+// Turns a line typed by the player into a command for the server.
+func parseCommand(line string, state *client.State) (client.Message, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return client.Message{}, errors.New("no command given")
+	}
+	name, args := strings.ToLower(fields[0]), fields[1:]
+
+	if action, ok := actionNames[name]; ok {
+		payload := map[string]any{"type": action}
+		if targetedActions[action] {
+			if len(args) != 1 {
+				return client.Message{}, fmt.Errorf("usage: %s <player>", name)
+			}
+			target, err := findPeer(state, args[0])
+			if err != nil {
+				return client.Message{}, err
+			}
+			payload["target"] = target.Id
+		}
+		return client.Message{Type: client.AttemptActionMessage, Payload: map[string]any{"action": payload}}, nil
+	}
+
+	switch name {
+	case "ready":
+		return client.Message{Type: client.ReadyMessage}, nil
+	case "unready":
+		return client.Message{Type: client.UnreadyMessage}, nil
+	case "challenge":
+		// The server fills in the initiator itself.
+		payload := map[string]any{"challenge": map[string]any{}}
+		return client.Message{Type: client.ChallengeMessage, Payload: payload}, nil
+	case "start":
+		return client.Message{Type: client.StartGameMessage}, nil
+	case "commit":
+		return client.Message{Type: client.CommitTurnMessage}, nil
+	case "end":
+		return client.Message{Type: client.EndTurnMessage}, nil
+
+	case "block":
+		if len(args) != 1 {
+			return client.Message{}, errors.New("usage: block <card>")
+		}
+		card := game.Card(strings.ToLower(args[0]))
+		if _, ok := game.CardBlocks[card]; !ok {
+			return client.Message{}, fmt.Errorf("%s can't block anything", args[0])
+		}
+		payload := map[string]any{"block": map[string]any{"card": card}}
+		return client.Message{Type: client.AttemptBlockMessage, Payload: payload}, nil
+
+	case "die":
+		if len(args) != 1 {
+			return client.Message{}, errors.New("usage: die <card>")
+		}
+		card, err := strconv.Atoi(args[0])
+		if err != nil || card < 1 || card > len(state.Self.Cards) {
+			return client.Message{}, fmt.Errorf("no card numbered %s", args[0])
+		}
+		if !state.Self.Cards[card-1].Alive {
+			return client.Message{}, fmt.Errorf("card %d is already dead", card)
+		}
+		return client.Message{Type: client.ResolveDeathMessage, Payload: map[string]any{"card": card - 1}}, nil
+
+	case "say":
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+		if text == "" {
+			return client.Message{}, errors.New("usage: say <text>")
+		}
+		return client.Message{Type: client.ChatMessage, Payload: map[string]any{"text": text}}, nil
+
+	case "react":
+		if len(args) < 1 || len(args) > 2 {
+			return client.Message{}, errors.New("usage: react <reaction> [player]")
+		}
+		payload := map[string]any{"reaction": strings.ToLower(args[0])}
+		if len(args) == 2 {
+			target, err := findPeer(state, args[1])
+			if err != nil {
+				return client.Message{}, err
+			}
+			payload["target"] = target.Id
+		} else {
+			payload["pendingAction"] = true
+		}
+		return client.Message{Type: client.ReactMessage, Payload: payload}, nil
+
+	case "help", "?":
+		return client.Message{}, ErrHelp
+	case "quit", "exit":
+		return client.Message{}, ErrQuit
+	}
+	return client.Message{}, fmt.Errorf("unknown command %q, type help for a list", name)
+}
+
+// Finds a peer by their number in the player list, or by the start of their name.
+func findPeer(state *client.State, name string) (client.Peer, error) {
+	if seat, err := strconv.Atoi(name); err == nil {
+		if seat < 1 || seat > len(state.Peers) {
+			return client.Peer{}, fmt.Errorf("no player numbered %d", seat)
+		}
+		return state.Peers[seat-1], nil
+	}
+
+	matches := []client.Peer{}
+	for _, peer := range state.Peers {
+		if strings.EqualFold(peer.Name, name) {
+			return peer, nil
+		}
+		if strings.HasPrefix(strings.ToLower(peer.Name), strings.ToLower(name)) {
+			matches = append(matches, peer)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return client.Peer{}, fmt.Errorf("no player called %s", name)
+	case 1:
+		return matches[0], nil
+	}
+	return client.Peer{}, fmt.Errorf("more than one player is called %s", name)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"revolt/client"
+	"revolt/game"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	state := &client.State{
+		Self: client.Peer{Id: "self", Name: "Alice", Cards: []game.CardState{
+			{Card: game.Duke, Alive: false},
+			{Card: game.Captain, Alive: true},
+		}},
+		Peers: []client.Peer{{Id: "bob", Name: "Bob"}, {Id: "bea", Name: "Bea"}},
+	}
+
+	encode := func(t *testing.T, message client.Message) string {
+		t.Helper()
+		data, err := json.Marshal(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	t.Run("should turn commands into protocol messages", func(t *testing.T) {
+		cases := map[string]string{
+			"ready":           `{"type":"ready"}`,
+			"start":           `{"type":"start_game"}`,
+			"aid":             `{"type":"attempt_action","payload":{"action":{"type":"foreign_aid"}}}`,
+			"Steal 2":         `{"type":"attempt_action","payload":{"action":{"target":"bea","type":"steal"}}}`,
+			"revolt bob":      `{"type":"attempt_action","payload":{"action":{"target":"bob","type":"revolt"}}}`,
+			"block contessa":  `{"type":"attempt_block","payload":{"block":{"card":"contessa"}}}`,
+			"challenge":       `{"type":"challenge","payload":{"challenge":{}}}`,
+			"commit":          `{"type":"commit_turn"}`,
+			"die 2":           `{"type":"resolve_death","payload":{"card":1}}`,
+			"end":             `{"type":"end_turn"}`,
+			"say  good luck ": `{"type":"chat","payload":{"text":"good luck"}}`,
+			"react doubt":     `{"type":"react","payload":{"pendingAction":true,"reaction":"doubt"}}`,
+			"react doubt 1":   `{"type":"react","payload":{"reaction":"doubt","target":"bob"}}`,
+		}
+		for line, expected := range cases {
+			message, err := parseCommand(line, state)
+			if err != nil {
+				t.Errorf("%q: %v", line, err)
+				continue
+			}
+			if actual := encode(t, message); actual != expected {
+				t.Errorf("%q: expected %s, got %s", line, expected, actual)
+			}
+		}
+	})
+
+	t.Run("should refuse invalid commands", func(t *testing.T) {
+		for _, line := range []string{"", "dance", "steal", "steal 3", "steal b", "block duchess", "die 1", "die 3", "say"} {
+			if _, err := parseCommand(line, state); err == nil {
+				t.Errorf("expected %q to be refused", line)
+			}
+		}
+	})
+
+	t.Run("should recognise help and quit", func(t *testing.T) {
+		if _, err := parseCommand("help", state); err != ErrHelp {
+			t.Errorf("expected help, got %v", err)
+		}
+		if _, err := parseCommand("quit", state); err != ErrQuit {
+			t.Errorf("expected quit, got %v", err)
+		}
+	})
+}
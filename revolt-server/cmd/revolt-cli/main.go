@@ -0,0 +1,137 @@
+/*
+A terminal client for Revolt, which creates or joins a game over the websocket protocol.
+
+	revolt-cli -create -name Alice
+	revolt-cli -name Bob <game ID or invite code>
+
+The table is redrawn whenever the game changes, and commands are typed at the prompt. Type
+`help` for a list.
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"revolt/client"
+	"strings"
+)
+
+type Options struct {
+	Server string
+	Game   string
+	Create bool
+	client.Options
+}
+
+func run(options Options) error {
+	ctx := context.Background()
+	if options.Create {
+		created, err := client.CreateGame(ctx, options.Server, options.Password)
+		if err != nil {
+			return err
+		}
+		options.Game, options.Token = created.Id, created.Token
+	}
+
+	address, err := client.GameURL(options.Server, options.Game)
+	if err != nil {
+		return err
+	}
+	c, err := client.Dial(ctx, address, options.Options)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	// Commands wait for the server's acknowledgement, so they are sent off the main loop.
+	rejected := make(chan error)
+
+	view := &View{}
+	view.Render(os.Stdout)
+	for {
+		select {
+		// Both channels are closed once the client stops.
+		case state, ok := <-c.States():
+			if !ok {
+				return c.Err()
+			}
+			view.Update(state)
+
+		case event, ok := <-c.Events():
+			if !ok {
+				return c.Err()
+			}
+			view.Handle(event)
+
+		case err := <-rejected:
+			view.Logf("%s", err)
+
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+			view.ShowHelp = false
+			if strings.TrimSpace(line) == "" {
+				break
+			}
+			if view.State == nil {
+				view.Logf("Still connecting.")
+				break
+			}
+			message, err := parseCommand(line, view.State)
+			switch {
+			case errors.Is(err, ErrQuit):
+				return nil
+			case errors.Is(err, ErrHelp):
+				view.ShowHelp = true
+			case err != nil:
+				view.Logf("%s", err)
+			default:
+				go func() {
+					if err := c.Send(ctx, message); err != nil {
+						rejected <- err
+					}
+				}()
+			}
+		}
+		view.Render(os.Stdout)
+	}
+}
+
+func main() {
+	options := Options{}
+	flag.StringVar(&options.Server, "server", "http://localhost:8080", "address of the Revolt server")
+	flag.StringVar(&options.Name, "name", os.Getenv("USER"), "name to play under")
+	flag.BoolVar(&options.Create, "create", false, "create a new game and join it as its owner")
+	flag.StringVar(&options.Password, "password", "", "password of the game, or to protect a new game with")
+	flag.BoolVar(&options.Spectate, "spectate", false, "watch the game rather than playing")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: revolt-cli [flags] (-create | <game ID or invite code>)")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	options.Game = flag.Arg(0)
+	if options.Create == (options.Game != "") || flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(options); err != nil {
+		fmt.Println()
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"revolt/client"
+	"revolt/game"
+	"strings"
+)
+
+// Clears the terminal and moves the cursor to the top left.
+const clearScreen = "\033[H\033[2J"
+
+// Draws the whole screen.
+func (v *View) Render(w io.Writer) {
+	fmt.Fprint(w, clearScreen)
+	s := v.State
+	if s == nil {
+		fmt.Fprintln(w, "Connecting...")
+	} else {
+		v.renderTable(w, s)
+	}
+
+	if len(v.Log) > 0 {
+		fmt.Fprintln(w)
+		for _, line := range v.Log {
+			fmt.Fprintln(w, line)
+		}
+	}
+	if v.ShowHelp {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, Help)
+	}
+	fmt.Fprint(w, "\n> ")
+}
+
+func (v *View) renderTable(w io.Writer, s *client.State) {
+	status := strings.ReplaceAll(s.Status, "_", " ")
+	header := fmt.Sprintf("Game %s  invite %s  %s", s.GameId, s.InviteCode, status)
+	if s.Spectators > 0 {
+		header += fmt.Sprintf("  %d watching", s.Spectators)
+	}
+	fmt.Fprintln(w, header)
+	fmt.Fprintln(w)
+
+	if !s.Spectator {
+		fmt.Fprintf(w, "  You   %s\n", describePeer(s, s.Self, true))
+	}
+	for i, peer := range s.Peers {
+		fmt.Fprintf(w, "  %d.    %s\n", i+1, describePeer(s, peer, false))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, v.describeTurn(s))
+}
+
+// Describes a player's seat. The client's own cards are numbered, for `die`; other players
+// only show the cards they have lost.
+func describePeer(s *client.State, peer client.Peer, self bool) string {
+	parts := []string{peer.Name}
+	if peer.Leading && s.Status == "in_progress" {
+		parts[0] = "* " + parts[0]
+	}
+	if s.Status == "lobby" {
+		if peer.Ready {
+			parts = append(parts, "ready")
+		} else {
+			parts = append(parts, "not ready")
+		}
+	} else {
+		parts = append(parts, fmt.Sprintf("%d credits", peer.Credits))
+	}
+
+	cards := []string{}
+	for i, card := range peer.Cards {
+		name := string(card.Card)
+		if !card.Alive {
+			name = "dead " + name
+		}
+		if self {
+			name = fmt.Sprintf("%d:%s", i+1, name)
+		}
+		cards = append(cards, name)
+	}
+	if len(cards) > 0 {
+		parts = append(parts, strings.Join(cards, ", "))
+	}
+	if peer.Id == s.OwnerId {
+		parts = append(parts, "owner")
+	}
+	if peer.Muted {
+		parts = append(parts, "muted")
+	}
+	return strings.Join(parts, "  |  ")
+}
+
+// Describes what the table is waiting for, and what the client can do about it.
+func (v *View) describeTurn(s *client.State) string {
+	switch s.Status {
+	case "lobby":
+		if v.Countdown > 0 {
+			return fmt.Sprintf("Dealing in %ds.", v.Countdown)
+		}
+		if s.Self.Id == s.OwnerId {
+			return "Waiting for players. Type ready, then start once everyone is ready."
+		}
+		return "Waiting for players. Type ready when you are."
+	case "complete":
+		return fmt.Sprintf("%s won the game.", s.Name(s.Winner))
+	}
+
+	leader, _ := s.Leader()
+	leading := leader.Id == s.Self.Id && !s.Spectator
+	action := describeAction(s, s.PendingAction)
+
+	switch s.TurnState {
+	case game.Default:
+		if leading {
+			return fmt.Sprintf("Your turn. Your cards allow %s, or bluff another action.",
+				joinActions(s.Self.AllowedActions))
+		}
+		return fmt.Sprintf("Waiting for %s to act.", leader.Name)
+	case game.ActionPending:
+		return fmt.Sprintf("%s %s. Type block <card>, challenge or commit.", leader.Name, action)
+	case game.BlockPending:
+		return fmt.Sprintf("%s %s, but %s blocks with %s. Type challenge or commit.",
+			leader.Name, action, s.Name(s.PendingBlock.Initiator), s.PendingBlock.Card)
+	case game.PlayerLostChallenge, game.LeaderLostChallenge, game.PlayerKilled:
+		if s.NextDeath == s.Self.Id {
+			return "You must lose a card. Type die <card>."
+		}
+		return fmt.Sprintf("Waiting for %s to lose a card.", s.Name(s.NextDeath))
+	case game.Finished:
+		return "The turn is over. Type end to pass to the next player."
+	case game.PlayerWon:
+		return fmt.Sprintf("%s won the game.", s.Name(s.Winner))
+	}
+	return ""
+}
+
+// Describes an action, such as "steals from Alice".
+func describeAction(s *client.State, action game.Action) string {
+	switch action.Type {
+	case game.ForeignAid:
+		return "takes foreign aid"
+	case game.Income:
+		return "takes income"
+	case game.Tax:
+		return "collects tax"
+	case game.Exchange:
+		return "exchanges cards"
+	case game.Steal:
+		return "steals from " + s.Name(action.TargetPlayer)
+	case game.Assassinate:
+		return "assassinates " + s.Name(action.TargetPlayer)
+	case game.Revolt:
+		return "revolts against " + s.Name(action.TargetPlayer)
+	}
+	return string(action.Type)
+}
+
+func joinActions(actions []game.ActionType) string {
+	names := []string{}
+	for _, action := range actions {
+		name := string(action)
+		if action == game.ForeignAid {
+			name = "aid"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
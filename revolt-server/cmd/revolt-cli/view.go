@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"revolt/client"
+)
+
+// How many lines of chat and notices are kept below the table.
+const LogSize = 8
+
+// Everything shown on screen.
+type View struct {
+	State *client.State
+	// Set while cards are about to be dealt.
+	Countdown int
+	// Recent chat, reactions and notices, oldest first.
+	Log []string
+	// Set to show the list of commands until the next one is typed.
+	ShowHelp bool
+}
+
+// Adds a line to the log, dropping the oldest once it is full.
+func (v *View) Logf(format string, args ...any) {
+	v.Log = append(v.Log, fmt.Sprintf(format, args...))
+	if len(v.Log) > LogSize {
+		v.Log = v.Log[len(v.Log)-LogSize:]
+	}
+}
+
+// Shows a new state.
+func (v *View) Update(state client.State) {
+	if state.Status != "lobby" {
+		v.Countdown = 0
+	}
+	v.State = &state
+}
+
+// Applies an event from the server to the view.
+func (v *View) Handle(event client.Event) {
+	switch e := event.(type) {
+	case client.ChatEntry:
+		v.Logf("%s: %s", e.Name, e.Text)
+	case client.ChatHistory:
+		v.Log = nil
+		for _, entry := range e.Messages {
+			v.Logf("%s: %s", entry.Name, entry.Text)
+		}
+	case client.Reaction:
+		v.Logf("%s reacts to %s: %s", v.name(e.From), v.name(e.Target), e.Reaction)
+	case client.Countdown:
+		v.Countdown = e.Remaining
+		if e.Cancelled {
+			v.Countdown = 0
+			v.Logf("The countdown was cancelled.")
+		}
+	case client.Announcement:
+		v.Logf("Announcement: %s", e.Message)
+	case client.Kicked:
+		if e.Banned {
+			v.Logf("You were banned from the game.")
+		} else {
+			v.Logf("You were kicked from the game.")
+		}
+	case client.Disconnected:
+		v.Logf("Lost connection, reconnecting...")
+	case client.Reconnected:
+		v.Logf("Reconnected.")
+	}
+}
+
+func (v *View) name(id string) string {
+	if v.State == nil {
+		return id
+	}
+	return v.State.Name(id)
+}
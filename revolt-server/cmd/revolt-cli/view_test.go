@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"revolt/client"
+	"revolt/game"
+	"strings"
+	"testing"
+)
+
+func TestView(t *testing.T) {
+	state := client.State{
+		GameId:        "game",
+		InviteCode:    "ABCDEF",
+		OwnerId:       "alice",
+		Status:        "in_progress",
+		TurnState:     game.ActionPending,
+		PendingAction: game.Action{Type: game.Steal, TargetPlayer: "alice"},
+		Self: client.Peer{Id: "alice", Name: "Alice", Credits: 2, Cards: []game.CardState{
+			{Card: game.Duke, Alive: true},
+			{Card: game.Captain, Alive: false},
+		}},
+		Peers: []client.Peer{{Id: "bob", Name: "Bob", Credits: 4, Leading: true, Cards: []game.CardState{
+			{Card: game.Contessa, Alive: false},
+		}}},
+	}
+
+	t.Run("should show the table and the pending action", func(t *testing.T) {
+		v := &View{}
+		v.Update(state)
+		var out strings.Builder
+		v.Render(&out)
+
+		for _, expected := range []string{
+			"invite ABCDEF  in progress",
+			"You   Alice  |  2 credits  |  1:duke, 2:dead captain  |  owner",
+			"1.    * Bob  |  4 credits  |  dead contessa",
+			"Bob steals from Alice. Type block <card>, challenge or commit.",
+		} {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("expected output to contain %q, got:\n%s", expected, out.String())
+			}
+		}
+	})
+
+	t.Run("should log chat, reactions and notices", func(t *testing.T) {
+		v := &View{}
+		v.Update(state)
+		v.Handle(client.ChatEntry{Name: "Bob", Text: "hello"})
+		v.Handle(client.Reaction{From: "bob", Reaction: "doubt", Target: "alice"})
+		v.Handle(client.Disconnected{Err: errors.New("connection reset")})
+
+		expected := []string{"Bob: hello", "Bob reacts to Alice: doubt", "Lost connection, reconnecting..."}
+		if strings.Join(v.Log, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("expected log %v, got %v", expected, v.Log)
+		}
+	})
+
+	t.Run("should keep only the latest log lines", func(t *testing.T) {
+		v := &View{}
+		for i := range LogSize + 2 {
+			v.Logf("line %d", i)
+		}
+		if len(v.Log) != LogSize || v.Log[0] != "line 2" {
+			t.Errorf("expected the oldest lines to be dropped, got %v", v.Log)
+		}
+	})
+
+	t.Run("should show the countdown until cards are dealt", func(t *testing.T) {
+		v := &View{}
+		lobby := state
+		lobby.Status = "lobby"
+		v.Update(lobby)
+		v.Handle(client.Countdown{Remaining: 3})
+		if turn := v.describeTurn(v.State); turn != "Dealing in 3s." {
+			t.Errorf("expected the countdown, got %q", turn)
+		}
+		v.Update(state)
+		if v.Countdown != 0 {
+			t.Error("expected the countdown to stop once the game starts")
+		}
+	})
+}
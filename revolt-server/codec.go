@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The query parameter clients use to choose a wire encoding.
+const EncodingKey = "encoding"
+
+// Encodes and decodes messages for the wire.
+type Codec interface {
+	// The name clients use to select the codec.
+	Name() string
+	// The websocket frame type messages are sent in.
+	FrameType() int
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// Encodes messages as JSON in text frames. The default codec.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) FrameType() int {
+	return websocket.TextMessage
+}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// Encodes messages as MessagePack in binary frames, using the same field names as JSON.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (MsgpackCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := msgpack.NewEncoder(&b)
+	encoder.SetCustomStructTag("json")
+	encoder.SetOmitEmpty(false)
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v any) error {
+	decoder := msgpack.NewDecoder(bytes.NewReader(data))
+	decoder.SetCustomStructTag("json")
+	// Decode untyped numbers as int64 or float64, as JSON decodes them all as float64.
+	decoder.UseLooseInterfaceDecoding(true)
+	return decoder.Decode(v)
+}
+
+var (
+	jsonCodec    Codec = JSONCodec{}
+	msgpackCodec Codec = MsgpackCodec{}
+)
+
+// Returns the codec with the given name, defaulting to JSON.
+func codecByName(name string) Codec {
+	if name == msgpackCodec.Name() {
+		return msgpackCodec
+	}
+	return jsonCodec
+}
+
+// Returns the codec used to decode a websocket frame of the given type.
+// Clients can send either encoding regardless of the one they receive.
+func codecForFrame(frameType int) Codec {
+	if frameType == websocket.BinaryMessage {
+		return msgpackCodec
+	}
+	return jsonCodec
+}
+
+// A message payload, kept in its encoded form until the message type is known so it can be
+// decoded straight into the matching payload struct.
+type Payload struct {
+	data  []byte
+	codec Codec
+	// Set instead of `data` for payloads built in-process.
+	value any
+}
+
+// Creates a payload holding a value, for messages built rather than decoded.
+func NewPayload(v any) Payload {
+	return Payload{value: v}
+}
+
+// Decodes the payload into `v`. An empty payload leaves `v` unchanged.
+func (p Payload) Decode(v any) error {
+	if p.value != nil {
+		data, err := json.Marshal(p.value)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, v)
+	}
+	if len(p.data) == 0 {
+		return nil
+	}
+	return p.codec.Unmarshal(p.data, v)
+}
+
+func (p *Payload) UnmarshalJSON(data []byte) error {
+	p.data = bytes.Clone(data)
+	p.codec = jsonCodec
+	return nil
+}
+
+func (p Payload) MarshalJSON() ([]byte, error) {
+	if p.value != nil {
+		return json.Marshal(p.value)
+	}
+	if len(p.data) == 0 {
+		return []byte("null"), nil
+	}
+	if p.codec != jsonCodec {
+		var v any
+		err := p.codec.Unmarshal(p.data, &v)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(v)
+	}
+	return p.data, nil
+}
+
+func (p *Payload) DecodeMsgpack(decoder *msgpack.Decoder) error {
+	data, err := decoder.DecodeRaw()
+	if err != nil {
+		return err
+	}
+	p.data = data
+	p.codec = msgpackCodec
+	return nil
+}
+
+func (p Payload) EncodeMsgpack(encoder *msgpack.Encoder) error {
+	if p.value != nil {
+		return encoder.Encode(p.value)
+	}
+	if len(p.data) == 0 {
+		return encoder.EncodeNil()
+	}
+	if p.codec != msgpackCodec {
+		var v any
+		err := p.codec.Unmarshal(p.data, &v)
+		if err != nil {
+			return err
+		}
+		return encoder.Encode(v)
+	}
+	_, err := encoder.Writer().Write(p.data)
+	return err
+}
+
+// Logs the payload as JSON.
+func (p Payload) LogValue() slog.Value {
+	data, err := p.MarshalJSON()
+	if err != nil {
+		return slog.StringValue("<invalid payload>")
+	}
+	return slog.StringValue(string(data))
+}
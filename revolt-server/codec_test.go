@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"revolt/game"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCodecs(t *testing.T) {
+	codecs := []Codec{jsonCodec, msgpackCodec}
+
+	for _, codec := range codecs {
+		t.Run(codec.Name()+" should decode payloads into their message type", func(t *testing.T) {
+			sent := Message{
+				Type:      AttemptActionMessage,
+				Payload:   NewPayload(AttemptActionPayload{Action: game.Action{Type: game.Steal, TargetPlayer: "1"}}),
+				RequestId: "a",
+			}
+			bytes, err := codec.Marshal(sent)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var received Message
+			err = codec.Unmarshal(bytes, &received)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload AttemptActionPayload
+			err = received.Payload.Decode(&payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if received.Type != AttemptActionMessage || received.RequestId != "a" {
+				t.Errorf("expected envelope fields to round trip, got %+v", received)
+			}
+			if payload.Action.Type != game.Steal || payload.Action.TargetPlayer != "1" {
+				t.Errorf("expected action to round trip, got %+v", payload)
+			}
+		})
+
+		t.Run(codec.Name()+" should accept messages without a payload", func(t *testing.T) {
+			bytes, err := codec.Marshal(map[string]any{"type": "commit_turn"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var received Message
+			err = codec.Unmarshal(bytes, &received)
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload := ChallengePayload{}
+			if err := received.Payload.Decode(&payload); err != nil {
+				t.Errorf("expected empty payload to decode, got %s", err)
+			}
+		})
+
+		t.Run(codec.Name()+" should round trip state broadcasts", func(t *testing.T) {
+			i := NewGameInstance("0")
+			i.Game.AddPlayer("0", "Player One")
+			i.Game.AddPlayer("1", "Player Two")
+			i.Game.Deal()
+			update := i.ToClientStateBroadcast(&Client{Id: "0"})
+
+			bytes, err := update.Serialise(codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var received ClientStateBroadcast
+			err = codec.Unmarshal(bytes, &received)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(received.Self, update.Self) || !reflect.DeepEqual(received.Peers, update.Peers) {
+				t.Errorf("expected state to round trip, got %+v", received)
+			}
+		})
+	}
+
+	t.Run("should use field names from JSON tags in msgpack", func(t *testing.T) {
+		bytes, err := msgpackCodec.Marshal(AckPayload{RequestId: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded map[string]any
+		msgpackCodec.Unmarshal(bytes, &decoded)
+
+		if decoded["requestId"] != "a" {
+			t.Errorf("expected requestId field, got %v", decoded)
+		}
+	})
+}
+
+func TestBinaryWebsocket(t *testing.T) {
+	t.Run("should speak msgpack over binary frames when negotiated", func(t *testing.T) {
+		initInstanceManager()
+		instance := NewGameInstance("")
+		im.RegisterInstance(instance)
+		go instance.Run()
+		defer instance.Stop()
+
+		server := httptest.NewServer(http.HandlerFunc(websocketHandler))
+		defer server.Close()
+
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/" + instance.GameId + "?encoding=msgpack"
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		frameType, bytes, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frameType != websocket.BinaryMessage {
+			t.Fatalf("expected a binary frame, got %d", frameType)
+		}
+
+		var hello Message
+		err = msgpackCodec.Unmarshal(bytes, &hello)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload HelloPayload
+		hello.Payload.Decode(&payload)
+		if payload.ProtocolVersion != ProtocolVersion {
+			t.Errorf("expected hello with protocol version, got %+v", payload)
+		}
+
+		// Commands can be sent as binary frames too.
+		command, _ := msgpackCodec.Marshal(Message{Type: ReadyMessage, RequestId: "a"})
+		conn.WriteMessage(websocket.BinaryMessage, command)
+
+		for {
+			_, bytes, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var message struct {
+				Type    ServerMessageType `json:"type"`
+				Payload AckPayload        `json:"payload"`
+			}
+			msgpackCodec.Unmarshal(bytes, &message)
+			if message.Type == AckMessage {
+				if message.Payload.Status != AckApplied {
+					t.Errorf("expected command to be applied, got %+v", message.Payload)
+				}
+				break
+			}
+		}
+	})
+}
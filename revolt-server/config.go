@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Server configuration, read from the environment.
+type Config struct {
+	// Address the server listens on.
+	Host string
+	// Minimum level of log messages to write (debug, info, warn or error).
+	LogLevel string
+	// Log output format (text or json).
+	LogFormat string
+	// Bearer token required by the admin API. The admin API is disabled if empty.
+	AdminToken string
+	// Key used to sign session tokens. A random key is used if empty, so sessions don't
+	// survive a restart.
+	SessionKey string
+	// Path of the database holding player accounts. Accounts are disabled if empty.
+	Database string
+	// Time between the owner starting a game and cards being dealt.
+	StartCountdown time.Duration
+	// Comma separated words masked in chat.
+	ChatFilter string
+	// Address of the pub/sub bus connecting the nodes of a cluster, such as
+	// `redis://localhost:6379`. The server runs alone if empty.
+	Bus string
+	// Identifies this node within the cluster. A random ID is used if empty.
+	NodeId string
+	// Paths of the certificate and key to serve HTTPS with. The server uses plain HTTP if empty.
+	TLSCert string
+	TLSKey  string
+	// Serves HTTPS with a generated self-signed certificate, for development.
+	TLSSelfSigned bool
+	// Address to redirect plain HTTP requests to HTTPS from, when serving HTTPS.
+	RedirectHost string
+	// Comma separated origins allowed to use the API from a browser, or `*` for any. The
+	// server's own origin is always allowed.
+	AllowedOrigins string
+}
+
+// Returns the default configuration, used for any values not set in the environment.
+func DefaultConfig() Config {
+	return Config{
+		Host:      "localhost:8080",
+		LogLevel:  "info",
+		LogFormat: "text",
+
+		StartCountdown: DefaultStartCountdown,
+	}
+}
+
+// Reads configuration from `REVOLT_*` environment variables, falling back to defaults.
+func LoadConfig(getenv func(string) string) Config {
+	config := DefaultConfig()
+	setString(&config.Host, getenv("REVOLT_HOST"))
+	setString(&config.LogLevel, getenv("REVOLT_LOG_LEVEL"))
+	setString(&config.LogFormat, getenv("REVOLT_LOG_FORMAT"))
+	setString(&config.AdminToken, getenv("REVOLT_ADMIN_TOKEN"))
+	setString(&config.SessionKey, getenv("REVOLT_SESSION_KEY"))
+	setString(&config.Database, getenv("REVOLT_DATABASE"))
+	setString(&config.ChatFilter, getenv("REVOLT_CHAT_FILTER"))
+	setString(&config.Bus, getenv("REVOLT_BUS"))
+	setString(&config.NodeId, getenv("REVOLT_NODE_ID"))
+	setString(&config.TLSCert, getenv("REVOLT_TLS_CERT"))
+	setString(&config.TLSKey, getenv("REVOLT_TLS_KEY"))
+	setBool(&config.TLSSelfSigned, "REVOLT_TLS_SELF_SIGNED", getenv("REVOLT_TLS_SELF_SIGNED"))
+	setString(&config.RedirectHost, getenv("REVOLT_HTTP_REDIRECT"))
+	setString(&config.AllowedOrigins, getenv("REVOLT_ALLOWED_ORIGINS"))
+	setDuration(&config.StartCountdown, "REVOLT_START_COUNTDOWN", getenv("REVOLT_START_COUNTDOWN"))
+	return config
+}
+
+// Loads configuration from the process environment.
+func LoadConfigFromEnv() Config {
+	return LoadConfig(os.Getenv)
+}
+
+// Overwrites `field` with `value` if `value` is set.
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+// Overwrites `field` with `value` parsed as a boolean, such as `true`, if `value` is set.
+// Invalid booleans are logged and ignored.
+func setBool(field *bool, name string, value string) {
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("ignoring invalid boolean", "variable", name, "value", value)
+		return
+	}
+	*field = parsed
+}
+
+// Overwrites `field` with `value` parsed as a duration, such as `5s`, if `value` is set.
+// Invalid durations are logged and ignored.
+func setDuration(field *time.Duration, name string, value string) {
+	if value == "" {
+		return
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		slog.Warn("ignoring invalid duration", "variable", name, "value", value)
+		return
+	}
+	*field = duration
+}
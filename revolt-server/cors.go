@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The origins allowed to call the API from a browser and to open websockets. Requests without
+// an `Origin` header, which don't come from browsers, and requests from the server's own origin
+// are always allowed.
+type OriginPolicy struct {
+	origins map[string]bool
+	// Set if the list included `*`, allowing every origin.
+	any bool
+}
+
+// Creates a policy allowing `origins`, such as `https://revolt.example`.
+func NewOriginPolicy(origins []string) *OriginPolicy {
+	p := &OriginPolicy{origins: make(map[string]bool)}
+	for _, origin := range origins {
+		origin = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+		switch origin {
+		case "":
+		case "*":
+			p.any = true
+		default:
+			p.origins[origin] = true
+		}
+	}
+	return p
+}
+
+// Reports whether a request may be made from its origin.
+func (p *OriginPolicy) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.any || p.origins[strings.ToLower(origin)] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// The origin policy, set from the server's configuration.
+var allowedOrigins = NewOriginPolicy(nil)
+
+// Lets browsers on allowed origins call `next`, answering their preflight requests. Requests
+// from other origins are refused.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !allowedOrigins.Allowed(r) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest("GET", "http://revolt.example/api/schema", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	t.Run("should allow listed origins and the server's own", func(t *testing.T) {
+		policy := NewOriginPolicy([]string{" https://Play.Example/ ", ""})
+
+		for _, origin := range []string{"", "http://revolt.example", "https://play.example"} {
+			if !policy.Allowed(request(origin)) {
+				t.Errorf("expected %q to be allowed", origin)
+			}
+		}
+		for _, origin := range []string{"https://evil.example", "http://play.example", "null"} {
+			if policy.Allowed(request(origin)) {
+				t.Errorf("expected %q to be refused", origin)
+			}
+		}
+	})
+
+	t.Run("should allow any origin with a wildcard", func(t *testing.T) {
+		policy := NewOriginPolicy([]string{"*"})
+		if !policy.Allowed(request("https://evil.example")) {
+			t.Error("expected any origin to be allowed")
+		}
+	})
+}
+
+func TestCORS(t *testing.T) {
+	setup := func(t *testing.T) *httptest.Server {
+		allowedOrigins = NewOriginPolicy([]string{"https://play.example"})
+		t.Cleanup(func() { allowedOrigins = NewOriginPolicy(nil) })
+		return newTestServer(t)
+	}
+
+	send := func(t *testing.T, method string, url string, origin string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, url, nil)
+		req.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	t.Run("should answer preflight requests from allowed origins", func(t *testing.T) {
+		server := setup(t)
+
+		res := send(t, "OPTIONS", server.URL+"/api/create", "https://play.example")
+		if res.StatusCode != http.StatusNoContent {
+			t.Fatalf("expected status 204, got %d", res.StatusCode)
+		}
+		if res.Header.Get("Access-Control-Allow-Origin") != "https://play.example" {
+			t.Errorf("expected the origin to be allowed, got %q", res.Header.Get("Access-Control-Allow-Origin"))
+		}
+		if !strings.Contains(res.Header.Get("Access-Control-Allow-Headers"), "Authorization") {
+			t.Error("expected the authorization header to be allowed")
+		}
+	})
+
+	t.Run("should allow requests from allowed origins", func(t *testing.T) {
+		server := setup(t)
+
+		res := send(t, "POST", server.URL+"/api/create", "https://play.example")
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", res.StatusCode)
+		}
+		if res.Header.Get("Access-Control-Allow-Origin") != "https://play.example" {
+			t.Errorf("expected the origin to be allowed, got %q", res.Header.Get("Access-Control-Allow-Origin"))
+		}
+	})
+
+	t.Run("should refuse requests from other origins", func(t *testing.T) {
+		server := setup(t)
+
+		res := send(t, "POST", server.URL+"/api/create", "https://evil.example")
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("expected status 403, got %d", res.StatusCode)
+		}
+		if len(im.ListInstances()) != 0 {
+			t.Error("expected no game to be created")
+		}
+	})
+
+	t.Run("should refuse websockets from other origins", func(t *testing.T) {
+		server := setup(t)
+		instance := NewGameInstance("")
+		im.StartInstance(instance)
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + instance.GameId
+
+		_, res, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example"}})
+		if err == nil || res.StatusCode != http.StatusForbidden {
+			t.Errorf("expected the handshake to be refused, got %v", err)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://play.example"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// How clients receive state updates.
+type UpdateMode string
+
+const (
+	// Every update is a full `ClientStateBroadcast`.
+	FullUpdates UpdateMode = "full"
+	// Updates are JSON Patches against the previous state, with periodic full snapshots.
+	DeltaUpdates UpdateMode = "delta"
+)
+
+// Every update mode clients can choose.
+var UpdateModes = []UpdateMode{FullUpdates, DeltaUpdates}
+
+// The query parameter clients use to choose an update mode.
+const UpdatesKey = "updates"
+
+// The number of deltas sent between full snapshots in delta mode.
+const SnapshotInterval = 20
+
+// A single RFC 6902 JSON Patch operation. Only `add`, `remove` and `replace` are produced.
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// Sent in place of a full state broadcast to clients in delta mode.
+// Applying `patch` to the state at `baseVersion` gives the state at `version`. A client whose
+// last state doesn't match `baseVersion` should send a `resync` message to get a full snapshot.
+type StateDeltaPayload struct {
+	BaseVersion int              `json:"baseVersion"`
+	Version     int              `json:"version"`
+	Patch       []PatchOperation `json:"patch"`
+}
+
+// Tracks the last state written to a delta mode client. Only used by the client's writer.
+type deltaEncoder struct {
+	last        any
+	lastVersion int
+	sinceFull   int
+}
+
+// Encodes a serialised state broadcast for the wire, as either the full state or a delta
+// against the previous state sent. A full snapshot is sent first, every `SnapshotInterval`
+// updates, and when `forceFull` is set.
+func (e *deltaEncoder) Encode(codec Codec, state []byte, forceFull bool) ([]byte, error) {
+	var current map[string]any
+	err := codec.Unmarshal(state, &current)
+	if err != nil {
+		return nil, err
+	}
+	version := toInt(current["version"])
+
+	if e.last == nil || forceFull || e.sinceFull >= SnapshotInterval {
+		e.last = current
+		e.lastVersion = version
+		e.sinceFull = 0
+		return state, nil
+	}
+
+	message := ServerMessage{
+		Type: StateDeltaMessage,
+		Payload: StateDeltaPayload{
+			BaseVersion: e.lastVersion,
+			Version:     version,
+			Patch:       Diff(e.last, current),
+		},
+	}
+	e.last = current
+	e.lastVersion = version
+	e.sinceFull++
+	return message.Serialise(codec)
+}
+
+// Converts a decoded number to an int, whichever codec decoded it.
+func toInt(v any) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int64:
+		return int(n)
+	case uint64:
+		return int(n)
+	}
+	return 0
+}
+
+// Computes a JSON Patch transforming the decoded JSON value `from` into `to`.
+// Arrays which change length are replaced wholesale rather than diffed element by element.
+func Diff(from any, to any) []PatchOperation {
+	return diff("", from, to, []PatchOperation{})
+}
+
+func diff(path string, from any, to any, patch []PatchOperation) []PatchOperation {
+	switch f := from.(type) {
+	case map[string]any:
+		t, ok := to.(map[string]any)
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(f) {
+			if _, ok := t[key]; !ok {
+				patch = append(patch, PatchOperation{Op: "remove", Path: path + "/" + escapePointer(key)})
+			}
+		}
+		for _, key := range sortedKeys(t) {
+			child := path + "/" + escapePointer(key)
+			if fromValue, ok := f[key]; ok {
+				patch = diff(child, fromValue, t[key], patch)
+			} else {
+				patch = append(patch, PatchOperation{Op: "add", Path: child, Value: t[key]})
+			}
+		}
+		return patch
+
+	case []any:
+		t, ok := to.([]any)
+		if !ok || len(f) != len(t) {
+			break
+		}
+		for i := range f {
+			patch = diff(path+"/"+strconv.Itoa(i), f[i], t[i], patch)
+		}
+		return patch
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		patch = append(patch, PatchOperation{Op: "replace", Path: path, Value: to})
+	}
+	return patch
+}
+
+// Applies a JSON Patch to a decoded JSON value, returning the patched value.
+// `doc` may be modified in place.
+func ApplyPatch(doc any, patch []PatchOperation) (any, error) {
+	for _, op := range patch {
+		var err error
+		doc, err = applyOperation(doc, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func applyOperation(doc any, op PatchOperation) (any, error) {
+	if op.Path == "" {
+		if op.Op == "remove" {
+			return nil, nil
+		}
+		return op.Value, nil
+	}
+
+	tokens := strings.Split(op.Path, "/")[1:]
+	parentTokens, key := tokens[:len(tokens)-1], unescapePointer(tokens[len(tokens)-1])
+
+	// Walk to the parent of the target, remembering how to write an updated array back.
+	var setParent func(any)
+	parent := doc
+	setParent = func(v any) { doc = v }
+	for _, token := range parentTokens {
+		token = unescapePointer(token)
+		switch p := parent.(type) {
+		case map[string]any:
+			child, ok := p[token]
+			if !ok {
+				return nil, fmt.Errorf("path %s not found", op.Path)
+			}
+			setParent = func(v any) { p[token] = v }
+			parent = child
+		case []any:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(p) {
+				return nil, fmt.Errorf("path %s not found", op.Path)
+			}
+			setParent = func(v any) { p[i] = v }
+			parent = p[i]
+		default:
+			return nil, fmt.Errorf("path %s not found", op.Path)
+		}
+	}
+
+	switch p := parent.(type) {
+	case map[string]any:
+		switch op.Op {
+		case "add", "replace":
+			p[key] = op.Value
+		case "remove":
+			delete(p, key)
+		default:
+			return nil, fmt.Errorf("unsupported operation %s", op.Op)
+		}
+	case []any:
+		i, err := strconv.Atoi(key)
+		if key == "-" {
+			i, err = len(p), nil
+		}
+		if err != nil || i < 0 || i > len(p) || (op.Op != "add" && i == len(p)) {
+			return nil, fmt.Errorf("path %s not found", op.Path)
+		}
+		switch op.Op {
+		case "add":
+			p = append(p[:i], append([]any{op.Value}, p[i:]...)...)
+		case "replace":
+			p[i] = op.Value
+		case "remove":
+			p = append(p[:i], p[i+1:]...)
+		default:
+			return nil, fmt.Errorf("unsupported operation %s", op.Op)
+		}
+		setParent(p)
+	default:
+		return nil, fmt.Errorf("path %s not found", op.Path)
+	}
+	return doc, nil
+}
+
+// Escapes a key for use as a JSON Pointer reference token.
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+func unescapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"revolt/game"
+	"testing"
+)
+
+// Decodes JSON into a generic value.
+func decode(t *testing.T, data []byte) any {
+	t.Helper()
+	var v any
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// Plays through the start of a game, returning the serialised broadcast for a client after each step.
+func broadcastSequence(t *testing.T) [][]byte {
+	t.Helper()
+	i := NewGameInstance("0")
+	i.Game.AddPlayer("0", "Player One")
+	i.Game.AddPlayer("1", "Player Two")
+	client := &Client{Id: "0"}
+
+	steps := []func(){
+		func() {},
+		func() { i.Game.Deal(); i.Status = InProgress },
+		func() { i.Game.AttemptAction(game.Action{Type: game.Steal, TargetPlayer: "1"}) },
+		func() { i.Game.AttemptBlock(game.Block{Card: game.Captain, Initiator: "1"}) },
+		func() { i.Game.Challenge(game.Challenge{Initiator: "0"}) },
+		func() { i.Game.AddPlayer("2", "Player/Three~") },
+		func() { i.Game.RemovePlayer("2") },
+	}
+
+	states := [][]byte{}
+	for _, step := range steps {
+		step()
+		i.Version++
+		update := i.ToClientStateBroadcast(client)
+		bytes, err := update.Serialise(jsonCodec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, bytes)
+	}
+	return states
+}
+
+func TestDiff(t *testing.T) {
+	t.Run("should produce patches which reproduce the target state", func(t *testing.T) {
+		states := broadcastSequence(t)
+
+		for n := 1; n < len(states); n++ {
+			from := decode(t, states[n-1])
+			to := decode(t, states[n])
+
+			patched, err := ApplyPatch(from, Diff(from, to))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(patched, decode(t, states[n])) {
+				t.Errorf("step %d: expected patched state to equal broadcast", n)
+			}
+		}
+	})
+
+	t.Run("should only include changed fields", func(t *testing.T) {
+		from := decode(t, []byte(`{"a":1,"b":{"c":[1,2]},"d":"x"}`))
+		to := decode(t, []byte(`{"a":1,"b":{"c":[1,3]},"e":"y"}`))
+
+		expected := []PatchOperation{
+			{Op: "replace", Path: "/b/c/1", Value: float64(3)},
+			{Op: "remove", Path: "/d"},
+			{Op: "add", Path: "/e", Value: "y"},
+		}
+		patch := Diff(from, to)
+
+		// Order within the patch isn't significant, so compare as sets.
+		if len(patch) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, patch)
+		}
+		for _, op := range expected {
+			found := false
+			for _, p := range patch {
+				found = found || reflect.DeepEqual(op, p)
+			}
+			if !found {
+				t.Errorf("expected patch to contain %v, got %v", op, patch)
+			}
+		}
+	})
+
+	t.Run("should escape keys in paths", func(t *testing.T) {
+		from := decode(t, []byte(`{}`))
+		to := decode(t, []byte(`{"a/b~c":true}`))
+
+		patch := Diff(from, to)
+		if len(patch) != 1 || patch[0].Path != "/a~1b~0c" {
+			t.Errorf("expected escaped path, got %v", patch)
+		}
+	})
+}
+
+func TestDeltaEncoder(t *testing.T) {
+	t.Run("should send deltas which reproduce every broadcast", func(t *testing.T) {
+		states := broadcastSequence(t)
+		encoder := deltaEncoder{}
+
+		var current any
+		for n, state := range states {
+			encoded, err := encoder.Encode(jsonCodec, state, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var message struct {
+				Type    ServerMessageType `json:"type"`
+				Payload StateDeltaPayload `json:"payload"`
+			}
+			json.Unmarshal(encoded, &message)
+
+			switch message.Type {
+			case StateMessage:
+				if n != 0 {
+					t.Errorf("step %d: expected a delta, got a full state", n)
+				}
+				current = decode(t, encoded)
+			case StateDeltaMessage:
+				if message.Payload.Version != message.Payload.BaseVersion+1 {
+					t.Errorf("step %d: expected consecutive versions, got %+v", n, message.Payload)
+				}
+				current, err = ApplyPatch(current, message.Payload.Patch)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !reflect.DeepEqual(current, decode(t, state)) {
+				t.Errorf("step %d: expected reconstructed state to equal broadcast", n)
+			}
+		}
+	})
+
+	t.Run("should send periodic and requested full snapshots", func(t *testing.T) {
+		state := []byte(`{"type":"state","version":1}`)
+		encoder := deltaEncoder{}
+
+		encoder.Encode(jsonCodec, state, false)
+		for range SnapshotInterval {
+			encoded, _ := encoder.Encode(jsonCodec, state, false)
+			if reflect.DeepEqual(encoded, state) {
+				t.Fatal("expected a delta before the snapshot interval")
+			}
+		}
+		if encoded, _ := encoder.Encode(jsonCodec, state, false); !reflect.DeepEqual(encoded, state) {
+			t.Error("expected a full snapshot after the snapshot interval")
+		}
+
+		encoder.Encode(jsonCodec, state, false)
+		if encoded, _ := encoder.Encode(jsonCodec, state, true); !reflect.DeepEqual(encoded, state) {
+			t.Error("expected a full snapshot when forced")
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// The built frontend, served at `/`. Only set when the server is built with the `frontend` tag,
+// otherwise the frontend is run separately.
+var frontendFiles fs.FS
+
+// Serves the frontend's files. Paths which aren't files, such as game IDs, are routed by the
+// frontend itself, so are sent its entry point. Vite names bundled assets by their content, so
+// they can be cached indefinitely.
+func frontendHandler(files fs.FS) http.Handler {
+	fileServer := http.FileServerFS(files)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		if info, err := fs.Stat(files, name); err != nil || info.IsDir() {
+			http.ServeFileFS(w, r, files, "index.html")
+			return
+		}
+		if strings.HasPrefix(name, "assets/") {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
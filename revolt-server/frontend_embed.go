@@ -0,0 +1,21 @@
+//go:build frontend
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// Copied from `revolt-frontend/dist` by `make build`.
+//
+//go:embed all:web/dist
+var embeddedFrontend embed.FS
+
+func init() {
+	files, err := fs.Sub(embeddedFrontend, "web/dist")
+	if err != nil {
+		panic(err)
+	}
+	frontendFiles = files
+}
@@ -17,6 +17,9 @@ const (
 	Contessa   Card = "contessa"
 )
 
+// Every type of card.
+var Cards = []Card{Duke, Assassin, Ambassador, Captain, Contessa}
+
 // Defines the state of a single card in a player's hand.
 type CardState struct {
 	Card  Card `json:"card"`
@@ -37,6 +40,9 @@ const (
 	Steal       ActionType = "steal"
 )
 
+// Every action a player can take.
+var ActionTypes = []ActionType{Income, ForeignAid, Revolt, Tax, Assassinate, Exchange, Steal}
+
 // Defines actions which do not need a card to perform.
 var DefaultGrants = []ActionType{Income, ForeignAid, Revolt}
 
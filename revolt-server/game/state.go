@@ -6,16 +6,20 @@ package game
 import (
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/google/uuid"
 )
 
+// Generates a random ID for a game or player.
 func Id() string {
-	// TODO this should be a full length UUID, but 8 chars is easier to read in development.
-	return uuid.NewString()[:8]
+	return uuid.NewString()
 }
 
-const MaxPlayers = 6
+const (
+	MinPlayers = 2
+	MaxPlayers = 6
+)
 
 // An initial player action.
 type Action struct {
@@ -49,12 +53,31 @@ const (
 	PlayerWon           TurnState = "player_won"
 )
 
+// Every state a turn can be in.
+var TurnStates = []TurnState{
+	Default, ActionPending, BlockPending, ExchangePending, PlayerLostChallenge,
+	LeaderLostChallenge, PlayerKilled, Finished, PlayerWon,
+}
+
+// A claim to hold a character, made by attempting a character action or a block.
+type Claim struct {
+	Player string
+	// Set if the player didn't hold a card allowing the action or block.
+	Bluff bool
+	// The player who challenged the claim, if anyone did.
+	ChallengedBy string
+}
+
 // Represents the current game state.
 type Game struct {
-	Deck             []Card
-	Players          map[string]*Player
-	Winner           string
-	Order            []string
+	Deck    []Card
+	Players map[string]*Player
+	Winner  string
+	Order   []string
+	// IDs of players in the order they lost their last card.
+	Eliminated []string
+	// Every claim made in the game, in order.
+	Claims           []Claim
 	Leader           int
 	TurnState        TurnState
 	NextDeath        string
@@ -72,6 +95,8 @@ func NewGame() Game {
 		Players:          make(map[string]*Player),
 		Winner:           "",
 		Order:            []string{},
+		Eliminated:       []string{},
+		Claims:           []Claim{},
 		Leader:           0,
 		NextDeath:        "",
 		PendingAction:    Action{},
@@ -94,6 +119,31 @@ func (g *Game) AddPlayer(id string, name string) error {
 	return nil
 }
 
+// Removes a player from the game and its turn order.
+func (g *Game) RemovePlayer(id string) {
+	delete(g.Players, id)
+	g.Order = slices.DeleteFunc(g.Order, func(player string) bool { return player == id })
+}
+
+// Sets the turn order, which must hold every player exactly once.
+func (g *Game) SetOrder(order []string) error {
+	if len(order) != len(g.Players) {
+		return fmt.Errorf("order has %d players, game has %d", len(order), len(g.Players))
+	}
+	seen := map[string]bool{}
+	for _, id := range order {
+		if _, ok := g.Players[id]; !ok {
+			return fmt.Errorf("player with id %s not found", id)
+		}
+		if seen[id] {
+			return fmt.Errorf("player with id %s appears more than once", id)
+		}
+		seen[id] = true
+	}
+	g.Order = slices.Clone(order)
+	return nil
+}
+
 func (g *Game) GetLeader() *Player {
 	id := g.Order[g.Leader]
 	return g.Players[id]
@@ -145,6 +195,13 @@ func (g *Game) AttemptAction(action Action) error {
 		return err
 	}
 
+	if isCharacterAction(action.Type) {
+		g.Claims = append(g.Claims, Claim{
+			Player: leader.Id,
+			Bluff:  !leader.IsAllowedAction(action.Type),
+		})
+	}
+
 	g.PendingAction = action
 	g.TurnState = ActionPending
 
@@ -166,6 +223,14 @@ func (g *Game) AttemptBlock(block Block) error {
 		return errors.New("card does not block current pending action")
 	}
 
+	blocker, ok := g.Players[block.Initiator]
+	if ok {
+		g.Claims = append(g.Claims, Claim{
+			Player: blocker.Id,
+			Bluff:  !blocker.CanBlock(g.PendingAction.Type),
+		})
+	}
+
 	g.PendingBlock = block
 	g.TurnState = BlockPending
 	return nil
@@ -184,6 +249,11 @@ func (g *Game) Challenge(challenge Challenge) error {
 	g.PendingChallenge = challenge
 	leader := g.GetLeader()
 
+	// Blocks and character actions are the most recent claim.
+	if len(g.Claims) > 0 && (g.TurnState == BlockPending || isCharacterAction(g.PendingAction.Type)) {
+		g.Claims[len(g.Claims)-1].ChallengedBy = challenge.Initiator
+	}
+
 	/*
 		If an action is being challenged, check the leader has the necessary card for the
 		current action.
@@ -228,7 +298,12 @@ func (g *Game) ResolveDeath(card int) error {
 	if g.NextDeath == "" {
 		return errors.New("id of next to die not set")
 	}
-	g.Players[g.NextDeath].KillCard(card)
+	player := g.Players[g.NextDeath]
+	alive := len(player.GetLivingCards()) != 0
+	player.KillCard(card)
+	if alive && len(player.GetLivingCards()) == 0 {
+		g.Eliminated = append(g.Eliminated, player.Id)
+	}
 	g.NextDeath = ""
 
 	// If a player has lost a challenge, return to ActionPending
@@ -325,6 +400,31 @@ func (g *Game) EndTurn() error {
 	return nil
 }
 
+// Returns the IDs of players in the order they finished, starting with the winner.
+// Players still in play are placed ahead of those eliminated, in turn order.
+func (g *Game) Placings() []string {
+	placings := []string{}
+	if g.Winner != "" {
+		placings = append(placings, g.Winner)
+	}
+	for _, id := range g.Order {
+		if id != g.Winner && !slices.Contains(g.Eliminated, id) {
+			placings = append(placings, id)
+		}
+	}
+	for i := len(g.Eliminated) - 1; i >= 0; i-- {
+		if g.Eliminated[i] != g.Winner {
+			placings = append(placings, g.Eliminated[i])
+		}
+	}
+	return placings
+}
+
+// Reports whether an action requires a character, and so is a claim to hold it.
+func isCharacterAction(action ActionType) bool {
+	return !slices.Contains(DefaultGrants, action)
+}
+
 // Utility function to check if the game is in any of the passed states.
 func (g *Game) stateIn(states ...TurnState) bool {
 	for _, state := range states {
@@ -15,6 +15,47 @@ func TestNewGame(t *testing.T) {
 	})
 }
 
+func TestSetOrder(t *testing.T) {
+	setup := func() Game {
+		g := NewGame()
+		g.AddPlayer("0", "Test")
+		g.AddPlayer("1", "Test")
+		g.AddPlayer("2", "Test")
+		return g
+	}
+
+	t.Run("should reorder players", func(t *testing.T) {
+		g := setup()
+		err := g.SetOrder([]string{"2", "0", "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(g.Order) != "[2 0 1]" {
+			t.Error("incorrect order, expected [2 0 1] got", g.Order)
+		}
+	})
+
+	t.Run("should reject orders that aren't a permutation of the players", func(t *testing.T) {
+		g := setup()
+		for _, order := range [][]string{{"0", "1"}, {"0", "1", "1"}, {"0", "1", "3"}} {
+			if err := g.SetOrder(order); err == nil {
+				t.Errorf("expected %v to be rejected", order)
+			}
+		}
+		if fmt.Sprint(g.Order) != "[0 1 2]" {
+			t.Error("expected order to be unchanged, got", g.Order)
+		}
+	})
+
+	t.Run("should remove players from the order", func(t *testing.T) {
+		g := setup()
+		g.RemovePlayer("1")
+		if len(g.Players) != 2 || fmt.Sprint(g.Order) != "[0 2]" {
+			t.Error("expected player 1 to be removed, got", g.Order)
+		}
+	})
+}
+
 func TestAddPlayer(t *testing.T) {
 	t.Run("should add players to the game", func(t *testing.T) {
 		g := NewGame()
@@ -773,3 +814,72 @@ func TestEndTurn(t *testing.T) {
 		}
 	})
 }
+
+func TestClaims(t *testing.T) {
+	setup := func() Game {
+		g := NewGame()
+		g.AddPlayer("0", "Test")
+		g.AddPlayer("1", "Test")
+		return g
+	}
+
+	t.Run("should record bluffed character actions", func(t *testing.T) {
+		g := setup()
+
+		g.AttemptAction(Action{Type: Tax})
+
+		if len(g.Claims) != 1 || !g.Claims[0].Bluff || g.Claims[0].Player != "0" {
+			t.Errorf("expected a bluffed claim by 0, got %+v", g.Claims)
+		}
+	})
+
+	t.Run("should not record actions which need no character", func(t *testing.T) {
+		g := setup()
+
+		g.AttemptAction(Action{Type: ForeignAid})
+
+		if len(g.Claims) != 0 {
+			t.Errorf("expected no claims, got %+v", g.Claims)
+		}
+	})
+
+	t.Run("should record who challenged a block", func(t *testing.T) {
+		g := setup()
+		g.Players["1"].Cards = append(g.Players["1"].Cards, CardState{Card: Duke, Alive: true})
+
+		g.AttemptAction(Action{Type: ForeignAid})
+		g.AttemptBlock(Block{Card: Duke, Initiator: "1"})
+		g.Challenge(Challenge{Initiator: "0"})
+
+		expected := Claim{Player: "1", Bluff: false, ChallengedBy: "0"}
+		if len(g.Claims) != 1 || g.Claims[0] != expected {
+			t.Errorf("expected claim %+v, got %+v", expected, g.Claims)
+		}
+	})
+}
+
+func TestPlacings(t *testing.T) {
+	t.Run("should place the winner first and the first eliminated last", func(t *testing.T) {
+		g := NewGame()
+		g.AddPlayer("0", "Test")
+		g.AddPlayer("1", "Test")
+		g.AddPlayer("2", "Test")
+		for _, id := range g.Order {
+			g.Players[id].Cards = []CardState{{Card: Duke, Alive: true}}
+		}
+
+		for _, id := range []string{"1", "2"} {
+			g.TurnState = PlayerKilled
+			g.NextDeath = id
+			g.ResolveDeath(0)
+		}
+		g.TurnState = Finished
+		g.EndTurn()
+
+		placings := g.Placings()
+		expected := []string{"0", "2", "1"}
+		if fmt.Sprint(placings) != fmt.Sprint(expected) {
+			t.Errorf("expected placings %v, got %v", expected, placings)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Set once the server is ready to accept games.
+var ready atomic.Bool
+
+// Liveness probe - responds as long as the process is serving HTTP.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// Readiness probe - responds with 503 until the server has finished starting up, and while
+// it has lost its connection to the rest of the cluster.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if !im.cluster.Connected() {
+		http.Error(w, "cluster unreachable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
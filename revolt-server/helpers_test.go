@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Creates a lobby owned by the first of `players` players, numbered from "0". The instance
+// isn't running, so messages are applied directly with `sendMessage`.
+func newTestGame(players int) (*GameInstance, []*Client) {
+	i := NewGameInstance("0")
+	clients := []*Client{}
+	for n := range players {
+		id := strconv.Itoa(n)
+		client := NewClient(nil, "Player "+id, slog.Default())
+		client.Id = id
+		i.Game.AddPlayer(id, client.Name)
+		i.Clients[id] = client
+		clients = append(clients, client)
+	}
+	return i, clients
+}
+
+// Adds a spectator with the ID "s" to a test game.
+func addTestSpectator(i *GameInstance) *Client {
+	spectator := NewClient(nil, "Watcher", slog.Default())
+	spectator.Id = "s"
+	spectator.Spectator = true
+	i.Spectators["s"] = spectator
+	return spectator
+}
+
+// Applies a message from a client to a test game, returning any rejection.
+func sendMessage(i *GameInstance, client *Client, messageType MessageType, payload any) error {
+	return i.HandleMessage(client, Message{Type: messageType, Payload: NewPayload(payload)})
+}
+
+// Reports whether a command was rejected for the given reason.
+func rejectedWith(err error, reason string) bool {
+	var commandErr *CommandError
+	return errors.As(err, &commandErr) && commandErr.Reason == reason
+}
+
+// Returns the payloads of the messages of a type queued for a client, discarding the rest.
+func received[T any](client *Client, messageType ServerMessageType) []T {
+	payloads := []T{}
+	for {
+		select {
+		case bytes := <-client.send:
+			var message struct {
+				Type    ServerMessageType `json:"type"`
+				Payload T                 `json:"payload"`
+			}
+			json.Unmarshal(bytes, &message)
+			if message.Type == messageType {
+				payloads = append(payloads, message.Payload)
+			}
+		default:
+			return payloads
+		}
+	}
+}
+
+// Starts a server with every route and a fresh instance manager, stopping the server and
+// every instance once the test ends. Games can be created freely.
+func newTestServer(t *testing.T) *httptest.Server {
+	initInstanceManager()
+	ipMessageLimits = newIPLimiter(IPMessageBurst, IPMessageRate)
+	ipCreateLimits = newIPLimiter(CreateBurst, 1)
+	server := httptest.NewServer(NewServeMux(Config{}))
+	t.Cleanup(func() {
+		server.Close()
+		for _, instance := range im.ListInstances() {
+			instance.Stop()
+		}
+	})
+	return server
+}
+
+// Starts an empty game, stopped along with the test server.
+func startTestInstance() *GameInstance {
+	instance := NewGameInstance("")
+	im.StartInstance(instance)
+	return instance
+}
+
+// Opens a websocket to a game by ID or invite code, closing it once the test ends.
+func dialGame(t *testing.T, server *httptest.Server, key string, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + key + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Waits until `condition` holds, checking it on the instance goroutine.
+func waitUntil(t *testing.T, instance *GameInstance, description string, condition func() bool) {
+	t.Helper()
+	for range 100 {
+		done := false
+		instance.Do(func() { done = condition() })
+		if done {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %s", description)
+}
+
+// Waits until a game has `count` players.
+func waitForPlayers(t *testing.T, instance *GameInstance, count int) {
+	t.Helper()
+	waitUntil(t, instance, fmt.Sprintf("%d players", count), func() bool {
+		return len(instance.Game.Players) == count
+	})
+}
+
+// Waits until a game has `count` connected players.
+func waitForClients(t *testing.T, instance *GameInstance, count int) {
+	t.Helper()
+	waitUntil(t, instance, fmt.Sprintf("%d connected clients", count), func() bool {
+		return len(instance.Clients) == count
+	})
+}
@@ -1,8 +1,9 @@
 package main
 
 import (
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"revolt/game"
 	"time"
 )
@@ -17,78 +18,630 @@ const (
 	Complete   GameStatus = "complete"
 )
 
+// Every instance status, in the order an instance moves through them.
+var GameStatuses = []GameStatus{Lobby, InProgress, Complete}
+
 // A single instance of a game.
 type GameInstance struct {
-	GameId  string
-	OwnerId string
-	Status  GameStatus
-	Game    game.Game
+	GameId string
+	// A short code players can join the game with in place of its ID.
+	InviteCode string
+	OwnerId    string
+	Status     GameStatus
+	Game       game.Game
+	// Connected players, keyed by player ID.
 	Clients map[string]*Client
+	// Connected clients watching the game, keyed by client ID.
+	Spectators map[string]*Client
+	Logger     *slog.Logger // Logger carrying the game ID.
+	// The accounts of players who joined as one, keyed by player ID.
+	Accounts map[string]PlayerAccount
+	// The rules preset the game is played with.
+	Preset string
+	// Whether the result counts towards players' ratings.
+	Rated bool
+	// Seats reserved for particular players, keyed by player ID. If set, only players with a
+	// reserved seat can join.
+	Seats map[string]Seat
+	// Players who are ready to start, keyed by player ID.
+	Ready map[string]bool
+	// Time between the owner starting the game and cards being dealt.
+	Countdown time.Duration
+	// When the countdown to starting ends, or zero if the game isn't counting down.
+	startsAt time.Time
+	// Identifies the running countdown, so a cancelled countdown's timer knows to stop.
+	countdownId int
+	// Checks chat messages before they're sent.
+	ChatFilter ChatFilter
+	// Clients the owner has muted, keyed by ID.
+	Muted map[string]bool
+	// Recent chat messages in each channel.
+	chatHistory map[ChatChannel][]ChatEntry
+	// Account IDs the owner has banned from the game.
+	Banned map[string]bool
+	// Called on the instance goroutine when the game is won.
+	OnComplete func(*GameInstance)
+	// Hash of the password needed to join, or nil if the game has none.
+	passwordHash []byte
+
+	// Incremented on every state broadcast, so clients can say which state a command was based on.
+	Version int
+
+	Register   chan *Client  // Channel to register new clients with the game instance.
+	Unregister chan *Client  // Channel to remove disconnected clients from the game instance.
+	Commands   chan Command  // Channel of client messages to apply to the game.
+	SendState  chan bool     // Channel to trigger a state broadcast
+	tasks      chan func()   // Channel of functions to run on the instance goroutine.
+	done       chan struct{} // Closed when the instance is stopped.
+}
+
+// A seat reserved for a player, by matchmaking.
+type Seat struct {
+	Name    string
+	Account *PlayerAccount
+}
 
-	// TODO unregister clients as well.
-	Register  chan *Client // Channel to register new clients with the game instance.
-	SendState chan bool    // Channel to trigger a state broadcast
+// A message received from a client, to be applied by the client's game instance.
+type Command struct {
+	Client  *Client
+	Message Message
 }
 
 // Creates a new game instance in the `Lobby` status.
-func NewGameInstance(ownerId string) GameInstance {
-	return GameInstance{
-		GameId:    game.Id(),
-		OwnerId:   ownerId,
-		Status:    Lobby,
-		Clients:   make(map[string]*Client),
-		Game:      game.NewGame(),
-		Register:  make(chan *Client),
-		SendState: make(chan bool),
+func NewGameInstance(ownerId string) *GameInstance {
+	id := game.Id()
+	return &GameInstance{
+		GameId:      id,
+		OwnerId:     ownerId,
+		Logger:      slog.Default().With("game", id),
+		Status:      Lobby,
+		Clients:     make(map[string]*Client),
+		Spectators:  make(map[string]*Client),
+		Accounts:    make(map[string]PlayerAccount),
+		Banned:      make(map[string]bool),
+		Ready:       make(map[string]bool),
+		Countdown:   startCountdown,
+		ChatFilter:  chatFilter,
+		Muted:       make(map[string]bool),
+		chatHistory: make(map[ChatChannel][]ChatEntry),
+		Preset:      DefaultRulesPreset,
+		Rated:       true,
+		Game:        game.NewGame(),
+		Register:    make(chan *Client),
+		Unregister:  make(chan *Client),
+		Commands:    make(chan Command),
+		SendState:   make(chan bool),
+		tasks:       make(chan func()),
+		done:        make(chan struct{}),
+	}
+}
+
+// Moves the instance to a new status, keeping the instance metrics in step.
+func (gi *GameInstance) SetStatus(status GameStatus) {
+	metrics.Instances.Dec(string(gi.Status))
+	metrics.Instances.Inc(string(status))
+	gi.Status = status
+}
+
+// Registers a client with the instance. Returns false if the instance has been stopped.
+func (gi *GameInstance) Join(client *Client) bool {
+	select {
+	case gi.Register <- client:
+		return true
+	case <-gi.done:
+		return false
+	}
+}
+
+// Removes a client from the instance, closing it.
+func (gi *GameInstance) Leave(client *Client) {
+	select {
+	case gi.Unregister <- client:
+	case <-gi.done:
+		client.Close()
+	}
+}
+
+// Submits a client message to be applied on the instance goroutine.
+// Returns false if the instance has been stopped.
+func (gi *GameInstance) Submit(client *Client, message Message) bool {
+	select {
+	case gi.Commands <- Command{Client: client, Message: message}:
+		return true
+	case <-gi.done:
+		return false
 	}
 }
 
-// Receives client registrations on the Register channel and handles state broadcast requests.
+// Finds the connected client whose session is held in `token`.
+func (gi *GameInstance) Authenticate(token string) (*Client, bool) {
+	session, err := sessions.Verify(token)
+	if err != nil || session.GameId != gi.GameId {
+		return nil, false
+	}
+	var found *Client
+	gi.Do(func() {
+		found = gi.Clients[session.PlayerId]
+		if found == nil {
+			found = gi.Spectators[session.PlayerId]
+		}
+	})
+	return found, found != nil
+}
+
+// Returns the ID of the player who joined as an account.
+// Must be called on the instance goroutine.
+func (gi *GameInstance) playerForAccount(accountId string) (string, bool) {
+	for playerId, account := range gi.Accounts {
+		if account.Id == accountId {
+			return playerId, true
+		}
+	}
+	return "", false
+}
+
+// Runs `f` on the instance goroutine and waits for it to return, giving it exclusive access
+// to the instance. Returns false without running `f` if the instance has been stopped.
+func (gi *GameInstance) Do(f func()) bool {
+	finished := make(chan struct{})
+	task := func() {
+		defer close(finished)
+		f()
+	}
+	select {
+	case gi.tasks <- task:
+		<-finished
+		return true
+	case <-gi.done:
+		return false
+	}
+}
+
+// Stops the instance goroutine and disconnects all clients.
+// Must not be called from the instance goroutine.
+func (gi *GameInstance) Stop() {
+	gi.Do(func() {
+		close(gi.done)
+	})
+}
+
+// Handles client registrations, client commands and state broadcast requests until stopped.
 func (gi *GameInstance) Run() {
-	log.Printf("running new game instance %s", gi.GameId)
+	gi.Logger.Info("running new game instance")
 	for {
 		select {
 		// Registers a client with the current game instance.
 		case client := <-gi.Register:
-			client.Log("registering client %s with game %s...", client.Name, gi.GameId)
+			client.Logger.Info("registering client with game")
+
+			if gi.isBanned(client) {
+				client.Logger.Info("refused banned client")
+				client.Send(ServerMessage{Type: KickedMessage, Payload: KickedPayload{Banned: true}})
+				client.Close()
+				continue
+			}
+
+			// A client resuming a session takes over its player, replacing any earlier connection.
+			if player, ok := gi.Game.Players[client.Id]; ok {
+				client.Name = player.Name
+				if previous, ok := gi.Clients[client.Id]; ok {
+					previous.Close()
+				}
+				gi.Clients[client.Id] = client
+				gi.sendChatHistory(client)
+				gi.refresh()
+				continue
+			}
+
+			// Clients who asked to watch, or who join once the game has started or filled up,
+			// become spectators. Games with reserved seats are never full, as every seat is
+			// reserved.
+			full := len(gi.Game.Players) >= game.MaxPlayers
+			if client.Spectator || gi.Status != Lobby || (gi.Seats == nil && full) {
+				if previous, ok := gi.Spectators[client.Id]; ok {
+					previous.Close()
+				}
+				client.Spectator = true
+				gi.Spectators[client.Id] = client
+				client.Logger.Info("client joined as a spectator")
+				gi.sendChatHistory(client)
+				gi.refresh()
+				continue
+			}
+
+			// Games with reserved seats only admit the players they were reserved for.
+			if gi.Seats != nil {
+				seat, ok := gi.Seats[client.Id]
+				if !ok {
+					client.Logger.Warn("client has no reserved seat")
+					client.Close()
+					continue
+				}
+				client.Name = seat.Name
+				client.Account = seat.Account
+			}
 
 			// Add the player to the current game instance.
 			err := gi.Game.AddPlayer(client.Id, client.Name)
 			if err != nil {
-				client.Log("error registering client with game instance")
+				client.Logger.Warn("error registering client with game instance", "error", err)
 				continue
 			}
 
+			// Games created without an owner, such as matchmade games, are owned by the first
+			// player to join.
+			if gi.OwnerId == "" {
+				gi.OwnerId = client.Id
+			}
 			gi.Clients[client.Id] = client
+			if client.Account != nil {
+				gi.Accounts[client.Id] = *client.Account
+			}
+			gi.sendChatHistory(client)
+			gi.broadcast()
+
+		// Removes a disconnected client and stops its writer.
+		case client := <-gi.Unregister:
+			client.Logger.Info("unregistering client from game")
+			client.Close()
+
+			if gi.Spectators[client.Id] == client {
+				delete(gi.Spectators, client.Id)
+				gi.refresh()
+				continue
+			}
+
+			// The client's session may already have been resumed on another connection.
+			if gi.Clients[client.Id] != client {
+				continue
+			}
+			delete(gi.Clients, client.Id)
+
+			// Players in a running game keep their seat, so they can reconnect with their token.
+			if gi.Status == InProgress {
+				gi.refresh()
+				continue
+			}
+			gi.removePlayer(client.Id)
+			gi.broadcast()
+
+		// Applies a client's message to the game.
+		case command := <-gi.Commands:
+			gi.handleCommand(command)
+
+		case task := <-gi.tasks:
+			task()
 
 		// Triggers a broadcast of the current instance state to all connected clients.
 		case <-gi.SendState:
-			log.Printf("broadcasting state to game instance %s", gi.GameId)
+			gi.broadcast()
 
+		case <-gi.done:
+			gi.Logger.Info("game instance stopped")
 			for _, client := range gi.Clients {
-				update := gi.ToClientStateBroadcast(client)
-				bytes, err := update.Serialise()
-				if err != nil {
-					break
-				}
-				client.Send <- bytes
+				client.Close()
+			}
+			for _, spectator := range gi.Spectators {
+				spectator.Close()
 			}
+			return
+		}
+	}
+}
+
+// Applies a command, broadcasting the new state if it succeeds and recording the
+// rejection if not. Commands carrying a request ID are acknowledged, and a repeated request ID
+// is acknowledged with the original outcome without being applied again.
+func (gi *GameInstance) handleCommand(command Command) {
+	client := command.Client
+	message := command.Message
+	logger := client.Logger.With("type", message.Type)
+
+	// Resyncs only affect the requesting client, so don't change the version.
+	if message.Type == ResyncMessage {
+		client.RequestSnapshot()
+		gi.sendState(client)
+		return
+	}
+
+	if message.RequestId != "" {
+		if ack, ok := client.requests.Get(message.RequestId); ok {
+			logger.Debug("duplicate request", "requestId", message.RequestId)
+			ack.Status = AckDuplicate
+			gi.acknowledge(client, ack)
+			return
+		}
+	}
+
+	var err error
+	if client.Spectator && !message.Type.Transient() {
+		err = rejectCommand(RejectNotInGame, "spectators can only chat")
+	}
+	if err == nil && !message.Type.Transient() {
+		err = gi.checkVersion(message)
+	}
+	if err == nil {
+		err = gi.HandleMessage(client, message)
+	}
+
+	ack := AckPayload{RequestId: message.RequestId, Status: AckApplied}
+	if err != nil {
+		reason := RejectInvalidMove
+		var commandErr *CommandError
+		if errors.As(err, &commandErr) {
+			reason = commandErr.Reason
+		}
+		logger.Warn("command rejected", "reason", reason, "error", err, "turnState", gi.Game.TurnState)
+		metrics.CommandsRejected.Inc(reason)
+		ack.Status = AckRejected
+		ack.Reason = reason
+		ack.Error = err.Error()
+	} else if !message.Type.Transient() {
+		gi.broadcast()
+	}
+
+	if message.RequestId != "" {
+		ack.Version = gi.Version
+		client.requests.Add(message.RequestId, ack)
+		gi.acknowledge(client, ack)
+	}
+}
+
+// Rejects a message based on a state older than the current one.
+func (gi *GameInstance) checkVersion(message Message) error {
+	if message.BaseVersion != 0 && message.BaseVersion != gi.Version {
+		return rejectCommand(RejectStale, "command based on version %d, current version is %d",
+			message.BaseVersion, gi.Version)
+	}
+	return nil
+}
+
+// Queues an acknowledgement for a client.
+func (gi *GameInstance) acknowledge(client *Client, ack AckPayload) {
+	client.Send(ServerMessage{Type: AckMessage, Payload: ack})
+}
+
+// An error returned when a client command is rejected, holding the reason used to label metrics.
+type CommandError struct {
+	Reason string
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Creates a CommandError with a formatted message.
+func rejectCommand(reason string, format string, v ...any) *CommandError {
+	return &CommandError{Reason: reason, Err: fmt.Errorf(format, v...)}
+}
+
+// Applies a single client message to the game. Must be called on the instance goroutine.
+func (gi *GameInstance) HandleMessage(client *Client, message Message) error {
+	// The game can be ended early by an admin, which leaves it mid-turn.
+	if message.Type.Move() && gi.Status != InProgress {
+		return rejectCommand(RejectNotInProgress, "the game isn't in progress")
+	}
+
+	switch message.Type {
+	case StartGameMessage:
+		if client.Id != gi.OwnerId {
+			return rejectCommand(RejectNotOwner, "can't start game (owned by %s)", gi.OwnerId)
+		}
+		return gi.requestStart()
+
+	case ReadyMessage, UnreadyMessage:
+		return gi.setReady(client, message.Type == ReadyMessage)
+
+	case KickPlayerMessage, BanPlayerMessage:
+		var payload PlayerPayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		return gi.kick(client, payload.PlayerId, message.Type == BanPlayerMessage)
+
+	case ReorderSeatsMessage:
+		var payload ReorderSeatsPayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		return gi.reorderSeats(client, payload.Order)
+
+	case TransferOwnerMessage:
+		var payload PlayerPayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		return gi.transferOwner(client, payload.PlayerId)
+
+	case SendChatMessage:
+		var payload ChatPayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		return gi.chat(client, payload.Text)
+
+	case ReactMessage:
+		var payload ReactPayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		return gi.react(client, payload)
+
+	case MutePlayerMessage, UnmutePlayerMessage:
+		var payload PlayerPayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		return gi.setMuted(client, payload.PlayerId, message.Type == MutePlayerMessage)
+
+	case AttemptActionMessage:
+		var payload AttemptActionPayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		err = gi.Game.AttemptAction(payload.Action)
+		if err != nil {
+			return fmt.Errorf("couldn't attempt action: %w", err)
+		}
+
+	case AttemptBlockMessage:
+		var payload AttemptBlockPayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		// Set initiator - even if provided, we don't want to allow impersonating other players.
+		payload.Block.Initiator = client.Id
+		err = gi.Game.AttemptBlock(payload.Block)
+		if err != nil {
+			return fmt.Errorf("couldn't attempt block: %w", err)
 		}
+
+	case ChallengeMessage:
+		var payload ChallengePayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		// Set initiator - even if provided, we don't want to allow impersonating other players.
+		payload.Challenge.Initiator = client.Id
+		err = gi.Game.Challenge(payload.Challenge)
+		if err != nil {
+			return fmt.Errorf("couldn't attempt challenge: %w", err)
+		}
+
+	case ResolveDeathMessage:
+		var payload ResolveDeathPayload
+		err := message.Payload.Decode(&payload)
+		if err != nil {
+			return rejectCommand(RejectInvalidPayload, "error reading message: %w", err)
+		}
+		err = gi.Game.ResolveDeath(payload.Card)
+		if err != nil {
+			return fmt.Errorf("couldn't resolve death action: %w", err)
+		}
+
+	case CommitTurnMessage:
+		err := gi.Game.CommitTurn()
+		if err != nil {
+			return fmt.Errorf("couldn't commit action: %w", err)
+		}
+
+	case EndTurnMessage:
+		err := gi.Game.EndTurn()
+		if err != nil {
+			return fmt.Errorf("couldn't end turn: %w", err)
+		}
+		if gi.Game.TurnState == game.PlayerWon {
+			gi.complete()
+		}
+
+	default:
+		return rejectCommand(RejectUnknownType, "unknown message type: %s", message.Type)
+	}
+
+	return nil
+}
+
+// Marks the game complete, recording its result and calling `OnComplete`. Games ended before
+// cards were dealt weren't played, so aren't rated. Must be called on the instance goroutine.
+func (gi *GameInstance) complete() {
+	played := gi.Status == InProgress
+	gi.SetStatus(Complete)
+	metrics.GamesCompleted.Inc("")
+	gi.Logger.Info("game complete", "winner", gi.Game.Winner)
+	if played {
+		recordResult(gi)
+	}
+	if gi.OnComplete != nil {
+		gi.OnComplete(gi)
+	}
+}
+
+// Moves to a new version after the game or lobby changes, and queues the new state for every
+// connected client.
+func (gi *GameInstance) broadcast() {
+	gi.Version++
+	gi.refresh()
+}
+
+// Queues the current instance state for every connected client without changing its version,
+// for changes that don't affect play, such as spectators coming and going. Commands based on
+// the current version stay valid.
+// Queueing never blocks, so a slow client can't hold up the rest of the game.
+func (gi *GameInstance) refresh() {
+	gi.Logger.Debug("broadcasting state", "version", gi.Version, "turnState", gi.Game.TurnState, "clients", len(gi.Clients))
+	start := time.Now()
+	defer func() {
+		metrics.BroadcastDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	for _, client := range gi.Clients {
+		gi.sendState(client)
+	}
+	for _, spectator := range gi.Spectators {
+		gi.sendState(spectator)
+	}
+}
+
+// Queues the current instance state for a single client.
+func (gi *GameInstance) sendState(client *Client) {
+	update := gi.ToClientStateBroadcast(client)
+	bytes, err := update.Serialise(client.Codec)
+	if err != nil {
+		client.Logger.Error("failed to serialise state", "error", err)
+		return
+	}
+	metrics.BroadcastSize.Observe(float64(len(bytes)))
+	client.EnqueueState(bytes)
+}
+
+// Queues a message for every connected client.
+func (gi *GameInstance) SendAll(message ServerMessage) {
+	for _, client := range gi.Clients {
+		client.Send(message)
+	}
+	for _, spectator := range gi.Spectators {
+		spectator.Send(message)
 	}
 }
 
 // A client state update.
 // This should contain everything a client needs to play, but nothing that would allow cheating.
 type ClientStateBroadcast struct {
+	Type ServerMessageType `json:"type"`
+	// Increases whenever the game or lobby changes. Clients send this back as a command's base
+	// version.
+	Version int `json:"version"`
+
 	// ISO timestamp
 	Timestamp time.Time `json:"timestamp"`
 
 	// Session and client info.
-	GameId  string     `json:"gameId"`
-	OwnerId string     `json:"ownerId"`
-	Self    Peer       `json:"self"`
-	Peers   []Peer     `json:"peers"`
-	Status  GameStatus `json:"status"`
+	GameId     string     `json:"gameId"`
+	InviteCode string     `json:"inviteCode"`
+	OwnerId    string     `json:"ownerId"`
+	Self       Peer       `json:"self"`
+	Peers      []Peer     `json:"peers"`
+	Status     GameStatus `json:"status"`
+	// Set if the client is watching rather than playing.
+	Spectator bool `json:"spectator,omitempty"`
+	// The number of clients watching the game.
+	Spectators int `json:"spectators"`
+	// When cards will be dealt, while the game is counting down to starting.
+	StartsAt *time.Time `json:"startsAt,omitempty"`
 
 	// Game info.
 	TurnState        game.TurnState `json:"turnState"`
@@ -105,17 +658,17 @@ type Peer struct {
 	Cards          []game.CardState  `json:"cards"`
 	Credits        int               `json:"credits"`
 	Leading        bool              `json:"leading"`
+	Ready          bool              `json:"ready"`
 	AllowedActions []game.ActionType `json:"allowedActions"`
+	// Set for players who joined as an account.
+	Account *PlayerAccount `json:"account,omitempty"`
+	// Set for players the owner has muted.
+	Muted bool `json:"muted,omitempty"`
 }
 
-// Converts a state update message to a JSON byte array.
-func (s *ClientStateBroadcast) Serialise() ([]byte, error) {
-	bytes, err := json.Marshal(s)
-	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
-	return bytes, nil
+// Encodes a state update message for the wire.
+func (s *ClientStateBroadcast) Serialise(codec Codec) ([]byte, error) {
+	return codec.Marshal(s)
 }
 
 func (gi *GameInstance) ToClientStateBroadcast(client *Client) ClientStateBroadcast {
@@ -131,6 +684,11 @@ func (gi *GameInstance) ToClientStateBroadcast(client *Client) ClientStateBroadc
 			Cards:   player.GetDeadCards(),
 			Credits: player.Credits,
 			Leading: i == gi.Game.Leader,
+			Ready:   gi.Ready[id],
+			Muted:   gi.Muted[id],
+		}
+		if account, ok := gi.Accounts[id]; ok {
+			peer.Account = &account
 		}
 
 		if player.Id == client.Id {
@@ -142,12 +700,23 @@ func (gi *GameInstance) ToClientStateBroadcast(client *Client) ClientStateBroadc
 		peers = append(peers, peer)
 	}
 
+	var startsAt *time.Time
+	if !gi.startsAt.IsZero() {
+		startsAt = &gi.startsAt
+	}
+
 	return ClientStateBroadcast{
-		Timestamp: time.Now(),
-		GameId:    gi.GameId,
-		OwnerId:   gi.OwnerId,
-		Status:    gi.Status,
-		TurnState: gi.Game.TurnState,
+		Type:       StateMessage,
+		Version:    gi.Version,
+		Timestamp:  time.Now(),
+		GameId:     gi.GameId,
+		InviteCode: gi.InviteCode,
+		OwnerId:    gi.OwnerId,
+		Status:     gi.Status,
+		StartsAt:   startsAt,
+		Spectator:  gi.isSpectator(client),
+		Spectators: len(gi.Spectators),
+		TurnState:  gi.Game.TurnState,
 
 		Self:             self,
 		Peers:            peers,
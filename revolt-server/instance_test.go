@@ -1,13 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"log/slog"
 	"reflect"
 	"revolt/game"
 	"testing"
 )
 
 func TestToClientStateBroadCast(t *testing.T) {
-	setup := func() GameInstance {
+	setup := func() *GameInstance {
 		i := NewGameInstance("0")
 		i.Game.AddPlayer("0", "Player One")
 		i.Game.AddPlayer("1", "Player Two")
@@ -75,3 +77,115 @@ func TestToClientStateBroadCast(t *testing.T) {
 		}
 	})
 }
+
+func TestHandleCommand(t *testing.T) {
+	setup := func() (*GameInstance, *Client) {
+		i := NewGameInstance("")
+		i.Countdown = 0
+		go i.Run()
+		owner := NewClient(nil, "Player One", slog.Default())
+		i.Join(owner)
+		i.Join(NewClient(nil, "Player Two", slog.Default()))
+		// Both players are ready, so the owner can start the game.
+		i.Do(func() {
+			for id := range i.Game.Players {
+				i.Ready[id] = true
+			}
+		})
+		return i, owner
+	}
+
+	readAck := func(t *testing.T, c *Client) AckPayload {
+		var message struct {
+			Type    ServerMessageType `json:"type"`
+			Payload AckPayload        `json:"payload"`
+		}
+		err := json.Unmarshal(<-c.send, &message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.Type != AckMessage {
+			t.Fatalf("expected ack, got %s", message.Type)
+		}
+		return message.Payload
+	}
+
+	t.Run("should include the state version in broadcasts", func(t *testing.T) {
+		i, owner := setup()
+		defer i.Stop()
+
+		var broadcast ClientStateBroadcast
+		i.Do(func() { broadcast = i.ToClientStateBroadcast(owner) })
+
+		// Each client registration triggers a broadcast.
+		if broadcast.Version != 2 {
+			t.Errorf("expected version 2, got %d", broadcast.Version)
+		}
+	})
+
+	t.Run("should keep the version when spectators come and go", func(t *testing.T) {
+		i, owner := setup()
+		defer i.Stop()
+
+		spectator := NewClient(nil, "Watcher", slog.Default())
+		spectator.Spectator = true
+		i.Join(spectator)
+		i.Leave(spectator)
+
+		var broadcast ClientStateBroadcast
+		i.Do(func() { broadcast = i.ToClientStateBroadcast(owner) })
+		if broadcast.Version != 2 || broadcast.Spectators != 0 {
+			t.Errorf("expected version 2 without spectators, got %d with %d", broadcast.Version, broadcast.Spectators)
+		}
+	})
+
+	t.Run("should acknowledge commands with a request ID", func(t *testing.T) {
+		i, owner := setup()
+		defer i.Stop()
+
+		i.Submit(owner, Message{Type: StartGameMessage, RequestId: "a", BaseVersion: 2})
+
+		ack := readAck(t, owner)
+		if ack.RequestId != "a" || ack.Status != AckApplied || ack.Version != 3 {
+			t.Errorf("expected applied ack at version 3, got %+v", ack)
+		}
+	})
+
+	t.Run("should not re-apply duplicate request IDs", func(t *testing.T) {
+		i, owner := setup()
+		defer i.Stop()
+
+		i.Submit(owner, Message{Type: StartGameMessage, RequestId: "a"})
+		readAck(t, owner)
+		i.Submit(owner, Message{Type: StartGameMessage, RequestId: "a"})
+
+		ack := readAck(t, owner)
+		if ack.Status != AckDuplicate || ack.Version != 3 {
+			t.Errorf("expected duplicate ack at version 3, got %+v", ack)
+		}
+
+		var cards int
+		i.Do(func() { cards = len(i.Game.Players[owner.Id].Cards) })
+		if cards != 2 {
+			t.Errorf("expected cards to be dealt once, got %d cards", cards)
+		}
+	})
+
+	t.Run("should reject stale commands", func(t *testing.T) {
+		i, owner := setup()
+		defer i.Stop()
+
+		i.Submit(owner, Message{Type: StartGameMessage, RequestId: "a", BaseVersion: 1})
+
+		ack := readAck(t, owner)
+		if ack.Status != AckRejected || ack.Reason != RejectStale {
+			t.Errorf("expected stale rejection, got %+v", ack)
+		}
+
+		var status GameStatus
+		i.Do(func() { status = i.Status })
+		if status != Lobby {
+			t.Errorf("expected game not to start, got status %s", status)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordKey = "password"
+
+	InviteCodeLength = 6
+	// The longest password a game can be given. bcrypt ignores anything past 72 bytes.
+	MaxGamePasswordLength = 64
+)
+
+// Characters used in invite codes. Letters and digits that are easily confused when read aloud
+// or written down (0/O, 1/I/L) are left out.
+const inviteAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+var (
+	ErrIncorrectPassword = errors.New("incorrect password")
+	ErrPasswordTooLong   = errors.New("password must be at most 64 characters")
+)
+
+// Generates a random invite code.
+func newInviteCode() string {
+	code := make([]byte, InviteCodeLength)
+	max := big.NewInt(int64(len(inviteAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		code[i] = inviteAlphabet[n.Int64()]
+	}
+	return string(code)
+}
+
+// Invite codes are matched regardless of case and surrounding whitespace.
+func normaliseInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// The optional body of a request to `/create`.
+type CreateGameRequest struct {
+	// If set, players must give this password to join the game.
+	Password string `json:"password,omitempty"`
+}
+
+// Protects the instance with a password. Must be called before the instance is started.
+func (gi *GameInstance) SetPassword(password string) error {
+	if password == "" {
+		gi.passwordHash = nil
+		return nil
+	}
+	if len(password) > MaxGamePasswordLength {
+		return ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return err
+	}
+	gi.passwordHash = hash
+	return nil
+}
+
+// Whether players need a password to join the instance.
+func (gi *GameInstance) HasPassword() bool {
+	return gi.passwordHash != nil
+}
+
+// Checks a password given by a joining player. Instances without a password accept anything.
+func (gi *GameInstance) CheckPassword(password string) error {
+	if gi.passwordHash == nil {
+		return nil
+	}
+	if bcrypt.CompareHashAndPassword(gi.passwordHash, []byte(password)) != nil {
+		return ErrIncorrectPassword
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPrivateGames(t *testing.T) {
+	passwordCost = bcrypt.MinCost
+
+	create := func(t *testing.T, server *httptest.Server, body string) (ConnectionResponse, *GameInstance) {
+		t.Helper()
+		res, err := http.Post(server.URL+"/api/create", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", res.StatusCode)
+		}
+		var response ConnectionResponse
+		json.NewDecoder(res.Body).Decode(&response)
+		instance, ok := im.GetInstance(response.Id)
+		if !ok {
+			t.Fatal("expected an instance to have been created")
+		}
+		return response, instance
+	}
+
+	// Connects to a game by ID or invite code, returning an error if the connection is closed
+	// instead of being sent a state update.
+	connect := func(t *testing.T, server *httptest.Server, key string, query string) error {
+		t.Helper()
+		conn := dialGame(t, server, key, query)
+		for {
+			_, bytes, err := conn.ReadMessage()
+			if err != nil {
+				return err
+			}
+			var message struct {
+				Type ServerMessageType `json:"type"`
+			}
+			json.Unmarshal(bytes, &message)
+			if message.Type == StateMessage {
+				return nil
+			}
+		}
+	}
+
+	t.Run("should make the creator the owner, whoever connects first", func(t *testing.T) {
+		server := newTestServer(t)
+		response, instance := create(t, server, "")
+		if response.Token == "" || response.PlayerId == "" {
+			t.Fatalf("expected the creator to be sent a session, got %+v", response)
+		}
+
+		if err := connect(t, server, response.Id, "?name=Stranger"); err != nil {
+			t.Fatal(err)
+		}
+		if err := connect(t, server, response.Id, "?name=Creator&token="+response.Token); err != nil {
+			t.Fatal(err)
+		}
+		waitForPlayers(t, instance, 2)
+
+		instance.Do(func() {
+			if instance.OwnerId != response.PlayerId {
+				t.Errorf("expected %s to own the game, got %s", response.PlayerId, instance.OwnerId)
+			}
+			if instance.Game.Players[response.PlayerId].Name != "Creator" {
+				t.Error("expected the creator to join as the owning player")
+			}
+		})
+	})
+
+	t.Run("should join games by invite code", func(t *testing.T) {
+		server := newTestServer(t)
+		response, instance := create(t, server, "")
+		if len(response.InviteCode) != InviteCodeLength {
+			t.Fatalf("expected a %d character invite code, got %q", InviteCodeLength, response.InviteCode)
+		}
+
+		if err := connect(t, server, strings.ToLower(response.InviteCode), "?name=One"); err != nil {
+			t.Fatal(err)
+		}
+		waitForPlayers(t, instance, 1)
+	})
+
+	t.Run("should require the password from new players", func(t *testing.T) {
+		server := newTestServer(t)
+		response, instance := create(t, server, `{"password":"hunter2"}`)
+
+		if err := connect(t, server, response.InviteCode, "?name=One"); err == nil {
+			t.Error("expected a player without the password to be refused")
+		}
+		if err := connect(t, server, response.InviteCode, "?name=Two&password=wrong"); err == nil {
+			t.Error("expected a player with the wrong password to be refused")
+		}
+		if err := connect(t, server, response.InviteCode, "?name=Three&password=hunter2"); err != nil {
+			t.Fatal(err)
+		}
+		// The creator's token admits them without the password.
+		if err := connect(t, server, response.Id, "?token="+response.Token); err != nil {
+			t.Fatal(err)
+		}
+		waitForPlayers(t, instance, 2)
+	})
+
+	t.Run("should reject passwords that are too long", func(t *testing.T) {
+		server := newTestServer(t)
+		body := `{"password":"` + strings.Repeat("a", MaxGamePasswordLength+1) + `"}`
+		res, err := http.Post(server.URL+"/api/create", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("should give every instance a distinct invite code", func(t *testing.T) {
+		initInstanceManager()
+		codes := map[string]bool{}
+		for range 50 {
+			instance := NewGameInstance("")
+			im.RegisterInstance(instance)
+			if codes[instance.InviteCode] {
+				t.Fatalf("invite code %s was reused", instance.InviteCode)
+			}
+			codes[instance.InviteCode] = true
+			if strings.Trim(instance.InviteCode, inviteAlphabet) != "" {
+				t.Errorf("invite code %s uses characters outside the alphabet", instance.InviteCode)
+			}
+		}
+	})
+}
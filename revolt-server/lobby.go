@@ -0,0 +1,269 @@
+package main
+
+import (
+	"math"
+	"revolt/game"
+	"slices"
+	"time"
+)
+
+// The default time between the owner starting a game and cards being dealt.
+const DefaultStartCountdown = 5 * time.Second
+
+// The countdown given to new instances, set from the server's configuration.
+var startCountdown = DefaultStartCountdown
+
+// Rejects lobby management commands from anyone but the owner, or once the game has started.
+func (gi *GameInstance) checkLobbyOwner(client *Client) error {
+	if client.Id != gi.OwnerId {
+		return rejectCommand(RejectNotOwner, "only the owner can manage the lobby (owned by %s)", gi.OwnerId)
+	}
+	if gi.Status != Lobby {
+		return rejectCommand(RejectNotInLobby, "the lobby can't be changed once the game has started")
+	}
+	return nil
+}
+
+// Whether the account the client joined as has been banned from the game.
+func (gi *GameInstance) isBanned(client *Client) bool {
+	return client.Account != nil && gi.Banned[client.Account.Id]
+}
+
+// Removes a player from the game. If the player owned the game, ownership passes to the player
+// seated after them, or to the next player to join if the game is left empty.
+// Must be called on the instance goroutine.
+func (gi *GameInstance) removePlayer(id string) {
+	seat := slices.Index(gi.Game.Order, id)
+	gi.Game.RemovePlayer(id)
+	delete(gi.Accounts, id)
+	delete(gi.Ready, id)
+	gi.checkCountdown()
+
+	if id != gi.OwnerId {
+		return
+	}
+	gi.OwnerId = ""
+	if len(gi.Game.Order) > 0 {
+		gi.OwnerId = gi.Game.Order[seat%len(gi.Game.Order)]
+	}
+	gi.Logger.Info("owner left, transferred ownership", "owner", gi.OwnerId)
+}
+
+// Removes a player from the lobby and disconnects them. Kicked players can rejoin, but banned
+// players' accounts are refused from then on. Anonymous players can't be banned, as they could
+// rejoin straight away as a new player.
+func (gi *GameInstance) kick(client *Client, playerId string, ban bool) error {
+	if err := gi.checkLobbyOwner(client); err != nil {
+		return err
+	}
+	if playerId == client.Id {
+		return rejectCommand(RejectInvalidMove, "the owner can't kick themselves")
+	}
+	if _, ok := gi.Game.Players[playerId]; !ok {
+		return rejectCommand(RejectNotInGame, "player with id %s not found", playerId)
+	}
+
+	if ban {
+		account, ok := gi.Accounts[playerId]
+		if !ok {
+			return rejectCommand(RejectAnonymous, "only players who joined as an account can be banned")
+		}
+		gi.Banned[account.Id] = true
+	}
+	gi.removePlayer(playerId)
+
+	// The player's connection unregisters them when it closes, which is ignored now they've
+	// been removed.
+	if target, ok := gi.Clients[playerId]; ok {
+		target.Send(ServerMessage{Type: KickedMessage, Payload: KickedPayload{Banned: ban}})
+		target.Close()
+		delete(gi.Clients, playerId)
+	}
+	gi.Logger.Info("player kicked", "player", playerId, "banned", ban)
+	return nil
+}
+
+// Changes the order players are seated in, which sets the turn order.
+func (gi *GameInstance) reorderSeats(client *Client, order []string) error {
+	if err := gi.checkLobbyOwner(client); err != nil {
+		return err
+	}
+	if err := gi.Game.SetOrder(order); err != nil {
+		return rejectCommand(RejectInvalidPayload, "couldn't reorder seats: %w", err)
+	}
+	return nil
+}
+
+// Makes another player the owner.
+func (gi *GameInstance) transferOwner(client *Client, playerId string) error {
+	if err := gi.checkLobbyOwner(client); err != nil {
+		return err
+	}
+	if _, ok := gi.Game.Players[playerId]; !ok {
+		return rejectCommand(RejectNotInGame, "player with id %s not found", playerId)
+	}
+	gi.OwnerId = playerId
+	gi.Logger.Info("ownership transferred", "owner", playerId)
+	return nil
+}
+
+// Marks a player as ready or not ready to start. A player who stops being ready cancels any
+// countdown to starting.
+func (gi *GameInstance) setReady(client *Client, ready bool) error {
+	if gi.Status != Lobby {
+		return rejectCommand(RejectNotInLobby, "the game has already started")
+	}
+	if _, ok := gi.Game.Players[client.Id]; !ok {
+		return rejectCommand(RejectNotInGame, "player with id %s not found", client.Id)
+	}
+	gi.Ready[client.Id] = ready
+	gi.checkCountdown()
+	return nil
+}
+
+// Checks the game can start, which needs it to be in the lobby with at least two ready players.
+// Players who aren't ready don't hold up the rest, and watch once the game starts.
+func (gi *GameInstance) checkCanStart() error {
+	if gi.Status != Lobby {
+		return rejectCommand(RejectNotInLobby, "the game has already started")
+	}
+	ready := 0
+	for id := range gi.Game.Players {
+		if gi.Ready[id] {
+			ready++
+		}
+	}
+	if ready < game.MinPlayers {
+		return rejectCommand(RejectNotReady, "at least %d ready players are needed to start, %d are ready",
+			game.MinPlayers, ready)
+	}
+	return nil
+}
+
+// Starts the countdown to dealing cards, or deals them straight away if the instance has no
+// countdown. Must be called on the instance goroutine.
+func (gi *GameInstance) requestStart() error {
+	if err := gi.checkCanStart(); err != nil {
+		return err
+	}
+	if !gi.startsAt.IsZero() {
+		return rejectCommand(RejectInvalidMove, "the game is already starting")
+	}
+	if gi.Countdown <= 0 {
+		gi.start()
+		return nil
+	}
+
+	gi.startsAt = time.Now().Add(gi.Countdown)
+	gi.countdownId++
+	go gi.runCountdown(gi.countdownId)
+	gi.Logger.Info("counting down to start", "countdown", gi.Countdown)
+	return nil
+}
+
+// Sends the time remaining every second until the countdown ends, then starts the game.
+// Stops early if the countdown is cancelled or the instance is stopped.
+func (gi *GameInstance) runCountdown(id int) {
+	for {
+		var next time.Duration
+		gi.Do(func() {
+			if gi.countdownId != id || gi.startsAt.IsZero() {
+				return
+			}
+			remaining := time.Until(gi.startsAt)
+			if remaining > 0 {
+				seconds := int(math.Ceil(remaining.Seconds()))
+				gi.SendAll(ServerMessage{Type: CountdownMessage, Payload: CountdownPayload{Remaining: seconds}})
+				// Wake on the next whole second remaining.
+				next = remaining - time.Duration(seconds-1)*time.Second
+				return
+			}
+
+			gi.startsAt = time.Time{}
+			if err := gi.checkCanStart(); err != nil {
+				gi.Logger.Warn("countdown ended but game can't start", "error", err)
+			} else {
+				gi.start()
+			}
+			gi.broadcast()
+		})
+		if next == 0 {
+			return
+		}
+		select {
+		case <-time.After(next):
+		case <-gi.done:
+			return
+		}
+	}
+}
+
+// Cancels the countdown if the game can no longer start. Must be called on the instance
+// goroutine.
+func (gi *GameInstance) checkCountdown() {
+	if gi.startsAt.IsZero() || gi.checkCanStart() == nil {
+		return
+	}
+	gi.startsAt = time.Time{}
+	gi.SendAll(ServerMessage{Type: CountdownMessage, Payload: CountdownPayload{Cancelled: true}})
+	gi.Logger.Info("countdown cancelled")
+}
+
+// Removes a player from the game, leaving their connection to watch it as a spectator.
+// Must be called on the instance goroutine.
+func (gi *GameInstance) benchPlayer(id string) {
+	gi.removePlayer(id)
+	if client, ok := gi.Clients[id]; ok {
+		delete(gi.Clients, id)
+		client.Spectator = true
+		gi.Spectators[id] = client
+	}
+	gi.Logger.Info("player wasn't ready, moved to spectators", "player", id)
+}
+
+// Releases the reserved seats of players who haven't joined and readied by a deadline, then
+// starts the game with the players left if there are enough of them, without waiting for the
+// owner. Games which have already started are left alone. Returns the IDs of the players who
+// kept their seats. Must be called on the instance goroutine.
+func (gi *GameInstance) releaseNoShows() []string {
+	if gi.Status != Lobby {
+		return slices.Clone(gi.Game.Order)
+	}
+	present := []string{}
+	for playerId := range gi.Seats {
+		if _, ok := gi.Clients[playerId]; ok && gi.Ready[playerId] {
+			present = append(present, playerId)
+			continue
+		}
+		delete(gi.Seats, playerId)
+		if _, ok := gi.Game.Players[playerId]; ok {
+			gi.benchPlayer(playerId)
+		}
+	}
+	gi.Logger.Info("released no-show seats", "present", len(present))
+	if len(present) >= game.MinPlayers {
+		// Any countdown stops once it sees it has been cleared.
+		gi.startsAt = time.Time{}
+		gi.start()
+	}
+	gi.broadcast()
+	return present
+}
+
+// Moves players who aren't ready to spectators, then deals cards and starts the game.
+func (gi *GameInstance) start() {
+	for _, id := range slices.Clone(gi.Game.Order) {
+		if !gi.Ready[id] {
+			gi.benchPlayer(id)
+		}
+	}
+	gi.Game.Deal()
+
+	// TODO remove, only for debug purposes.
+	for _, p := range gi.Game.Players {
+		p.Credits += 5
+	}
+
+	gi.SetStatus(InProgress)
+	gi.Logger.Info("game started", "players", len(gi.Game.Players))
+}
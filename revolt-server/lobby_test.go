@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestLobbyManagement(t *testing.T) {
+	t.Run("should kick players, letting them rejoin", func(t *testing.T) {
+		i, clients := newTestGame(3)
+
+		err := sendMessage(i, clients[0], KickPlayerMessage, PlayerPayload{PlayerId: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := i.Game.Players["1"]; ok {
+			t.Error("expected kicked player to be removed")
+		}
+		if _, ok := i.Clients["1"]; ok || !clients[1].Closed() {
+			t.Error("expected kicked player to be disconnected")
+		}
+		if i.isBanned(clients[1]) {
+			t.Error("expected kicked player to be able to rejoin")
+		}
+	})
+
+	t.Run("should ban players' accounts", func(t *testing.T) {
+		i, clients := newTestGame(3)
+		i.Accounts["1"] = PlayerAccount{Id: "account"}
+
+		err := sendMessage(i, clients[0], BanPlayerMessage, PlayerPayload{PlayerId: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := i.Game.Players["1"]; ok {
+			t.Error("expected banned player to be removed")
+		}
+		rejoining := NewClient(nil, "New Session", slog.Default())
+		rejoining.Account = &PlayerAccount{Id: "account"}
+		if !i.isBanned(rejoining) {
+			t.Error("expected banned account to be refused")
+		}
+	})
+
+	t.Run("should refuse to ban anonymous players", func(t *testing.T) {
+		i, clients := newTestGame(3)
+
+		err := sendMessage(i, clients[0], BanPlayerMessage, PlayerPayload{PlayerId: "1"})
+		if !rejectedWith(err, RejectAnonymous) {
+			t.Errorf("expected ban to be rejected as anonymous, got %v", err)
+		}
+		if _, ok := i.Game.Players["1"]; !ok {
+			t.Error("expected anonymous player to stay in the game")
+		}
+	})
+
+	t.Run("should only accept lobby commands from the owner", func(t *testing.T) {
+		i, clients := newTestGame(3)
+
+		err := sendMessage(i, clients[1], KickPlayerMessage, PlayerPayload{PlayerId: "2"})
+		if !rejectedWith(err, RejectNotOwner) {
+			t.Errorf("expected command to be rejected as not owner, got %v", err)
+		}
+		err = sendMessage(i, clients[1], TransferOwnerMessage, PlayerPayload{PlayerId: "1"})
+		if !rejectedWith(err, RejectNotOwner) {
+			t.Errorf("expected command to be rejected as not owner, got %v", err)
+		}
+	})
+
+	t.Run("should only accept lobby commands in the lobby", func(t *testing.T) {
+		i, clients := newTestGame(3)
+		i.Status = InProgress
+
+		err := sendMessage(i, clients[0], KickPlayerMessage, PlayerPayload{PlayerId: "1"})
+		if !rejectedWith(err, RejectNotInLobby) {
+			t.Errorf("expected command to be rejected as not in lobby, got %v", err)
+		}
+	})
+
+	t.Run("should reorder seats", func(t *testing.T) {
+		i, clients := newTestGame(3)
+
+		err := sendMessage(i, clients[0], ReorderSeatsMessage, ReorderSeatsPayload{Order: []string{"2", "0", "1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(i.Game.Order) != "[2 0 1]" {
+			t.Errorf("expected order [2 0 1], got %v", i.Game.Order)
+		}
+
+		err = sendMessage(i, clients[0], ReorderSeatsMessage, ReorderSeatsPayload{Order: []string{"2", "0"}})
+		if !rejectedWith(err, RejectInvalidPayload) {
+			t.Errorf("expected partial order to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("should transfer ownership", func(t *testing.T) {
+		i, clients := newTestGame(3)
+
+		err := sendMessage(i, clients[0], TransferOwnerMessage, PlayerPayload{PlayerId: "2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i.OwnerId != "2" {
+			t.Errorf("expected 2 to own the game, got %s", i.OwnerId)
+		}
+
+		err = sendMessage(i, clients[2], TransferOwnerMessage, PlayerPayload{PlayerId: "9"})
+		if !rejectedWith(err, RejectNotInGame) {
+			t.Errorf("expected transfer to a missing player to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("should pass ownership to the next seated player when the owner leaves", func(t *testing.T) {
+		i, _ := newTestGame(3)
+		i.Game.SetOrder([]string{"1", "0", "2"})
+
+		i.removePlayer("0")
+		if i.OwnerId != "2" {
+			t.Errorf("expected 2 to own the game, got %s", i.OwnerId)
+		}
+
+		// The last seat passes ownership back around to the first.
+		i.removePlayer("2")
+		if i.OwnerId != "1" {
+			t.Errorf("expected 1 to own the game, got %s", i.OwnerId)
+		}
+
+		i.removePlayer("1")
+		if i.OwnerId != "" {
+			t.Errorf("expected an empty game to have no owner, got %s", i.OwnerId)
+		}
+	})
+
+	t.Run("should refuse banned players when they reconnect", func(t *testing.T) {
+		i := NewGameInstance("")
+		i.Banned["account"] = true
+		go i.Run()
+		defer i.Stop()
+
+		client := NewClient(nil, "Banned", slog.Default())
+		client.Id = "banned"
+		client.Account = &PlayerAccount{Id: "account"}
+		i.Join(client)
+		i.Do(func() {
+			if _, ok := i.Game.Players["banned"]; ok {
+				t.Error("expected banned player to be refused")
+			}
+		})
+		if !client.Closed() {
+			t.Error("expected banned player to be disconnected")
+		}
+	})
+}
+
+func TestReadyChecks(t *testing.T) {
+	// Creates a lobby of two players owned by the first, with no countdown.
+	setup := func() (*GameInstance, []*Client) {
+		i, clients := newTestGame(2)
+		i.Countdown = 0
+		return i, clients
+	}
+
+	// Reads queued messages until a countdown message arrives.
+	readCountdown := func(t *testing.T, client *Client) CountdownPayload {
+		t.Helper()
+		for {
+			select {
+			case bytes := <-client.send:
+				var message struct {
+					Type    ServerMessageType `json:"type"`
+					Payload CountdownPayload  `json:"payload"`
+				}
+				json.Unmarshal(bytes, &message)
+				if message.Type == CountdownMessage {
+					return message.Payload
+				}
+			case <-time.After(time.Second):
+				t.Fatal("expected a countdown message")
+			}
+		}
+	}
+
+	t.Run("should reject starting with fewer than two players", func(t *testing.T) {
+		i, clients := setup()
+		i.removePlayer("1")
+		sendMessage(i, clients[0], ReadyMessage, nil)
+
+		err := sendMessage(i, clients[0], StartGameMessage, nil)
+		if !rejectedWith(err, RejectNotReady) {
+			t.Errorf("expected start to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("should reject starting until two players are ready", func(t *testing.T) {
+		i, clients := setup()
+		sendMessage(i, clients[0], ReadyMessage, nil)
+
+		err := sendMessage(i, clients[0], StartGameMessage, nil)
+		if !rejectedWith(err, RejectNotReady) {
+			t.Errorf("expected start to be rejected, got %v", err)
+		}
+
+		sendMessage(i, clients[1], ReadyMessage, nil)
+		if err := sendMessage(i, clients[0], StartGameMessage, nil); err != nil {
+			t.Fatal(err)
+		}
+		if i.Status != InProgress {
+			t.Errorf("expected game to start, got status %s", i.Status)
+		}
+	})
+
+	t.Run("should move players who aren't ready to spectators", func(t *testing.T) {
+		i, clients := newTestGame(3)
+		i.Countdown = 0
+		sendMessage(i, clients[0], ReadyMessage, nil)
+		sendMessage(i, clients[1], ReadyMessage, nil)
+
+		if err := sendMessage(i, clients[0], StartGameMessage, nil); err != nil {
+			t.Fatal(err)
+		}
+		if i.Status != InProgress {
+			t.Fatalf("expected game to start, got status %s", i.Status)
+		}
+		if _, ok := i.Game.Players["2"]; ok {
+			t.Error("expected the unready player not to be dealt in")
+		}
+		if i.Spectators["2"] != clients[2] || !clients[2].Spectator {
+			t.Error("expected the unready player to watch the game")
+		}
+		if len(i.Game.Order) != 2 {
+			t.Errorf("expected two players to be seated, got %v", i.Game.Order)
+		}
+	})
+
+	t.Run("should reject starting a game twice", func(t *testing.T) {
+		i, clients := setup()
+		sendMessage(i, clients[0], ReadyMessage, nil)
+		sendMessage(i, clients[1], ReadyMessage, nil)
+		if err := sendMessage(i, clients[0], StartGameMessage, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		err := sendMessage(i, clients[0], StartGameMessage, nil)
+		if !rejectedWith(err, RejectNotInLobby) {
+			t.Errorf("expected second start to be rejected, got %v", err)
+		}
+		if cards := len(i.Game.Players["0"].Cards); cards != 2 {
+			t.Errorf("expected cards to be dealt once, got %d cards", cards)
+		}
+		if err := sendMessage(i, clients[1], UnreadyMessage, nil); !rejectedWith(err, RejectNotInLobby) {
+			t.Errorf("expected readiness to be fixed once started, got %v", err)
+		}
+	})
+
+	t.Run("should count down before dealing", func(t *testing.T) {
+		i, clients := setup()
+		i.Countdown = 50 * time.Millisecond
+		go i.Run()
+		defer i.Stop()
+
+		i.Do(func() {
+			sendMessage(i, clients[0], ReadyMessage, nil)
+			sendMessage(i, clients[1], ReadyMessage, nil)
+			if err := sendMessage(i, clients[0], StartGameMessage, nil); err != nil {
+				t.Error(err)
+			}
+			if i.Status != Lobby || i.ToClientStateBroadcast(clients[0]).StartsAt == nil {
+				t.Error("expected game to count down before starting")
+			}
+			if err := sendMessage(i, clients[0], StartGameMessage, nil); !rejectedWith(err, RejectInvalidMove) {
+				t.Errorf("expected start during the countdown to be rejected, got %v", err)
+			}
+		})
+
+		if countdown := readCountdown(t, clients[1]); countdown.Remaining != 1 {
+			t.Errorf("expected 1 second remaining, got %+v", countdown)
+		}
+		for range 100 {
+			var status GameStatus
+			i.Do(func() { status = i.Status })
+			if status == InProgress {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Error("expected game to start after the countdown")
+	})
+
+	t.Run("should cancel the countdown when a player stops being ready", func(t *testing.T) {
+		i, clients := setup()
+		i.Countdown = time.Minute
+		go i.Run()
+		defer i.Stop()
+
+		i.Do(func() {
+			sendMessage(i, clients[0], ReadyMessage, nil)
+			sendMessage(i, clients[1], ReadyMessage, nil)
+			sendMessage(i, clients[0], StartGameMessage, nil)
+		})
+		readCountdown(t, clients[1])
+
+		i.Do(func() { sendMessage(i, clients[1], UnreadyMessage, nil) })
+		if countdown := readCountdown(t, clients[1]); !countdown.Cancelled {
+			t.Errorf("expected countdown to be cancelled, got %+v", countdown)
+		}
+		i.Do(func() {
+			if i.Status != Lobby || !i.startsAt.IsZero() {
+				t.Error("expected game to stay in the lobby")
+			}
+		})
+	})
+}
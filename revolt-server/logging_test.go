@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	t.Run("should write structured fields as JSON", func(t *testing.T) {
+		var b bytes.Buffer
+		logger, err := NewLogger(&b, "info", "json")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		logger.With("game", "abc").Info("game started", "players", 2)
+
+		var entry map[string]any
+		err = json.Unmarshal(b.Bytes(), &entry)
+		if err != nil {
+			t.Fatalf("expected a JSON log entry, got %s", b.String())
+		}
+		if entry["game"] != "abc" || entry["msg"] != "game started" {
+			t.Errorf("expected game and message fields, got %v", entry)
+		}
+	})
+
+	t.Run("should filter messages below the configured level", func(t *testing.T) {
+		var b bytes.Buffer
+		logger, err := NewLogger(&b, "WARN", "text")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		logger.Info("ignored")
+		logger.Warn("kept")
+
+		if strings.Contains(b.String(), "ignored") || !strings.Contains(b.String(), "kept") {
+			t.Errorf("expected only warnings to be logged, got %s", b.String())
+		}
+	})
+
+	t.Run("should reject unknown levels and formats", func(t *testing.T) {
+		if _, err := NewLogger(&bytes.Buffer{}, "loud", "text"); err == nil {
+			t.Error("expected error for invalid level")
+		}
+		if _, err := NewLogger(&bytes.Buffer{}, "info", "xml"); err == nil {
+			t.Error("expected error for invalid format")
+		}
+	})
+}
@@ -2,11 +2,20 @@ package main
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 )
 
 func run() error {
-	err := RunServer()
+	config := LoadConfigFromEnv()
+
+	logger, err := NewLogger(os.Stderr, config.LogLevel, config.LogFormat)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+
+	err = RunServer(config)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"revolt/game"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// How often the matcher retries forming tables from waiting players.
+	MatchInterval = time.Second
+	// The widest rating difference allowed between players at a rated table when they join the queue.
+	RatingBand = 200
+	// How much the allowed rating difference grows for every `RatingBandInterval` a player waits.
+	RatingBandGrowth   = 50
+	RatingBandInterval = 10 * time.Second
+	// How long matched players have to join their game and ready up before losing their seat.
+	MatchNoShowTimeout = time.Minute
+
+	DefaultTableSize   = 4
+	MinTableSize       = 2
+	DefaultRulesPreset = "standard"
+)
+
+// Returned when an account joins the queue while already queued, such as from another tab.
+var ErrAlreadyQueued = errors.New("already queued")
+
+// Rules presets players can queue for. Only the standard rules exist so far.
+var RulesPresets = []string{DefaultRulesPreset}
+
+// What a player is queueing for. Only players with identical preferences are matched.
+type QueuePreferences struct {
+	TableSize int    `json:"tableSize"`
+	Preset    string `json:"preset"`
+	Rated     bool   `json:"rated"`
+}
+
+// Reads queue preferences from `?size=`, `?preset=` and `?rated=`.
+func parsePreferences(r *http.Request) (QueuePreferences, error) {
+	query := r.URL.Query()
+	preferences := QueuePreferences{TableSize: DefaultTableSize, Preset: DefaultRulesPreset}
+
+	if value := query.Get("size"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < MinTableSize || size > game.MaxPlayers {
+			return preferences, errors.New("table size must be between 2 and 6")
+		}
+		preferences.TableSize = size
+	}
+	if value := query.Get("preset"); value != "" {
+		if !slices.Contains(RulesPresets, value) {
+			return preferences, errors.New("unknown rules preset")
+		}
+		preferences.Preset = value
+	}
+	if value := query.Get("rated"); value != "" {
+		rated, err := strconv.ParseBool(value)
+		if err != nil {
+			return preferences, errors.New("rated must be true or false")
+		}
+		preferences.Rated = rated
+	}
+	return preferences, nil
+}
+
+// A player waiting in the matchmaking queue.
+type QueueEntry struct {
+	Name        string
+	Account     *PlayerAccount
+	Rating      float64
+	Preferences QueuePreferences
+	Joined      time.Time
+
+	// Receives the player's match. Buffered, so the matcher never blocks on a player.
+	match chan MatchFoundPayload
+	// Receives whether the player was queued.
+	queued chan error
+}
+
+// The rating difference the entry accepts after waiting until `now`.
+func (e *QueueEntry) band(now time.Time) float64 {
+	waited := now.Sub(e.Joined) / RatingBandInterval
+	return RatingBand + RatingBandGrowth*float64(waited)
+}
+
+// Groups queued players into tables, creating an instance for each table.
+// The queue is only accessed on the matcher goroutine.
+type Matchmaker struct {
+	NoShowTimeout time.Duration
+
+	join  chan *QueueEntry
+	leave chan *QueueEntry
+	queue []*QueueEntry
+	now   func() time.Time
+}
+
+// Global matchmaker, started by `RunServer`.
+var matchmaker = NewMatchmaker()
+
+func NewMatchmaker() *Matchmaker {
+	return &Matchmaker{
+		NoShowTimeout: MatchNoShowTimeout,
+		join:          make(chan *QueueEntry),
+		leave:         make(chan *QueueEntry),
+		now:           time.Now,
+	}
+}
+
+// Adds a player to the queue, noting when they joined. Accounts can only be queued once.
+func (m *Matchmaker) Join(entry *QueueEntry) error {
+	entry.queued = make(chan error, 1)
+	m.join <- entry
+	return <-entry.queued
+}
+
+// Whether an account is waiting in the queue.
+func (m *Matchmaker) isQueued(account *PlayerAccount) bool {
+	return account != nil && slices.ContainsFunc(m.queue, func(e *QueueEntry) bool {
+		return e.Account != nil && e.Account.Id == account.Id
+	})
+}
+
+// Removes a player from the queue, if they haven't already been matched.
+func (m *Matchmaker) Leave(entry *QueueEntry) {
+	m.leave <- entry
+}
+
+// Matches players as they join, and periodically so rating bands can widen.
+func (m *Matchmaker) Run() {
+	ticker := time.NewTicker(MatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry := <-m.join:
+			if m.isQueued(entry.Account) {
+				entry.queued <- ErrAlreadyQueued
+				continue
+			}
+			entry.Joined = m.now()
+			m.queue = append(m.queue, entry)
+			entry.queued <- nil
+			metrics.QueuedPlayers.Inc("")
+			m.match()
+		case entry := <-m.leave:
+			if i := slices.Index(m.queue, entry); i != -1 {
+				m.queue = slices.Delete(m.queue, i, i+1)
+				metrics.QueuedPlayers.Dec("")
+			}
+		case <-ticker.C:
+			m.match()
+		}
+	}
+}
+
+// Forms as many tables as possible from the queue.
+func (m *Matchmaker) match() {
+	groups := map[QueuePreferences][]*QueueEntry{}
+	for _, entry := range m.queue {
+		groups[entry.Preferences] = append(groups[entry.Preferences], entry)
+	}
+
+	for preferences, entries := range groups {
+		for {
+			table := m.findTable(preferences, entries)
+			if table == nil {
+				break
+			}
+			m.seat(preferences, table)
+			entries = slices.DeleteFunc(entries, func(e *QueueEntry) bool {
+				return slices.Contains(table, e)
+			})
+			m.queue = slices.DeleteFunc(m.queue, func(e *QueueEntry) bool {
+				return slices.Contains(table, e)
+			})
+			metrics.QueuedPlayers.Add("", -float64(len(table)))
+		}
+	}
+}
+
+// Picks a full table from players with the same preferences, or nil if there isn't one.
+// Unrated tables are filled in queue order. Rated tables are drawn from players close in
+// rating, where every player's rating band covers the whole table.
+func (m *Matchmaker) findTable(preferences QueuePreferences, entries []*QueueEntry) []*QueueEntry {
+	size := preferences.TableSize
+	if len(entries) < size {
+		return nil
+	}
+	if !preferences.Rated {
+		return slices.Clone(entries[:size])
+	}
+
+	sorted := slices.Clone(entries)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Rating < sorted[j].Rating })
+	now := m.now()
+	for start := 0; start+size <= len(sorted); start++ {
+		table := sorted[start : start+size]
+		spread := table[size-1].Rating - table[0].Rating
+		fits := true
+		for _, entry := range table {
+			if spread > entry.band(now) {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			return slices.Clone(table)
+		}
+	}
+	return nil
+}
+
+// Creates an instance for a table, reserving a seat for each player, and tells each player
+// the game to join and the token to join it with.
+func (m *Matchmaker) seat(preferences QueuePreferences, table []*QueueEntry) {
+	instance := NewGameInstance("")
+	instance.Preset = preferences.Preset
+	instance.Rated = preferences.Rated
+	instance.Seats = map[string]Seat{}
+
+	players := make([]string, len(table))
+	seated := map[string]*QueueEntry{}
+	for i, entry := range table {
+		players[i] = game.Id()
+		instance.Seats[players[i]] = Seat{Name: entry.Name, Account: entry.Account}
+		seated[players[i]] = entry
+	}
+	im.StartInstance(instance)
+	instance.Logger.Info("created game from matchmaking", "players", len(table), "preset", preferences.Preset, "rated", preferences.Rated)
+	time.AfterFunc(m.NoShowTimeout, func() { m.checkNoShows(instance, seated) })
+
+	for i, entry := range table {
+		entry.match <- MatchFoundPayload{
+			GameId:   instance.GameId,
+			PlayerId: players[i],
+			Token:    sessions.Issue(instance.GameId, players[i]),
+		}
+	}
+}
+
+// Releases the seats of matched players who haven't joined their game and readied in time. The
+// game starts with the players left if there are enough of them. Otherwise they're put back in
+// the queue, and sent their next match over their game connection, and the game is removed once
+// they've moved on.
+func (m *Matchmaker) checkNoShows(instance *GameInstance, seated map[string]*QueueEntry) {
+	started := false
+	requeue := map[*Client]*QueueEntry{}
+	instance.Do(func() {
+		present := instance.releaseNoShows()
+		if instance.Status != Lobby {
+			started = true
+			return
+		}
+		for _, playerId := range present {
+			requeue[instance.Clients[playerId]] = seated[playerId]
+		}
+	})
+	if started {
+		return
+	}
+
+	var waiting sync.WaitGroup
+	for client, entry := range requeue {
+		requeued := &QueueEntry{
+			Name:        entry.Name,
+			Account:     entry.Account,
+			Rating:      entry.Rating,
+			Preferences: entry.Preferences,
+			match:       make(chan MatchFoundPayload, 1),
+		}
+		if err := m.Join(requeued); err != nil {
+			client.Logger.Warn("couldn't requeue player", "error", err)
+			continue
+		}
+		client.Send(ServerMessage{Type: QueuedMessage, Payload: QueuedPayload{Preferences: requeued.Preferences}})
+
+		waiting.Add(1)
+		go func() {
+			defer waiting.Done()
+			select {
+			case match := <-requeued.match:
+				client.Send(ServerMessage{Type: MatchFoundMessage, Payload: match})
+				// Give the player time to receive their match before the game closes their
+				// connection.
+				select {
+				case <-client.done:
+				case <-time.After(m.NoShowTimeout):
+				}
+			case <-client.done:
+				m.Leave(requeued)
+			}
+		}()
+	}
+	instance.Logger.Info("too few matched players joined, requeued them", "players", len(requeue))
+	go func() {
+		waiting.Wait()
+		im.DeleteInstance(instance.GameId)
+	}()
+}
+
+// Queues a player for a game at `/matchmake`. Preferences are given as query parameters, and
+// rated games require joining as an account with `?account=`. The player is sent a `queued`
+// message, then a `match_found` message once a table is formed, after which the connection is
+// closed. Closing the connection leaves the queue. An account can only be queued once.
+func matchmakeHandler(w http.ResponseWriter, r *http.Request) {
+	preferences, err := parsePreferences(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry := &QueueEntry{
+		Name:        r.URL.Query().Get(NameKey),
+		Preferences: preferences,
+		match:       make(chan MatchFoundPayload, 1),
+	}
+	if token := r.URL.Query().Get(AccountKey); token != "" {
+		// Account routes don't exist when accounts are disabled, so neither does queueing as one.
+		if accounts == nil {
+			http.Error(w, "accounts are disabled", http.StatusNotFound)
+			return
+		}
+		account, err := authenticateAccount(token)
+		if err != nil {
+			http.Error(w, "unauthorised", http.StatusUnauthorized)
+			return
+		}
+		stats, err := accounts.Stats(account.Id)
+		if err != nil {
+			slog.Error("failed to read stats", "error", err)
+			http.Error(w, "failed to read rating", http.StatusInternalServerError)
+			return
+		}
+		entry.Name = account.Profile.DisplayName
+		entry.Account = &PlayerAccount{Id: account.Id, Avatar: account.Profile.Avatar}
+		entry.Rating = stats.Rating
+	}
+	if preferences.Rated && entry.Account == nil {
+		http.Error(w, "rated games require an account", http.StatusUnauthorized)
+		return
+	}
+
+	if err := matchmaker.Join(entry); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	conn, err := upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("websocket upgrade failed", "error", err)
+		matchmaker.Leave(entry)
+		return
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(ServerMessage{Type: QueuedMessage, Payload: QueuedPayload{Preferences: preferences}})
+
+	// The player sends nothing while queued, so a read only returns when they leave.
+	left := make(chan struct{})
+	go func() {
+		defer close(left)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case match := <-entry.match:
+		conn.WriteJSON(ServerMessage{Type: MatchFoundMessage, Payload: match})
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, "matched"))
+	case <-left:
+		matchmaker.Leave(entry)
+	}
+}
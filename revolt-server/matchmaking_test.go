@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFindTable(t *testing.T) {
+	now := time.Now()
+	entry := func(rating float64, waited time.Duration) *QueueEntry {
+		return &QueueEntry{Rating: rating, Joined: now.Add(-waited)}
+	}
+	setup := func() *Matchmaker {
+		m := NewMatchmaker()
+		m.now = func() time.Time { return now }
+		return m
+	}
+
+	t.Run("should fill unrated tables in queue order", func(t *testing.T) {
+		m := setup()
+		entries := []*QueueEntry{entry(1000, 0), entry(2000, 0), entry(1500, 0)}
+
+		table := m.findTable(QueuePreferences{TableSize: 2}, entries)
+		if len(table) != 2 || table[0] != entries[0] || table[1] != entries[1] {
+			t.Errorf("expected the first two players, got %v", table)
+		}
+	})
+
+	t.Run("should wait for a full table", func(t *testing.T) {
+		m := setup()
+
+		if table := m.findTable(QueuePreferences{TableSize: 3}, []*QueueEntry{entry(1500, 0)}); table != nil {
+			t.Errorf("expected no table, got %v", table)
+		}
+	})
+
+	t.Run("should group rated players within their rating band", func(t *testing.T) {
+		m := setup()
+		entries := []*QueueEntry{entry(1000, 0), entry(1600, 0), entry(1500, 0)}
+
+		table := m.findTable(QueuePreferences{TableSize: 2, Rated: true}, entries)
+		if len(table) != 2 || table[0] != entries[2] || table[1] != entries[1] {
+			t.Errorf("expected the two closest ratings, got %v", table)
+		}
+
+		if table := m.findTable(QueuePreferences{TableSize: 2, Rated: true}, entries[:2]); table != nil {
+			t.Errorf("expected players 600 apart not to be matched, got %v", table)
+		}
+	})
+
+	t.Run("should widen rating bands the longer players wait", func(t *testing.T) {
+		m := setup()
+		entries := []*QueueEntry{entry(1500, time.Minute), entry(1800, time.Minute)}
+
+		if table := m.findTable(QueuePreferences{TableSize: 2, Rated: true}, entries); table == nil {
+			t.Error("expected players who have waited to be matched")
+		}
+	})
+}
+
+func TestMatchmakerQueue(t *testing.T) {
+	now := time.Now().Add(-time.Hour)
+	setup := func() *Matchmaker {
+		m := NewMatchmaker()
+		m.now = func() time.Time { return now }
+		go m.Run()
+		return m
+	}
+	// Builds an entry for a rated table of four, which a lone player never fills.
+	entry := func(account *PlayerAccount) *QueueEntry {
+		return &QueueEntry{
+			Account:     account,
+			Preferences: QueuePreferences{TableSize: 4, Rated: true},
+			match:       make(chan MatchFoundPayload, 1),
+		}
+	}
+
+	t.Run("should note when players join by the matchmaker's clock", func(t *testing.T) {
+		m := setup()
+		queued := entry(&PlayerAccount{Id: "account"})
+
+		if err := m.Join(queued); err != nil {
+			t.Fatal(err)
+		}
+		if !queued.Joined.Equal(now) {
+			t.Errorf("expected to have joined at %v, got %v", now, queued.Joined)
+		}
+	})
+
+	t.Run("should refuse to queue an account twice", func(t *testing.T) {
+		m := setup()
+		m.Join(entry(&PlayerAccount{Id: "account"}))
+
+		if err := m.Join(entry(&PlayerAccount{Id: "account"})); err != ErrAlreadyQueued {
+			t.Errorf("expected the second entry to be refused, got %v", err)
+		}
+		if err := m.Join(entry(nil)); err != nil {
+			t.Errorf("expected anonymous players to queue, got %v", err)
+		}
+	})
+}
+
+func TestParsePreferences(t *testing.T) {
+	t.Run("should default to an unrated standard table of four", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/matchmake", nil)
+
+		preferences, err := parsePreferences(r)
+		expected := QueuePreferences{TableSize: 4, Preset: "standard"}
+		if err != nil || preferences != expected {
+			t.Errorf("expected %+v, got %+v (%v)", expected, preferences, err)
+		}
+	})
+
+	t.Run("should reject invalid preferences", func(t *testing.T) {
+		for _, query := range []string{"size=1", "size=7", "preset=unknown", "rated=maybe"} {
+			r := httptest.NewRequest("GET", "/matchmake?"+query, nil)
+			if _, err := parsePreferences(r); err == nil {
+				t.Errorf("expected %s to be rejected", query)
+			}
+		}
+	})
+}
+
+func TestMatchmake(t *testing.T) {
+	t.Run("should seat matched players in a new game", func(t *testing.T) {
+		initInstanceManager()
+		matchmaker = NewMatchmaker()
+		go matchmaker.Run()
+
+		mux := http.NewServeMux()
+		mux.Handle("/matchmake", http.HandlerFunc(matchmakeHandler))
+		mux.Handle("/{id}", http.HandlerFunc(websocketHandler))
+		server := httptest.NewServer(mux)
+		defer server.Close()
+		base := "ws" + strings.TrimPrefix(server.URL, "http")
+
+		queue := func(name string) <-chan MatchFoundPayload {
+			conn, _, err := websocket.DefaultDialer.Dial(base+"/matchmake?size=2&name="+name, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var queued struct {
+				Type ServerMessageType `json:"type"`
+			}
+			conn.ReadJSON(&queued)
+			if queued.Type != QueuedMessage {
+				t.Errorf("expected to be queued, got %s", queued.Type)
+			}
+
+			matched := make(chan MatchFoundPayload, 1)
+			go func() {
+				defer conn.Close()
+				var message struct {
+					Type    ServerMessageType `json:"type"`
+					Payload MatchFoundPayload `json:"payload"`
+				}
+				conn.ReadJSON(&message)
+				matched <- message.Payload
+			}()
+			return matched
+		}
+
+		first := queue("One")
+		second := queue("Two")
+		one, two := <-first, <-second
+		if one.GameId == "" || one.GameId != two.GameId || one.PlayerId == two.PlayerId {
+			t.Fatalf("expected both players in the same game, got %+v and %+v", one, two)
+		}
+
+		instance, ok := im.GetInstance(one.GameId)
+		if !ok {
+			t.Fatal("expected matched game to exist")
+		}
+		defer instance.Stop()
+
+		conn, _, err := websocket.DefaultDialer.Dial(base+"/"+one.GameId+"?token="+one.Token, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for {
+			var state ClientStateBroadcast
+			if err := conn.ReadJSON(&state); err != nil {
+				t.Fatal(err)
+			}
+			if state.Type == StateMessage {
+				if state.Self.Id != one.PlayerId || state.Self.Name != "One" {
+					t.Errorf("expected to take the reserved seat, got %+v", state.Self)
+				}
+				break
+			}
+		}
+
+		// Players without a reserved seat are turned away.
+		stranger, _, err := websocket.DefaultDialer.Dial(base+"/"+one.GameId+"?name=Stranger", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stranger.Close()
+		for {
+			var message struct {
+				Type ServerMessageType `json:"type"`
+			}
+			if err := stranger.ReadJSON(&message); err != nil {
+				break
+			}
+			if message.Type == StateMessage {
+				t.Fatal("expected a player without a seat to be disconnected")
+			}
+		}
+	})
+
+	// Starts a matched game of three with the given players joined, readying the first `ready`.
+	seatTable := func(t *testing.T, m *Matchmaker, joined int, ready int) (*GameInstance, []*Client) {
+		initInstanceManager()
+		instance := NewGameInstance("")
+		instance.Seats = map[string]Seat{}
+		seated := map[string]*QueueEntry{}
+		for _, id := range []string{"0", "1", "2"} {
+			instance.Seats[id] = Seat{Name: "Player " + id}
+			seated[id] = &QueueEntry{Name: "Player " + id, Preferences: QueuePreferences{TableSize: 2}}
+		}
+		im.StartInstance(instance)
+		t.Cleanup(func() { im.DeleteInstance(instance.GameId) })
+
+		clients := []*Client{}
+		instance.Do(func() {
+			for n := range joined {
+				id := fmt.Sprint(n)
+				client := NewClient(nil, "Player "+id, slog.Default())
+				client.Id = id
+				instance.Game.AddPlayer(id, client.Name)
+				instance.Clients[id] = client
+				instance.Ready[id] = n < ready
+				clients = append(clients, client)
+			}
+		})
+		m.checkNoShows(instance, seated)
+		return instance, clients
+	}
+
+	t.Run("should start matched games with the players who showed up", func(t *testing.T) {
+		m := NewMatchmaker()
+		instance, clients := seatTable(t, m, 3, 2)
+
+		instance.Do(func() {
+			if instance.Status != InProgress || len(instance.Game.Players) != 2 {
+				t.Errorf("expected the two ready players to start, got %s with %v", instance.Status, instance.Game.Order)
+			}
+			if _, ok := instance.Seats["2"]; ok || !clients[2].Spectator {
+				t.Error("expected the unready player to lose their seat")
+			}
+		})
+	})
+
+	t.Run("should requeue players left without enough opponents", func(t *testing.T) {
+		m := NewMatchmaker()
+		go m.Run()
+		instance, clients := seatTable(t, m, 1, 1)
+
+		if queued := received[QueuedPayload](clients[0], QueuedMessage); len(queued) != 1 {
+			t.Fatalf("expected the player to be queued again, got %v", queued)
+		}
+		m.Join(&QueueEntry{Name: "Other", Preferences: QueuePreferences{TableSize: 2}, match: make(chan MatchFoundPayload, 1)})
+		for range 100 {
+			if matches := received[MatchFoundPayload](clients[0], MatchFoundMessage); len(matches) == 1 {
+				if matches[0].GameId == instance.GameId {
+					t.Error("expected a new game")
+				}
+				// The abandoned game is removed once the player moves on.
+				clients[0].Close()
+				select {
+				case <-instance.done:
+				case <-time.After(time.Second):
+					t.Fatal("expected the abandoned game to be removed")
+				}
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("expected the player to be matched again")
+	})
+
+	t.Run("should refuse accounts when accounts are disabled", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/matchmake?size=2&account=token", nil)
+		rr := httptest.NewRecorder()
+		matchmakeHandler(rr, req)
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", rr.Code)
+		}
+	})
+}
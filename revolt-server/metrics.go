@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Reasons a client command can be rejected, used to label the rejected commands metric.
+const (
+	RejectMalformed      = "malformed"
+	RejectUnknownType    = "unknown_type"
+	RejectNotInGame      = "not_in_game"
+	RejectNotOwner       = "not_owner"
+	RejectNotInLobby     = "not_in_lobby"
+	RejectNotInProgress  = "not_in_progress"
+	RejectNotReady       = "not_ready"
+	RejectMuted          = "muted"
+	RejectRateLimited    = "rate_limited"
+	RejectInvalidPayload = "invalid_payload"
+	RejectInvalidMove    = "invalid_move"
+	RejectStale          = "stale"
+	RejectAnonymous      = "anonymous"
+)
+
+// Labels messages of a type the server doesn't know.
+const UnknownMessageLabel = "unknown"
+
+// Returns the label to count a message of type `t` under. Clients can send any type, so
+// unknown types share a label rather than each adding a series.
+func messageLabel(t MessageType) string {
+	if slices.Contains(MessageTypes, t) {
+		return string(t)
+	}
+	return UnknownMessageLabel
+}
+
+// A set of float values keyed by a single label value.
+// Used for both counters and gauges, which only differ in how they're written.
+type metricVec struct {
+	name  string
+	help  string
+	kind  string
+	label string
+
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+func newMetricVec(kind string, name string, help string, label string) *metricVec {
+	return &metricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		label:  label,
+		values: make(map[string]float64),
+	}
+}
+
+// Adds `delta` to the value with the given label value.
+func (m *metricVec) Add(labelValue string, delta float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values[labelValue] += delta
+}
+
+func (m *metricVec) Inc(labelValue string) {
+	m.Add(labelValue, 1)
+}
+
+func (m *metricVec) Dec(labelValue string) {
+	m.Add(labelValue, -1)
+}
+
+// Returns the current value for a label value.
+func (m *metricVec) Get(labelValue string) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.values[labelValue]
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	writeHeader(w, m.name, m.help, m.kind)
+	if m.label == "" {
+		fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(m.values[""]))
+		return
+	}
+
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", m.name, m.label, key, formatFloat(m.values[key]))
+	}
+}
+
+// A cumulative histogram with fixed bucket upper bounds.
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	lock   sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name string, help string, buckets []float64) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// Records a single observation.
+func (h *histogram) Observe(value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Server-wide metrics, exposed in the Prometheus text format.
+type Metrics struct {
+	Instances         *metricVec
+	ConnectedClients  *metricVec
+	MessagesReceived  *metricVec
+	CommandsRejected  *metricVec
+	GamesCompleted    *metricVec
+	QueuedPlayers     *metricVec
+	RateLimited       *metricVec
+	BroadcastDuration *histogram
+	BroadcastSize     *histogram
+}
+
+// Global metrics store.
+var metrics = NewMetrics()
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Instances: newMetricVec("gauge",
+			"revolt_instances", "Live game instances by status.", "status"),
+		ConnectedClients: newMetricVec("gauge",
+			"revolt_connected_clients", "Clients currently connected.", ""),
+		MessagesReceived: newMetricVec("counter",
+			"revolt_messages_received_total", "Messages received from clients by type.", "type"),
+		CommandsRejected: newMetricVec("counter",
+			"revolt_commands_rejected_total", "Client commands rejected by reason.", "reason"),
+		GamesCompleted: newMetricVec("counter",
+			"revolt_games_completed_total", "Games played through to a winner.", ""),
+		QueuedPlayers: newMetricVec("gauge",
+			"revolt_matchmaking_queued_players", "Players waiting in the matchmaking queue.", ""),
+		RateLimited: newMetricVec("counter",
+			"revolt_rate_limited_total", "Requests refused for exceeding a rate limit, by kind.", "kind"),
+		BroadcastDuration: newHistogram(
+			"revolt_broadcast_duration_seconds", "Time taken to queue a state broadcast for every client.",
+			[]float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1}),
+		BroadcastSize: newHistogram(
+			"revolt_broadcast_size_bytes", "Size of individual client state broadcasts.",
+			[]float64{256, 512, 1024, 2048, 4096, 8192, 16384}),
+	}
+}
+
+// Writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) {
+	m.Instances.write(w)
+	m.ConnectedClients.write(w)
+	m.MessagesReceived.write(w)
+	m.CommandsRejected.write(w)
+	m.GamesCompleted.write(w)
+	m.QueuedPlayers.write(w)
+	m.RateLimited.write(w)
+	m.BroadcastDuration.write(w)
+	m.BroadcastSize.write(w)
+
+	writeHeader(w, "revolt_messages_dropped_total", "Messages dropped because a client's queue was full.", "counter")
+	fmt.Fprintf(w, "revolt_messages_dropped_total %d\n", droppedMessages.Load())
+	writeHeader(w, "revolt_states_coalesced_total", "State broadcasts replaced by a newer state before being sent.", "counter")
+	fmt.Fprintf(w, "revolt_states_coalesced_total %d\n", coalescedStates.Load())
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	var b strings.Builder
+	metrics.Write(&b)
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("should write labelled counters and gauges", func(t *testing.T) {
+		m := NewMetrics()
+		m.MessagesReceived.Inc(string(StartGameMessage))
+		m.MessagesReceived.Inc(string(StartGameMessage))
+		m.ConnectedClients.Inc("")
+
+		var b strings.Builder
+		m.Write(&b)
+		output := b.String()
+
+		expected := []string{
+			"# TYPE revolt_messages_received_total counter",
+			`revolt_messages_received_total{type="start_game"} 2`,
+			"# TYPE revolt_connected_clients gauge",
+			"revolt_connected_clients 1",
+		}
+		for _, line := range expected {
+			if !strings.Contains(output, line+"\n") {
+				t.Errorf("expected output to contain %q, got:\n%s", line, output)
+			}
+		}
+	})
+
+	t.Run("should label unknown message types as unknown", func(t *testing.T) {
+		if label := messageLabel(EndTurnMessage); label != "end_turn" {
+			t.Errorf("expected end_turn, got %q", label)
+		}
+		if label := messageLabel("made_up"); label != UnknownMessageLabel {
+			t.Errorf("expected %q, got %q", UnknownMessageLabel, label)
+		}
+	})
+
+	t.Run("should write cumulative histogram buckets", func(t *testing.T) {
+		m := NewMetrics()
+		m.BroadcastSize.Observe(300)
+		m.BroadcastSize.Observe(5000)
+
+		var b strings.Builder
+		m.Write(&b)
+		output := b.String()
+
+		expected := []string{
+			`revolt_broadcast_size_bytes_bucket{le="256"} 0`,
+			`revolt_broadcast_size_bytes_bucket{le="512"} 1`,
+			`revolt_broadcast_size_bytes_bucket{le="8192"} 2`,
+			`revolt_broadcast_size_bytes_bucket{le="+Inf"} 2`,
+			"revolt_broadcast_size_bytes_sum 5300",
+			"revolt_broadcast_size_bytes_count 2",
+		}
+		for _, line := range expected {
+			if !strings.Contains(output, line+"\n") {
+				t.Errorf("expected output to contain %q, got:\n%s", line, output)
+			}
+		}
+	})
+
+	t.Run("should track instances by status", func(t *testing.T) {
+		initInstanceManager()
+		before := metrics.Instances.Get(string(InProgress))
+
+		i := NewGameInstance("")
+		im.RegisterInstance(i)
+		i.SetStatus(InProgress)
+
+		if count := metrics.Instances.Get(string(InProgress)) - before; count != 1 {
+			t.Errorf("expected 1 more in progress instance, got %v", count)
+		}
+	})
+}
+
+func TestMetricsHandler(t *testing.T) {
+	t.Run("should serve metrics as plain text", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/metrics", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(metricsHandler).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("expected status 200, got %v", status)
+		}
+		if !strings.Contains(rr.Body.String(), "revolt_games_completed_total") {
+			t.Errorf("expected games completed metric, got:\n%s", rr.Body.String())
+		}
+	})
+}
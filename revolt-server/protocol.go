@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// The version of the wire protocol spoken by this server.
+	// Bump this when making a change existing clients can't understand.
+	ProtocolVersion = 1
+	// The oldest protocol version this server still accepts.
+	MinProtocolVersion = 1
+
+	// Prefix of the websocket subprotocol clients use to advertise their protocol version,
+	// e.g. `revolt.v1`.
+	SubprotocolPrefix = "revolt.v"
+)
+
+// Returns the subprotocol name for a protocol version.
+func subprotocol(version int) string {
+	return fmt.Sprintf("%s%d", SubprotocolPrefix, version)
+}
+
+// The result of negotiating a protocol version with a connecting client.
+type ProtocolNegotiation struct {
+	// The version to speak, or 0 if the client didn't advertise one.
+	Version int
+	// The subprotocol to accept the connection with, if any.
+	Subprotocol string
+	// Set if the client's versions are all unsupported.
+	Err error
+}
+
+// Chooses the highest supported protocol version from the subprotocols requested by a client.
+// Clients which don't advertise a version are allowed, but should be warned that they may break.
+func negotiateProtocol(r *http.Request) ProtocolNegotiation {
+	requested := websocket.Subprotocols(r)
+	best := ProtocolNegotiation{}
+	advertised := []string{}
+
+	for _, protocol := range requested {
+		versionString, ok := strings.CutPrefix(protocol, SubprotocolPrefix)
+		if !ok {
+			continue
+		}
+		advertised = append(advertised, protocol)
+		version, err := strconv.Atoi(versionString)
+		if err != nil {
+			continue
+		}
+		if version >= MinProtocolVersion && version <= ProtocolVersion && version > best.Version {
+			best = ProtocolNegotiation{Version: version, Subprotocol: protocol}
+		}
+	}
+
+	if best.Version == 0 && len(advertised) > 0 {
+		// Accept the handshake with the client's own subprotocol, so the connection can be
+		// closed with a reason the client can read.
+		return ProtocolNegotiation{
+			Subprotocol: advertised[0],
+			Err: fmt.Errorf("unsupported protocol version (server supports %s to %s)",
+				subprotocol(MinProtocolVersion), subprotocol(ProtocolVersion)),
+		}
+	}
+	return best
+}
+
+// Builds the hello message sent to a client when it connects.
+func helloMessage(client *Client, negotiation ProtocolNegotiation) ServerMessage {
+	payload := HelloPayload{
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+		ClientId:           client.Id,
+		Token:              client.Token,
+		UpdateMode:         client.UpdateMode,
+	}
+	if negotiation.Version == 0 {
+		payload.Warning = fmt.Sprintf("no protocol version advertised, expected subprotocol %s",
+			subprotocol(ProtocolVersion))
+	}
+	return ServerMessage{Type: HelloMessage, Payload: payload}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateProtocol(t *testing.T) {
+	request := func(protocols string) ProtocolNegotiation {
+		req := httptest.NewRequest("GET", "/game", nil)
+		if protocols != "" {
+			req.Header.Set("Sec-Websocket-Protocol", protocols)
+		}
+		return negotiateProtocol(req)
+	}
+
+	t.Run("should accept a supported version", func(t *testing.T) {
+		negotiation := request(subprotocol(ProtocolVersion))
+
+		if negotiation.Err != nil {
+			t.Fatal(negotiation.Err)
+		}
+		if negotiation.Version != ProtocolVersion || negotiation.Subprotocol != subprotocol(ProtocolVersion) {
+			t.Errorf("expected version %d, got %+v", ProtocolVersion, negotiation)
+		}
+	})
+
+	t.Run("should pick the highest supported version", func(t *testing.T) {
+		negotiation := request("chat, revolt.v999, " + subprotocol(ProtocolVersion))
+
+		if negotiation.Err != nil {
+			t.Fatal(negotiation.Err)
+		}
+		if negotiation.Version != ProtocolVersion {
+			t.Errorf("expected version %d, got %d", ProtocolVersion, negotiation.Version)
+		}
+	})
+
+	t.Run("should reject unsupported versions", func(t *testing.T) {
+		negotiation := request("revolt.v999")
+
+		if negotiation.Err == nil {
+			t.Error("expected error for unsupported version")
+		}
+		if negotiation.Subprotocol != "revolt.v999" {
+			t.Errorf("expected handshake to echo the requested protocol, got %s", negotiation.Subprotocol)
+		}
+	})
+
+	t.Run("should allow clients without a version, with a warning", func(t *testing.T) {
+		negotiation := request("")
+
+		if negotiation.Err != nil || negotiation.Version != 0 {
+			t.Errorf("expected unversioned negotiation, got %+v", negotiation)
+		}
+
+		hello := helloMessage(&Client{Id: "0"}, negotiation)
+		if hello.Payload.(HelloPayload).Warning == "" {
+			t.Error("expected hello message to include a warning")
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A token bucket rate limiter, allowing bursts of up to `capacity` events and refilling at
+// `rate` tokens per second. Safe to use from any goroutine.
+type tokenBucket struct {
+	lock     sync.Mutex
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+// Creates a full bucket.
+func newTokenBucket(capacity float64, rate float64) *tokenBucket {
+	return &tokenBucket{
+		capacity: capacity,
+		rate:     rate,
+		tokens:   capacity,
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// Takes a token if one is available, reporting whether the event is allowed.
+func (b *tokenBucket) Allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.now()
+	b.tokens = min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+const (
+	// The largest websocket frame accepted from a client. Larger frames close the connection
+	// with `websocket.CloseMessageTooBig`.
+	MaxFrameSize = 16 * 1024
+
+	// Each connection can send a burst of `MessageBurst` messages, then `MessageRate` a second.
+	MessageBurst = 20
+	MessageRate  = 10
+	// All connections from a single IP address share a larger allowance.
+	IPMessageBurst = 60
+	IPMessageRate  = 30
+	// Each IP address can create a burst of `CreateBurst` games, then one every `CreateInterval`.
+	CreateBurst    = 5
+	CreateInterval = 30 * time.Second
+
+	// Close code sent to connections that exceed their message allowance.
+	CloseRateLimited = 4029
+
+	// How often buckets that have refilled are forgotten.
+	pruneInterval = time.Minute
+)
+
+// Token buckets for each client IP address, created on first use. Safe to use from any goroutine.
+type ipLimiter struct {
+	lock      sync.Mutex
+	capacity  float64
+	rate      float64
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+func newIPLimiter(capacity float64, rate float64) *ipLimiter {
+	return &ipLimiter{
+		capacity:  capacity,
+		rate:      rate,
+		buckets:   make(map[string]*tokenBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Takes a token from the address's bucket, reporting whether the event is allowed.
+func (l *ipLimiter) Allow(ip string) bool {
+	l.lock.Lock()
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		bucket = newTokenBucket(l.capacity, l.rate)
+		l.buckets[ip] = bucket
+	}
+	if time.Since(l.lastPrune) > pruneInterval {
+		l.prune()
+	}
+	l.lock.Unlock()
+	return bucket.Allow()
+}
+
+// Forgets addresses whose buckets would have refilled, as they behave exactly like new ones.
+// Must be called with the lock held.
+func (l *ipLimiter) prune() {
+	l.lastPrune = time.Now()
+	refill := time.Duration(l.capacity / l.rate * float64(time.Second))
+	for ip, bucket := range l.buckets {
+		bucket.lock.Lock()
+		idle := time.Since(bucket.last)
+		bucket.lock.Unlock()
+		if idle > refill {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// Global limits shared by every connection from an address.
+var (
+	ipMessageLimits = newIPLimiter(IPMessageBurst, IPMessageRate)
+	ipCreateLimits  = newIPLimiter(CreateBurst, float64(time.Second)/float64(CreateInterval))
+)
+
+// The IP address a request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Whether a client may send another message, under both its own limit and its address's.
+func (c *Client) allowMessage() bool {
+	return allowFrom(c.messageLimit, c.Address)
+}
+
+// Whether a connection may send another message, under both `limit` and the limit shared by
+// its address, if known.
+func allowFrom(limit *tokenBucket, address string) bool {
+	if !limit.Allow() {
+		return false
+	}
+	return address == "" || ipMessageLimits.Allow(address)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	t.Run("should allow bursts up to capacity, then refill over time", func(t *testing.T) {
+		now := time.Now()
+		bucket := newTokenBucket(3, 1)
+		bucket.now = func() time.Time { return now }
+		bucket.last = now
+
+		for i := range 3 {
+			if !bucket.Allow() {
+				t.Fatalf("expected event %d to be allowed", i)
+			}
+		}
+		if bucket.Allow() {
+			t.Error("expected an empty bucket to refuse events")
+		}
+
+		now = now.Add(1500 * time.Millisecond)
+		if !bucket.Allow() {
+			t.Error("expected a token to have been refilled")
+		}
+		if bucket.Allow() {
+			t.Error("expected only one token to have been refilled")
+		}
+
+		// Refilling stops at capacity.
+		now = now.Add(time.Hour)
+		for range 3 {
+			bucket.Allow()
+		}
+		if bucket.Allow() {
+			t.Error("expected the bucket to hold at most its capacity")
+		}
+	})
+}
+
+func TestIPLimiter(t *testing.T) {
+	t.Run("should limit each address separately", func(t *testing.T) {
+		limiter := newIPLimiter(2, 0.001)
+		for range 2 {
+			if !limiter.Allow("10.0.0.1") {
+				t.Fatal("expected event to be allowed")
+			}
+		}
+		if limiter.Allow("10.0.0.1") {
+			t.Error("expected address to be limited")
+		}
+		if !limiter.Allow("10.0.0.2") {
+			t.Error("expected other addresses not to be limited")
+		}
+	})
+
+	t.Run("should forget addresses whose buckets have refilled", func(t *testing.T) {
+		limiter := newIPLimiter(2, 1)
+		limiter.Allow("10.0.0.1")
+		limiter.buckets["10.0.0.1"].last = time.Now().Add(-time.Hour)
+		limiter.Allow("10.0.0.2")
+
+		limiter.lock.Lock()
+		limiter.prune()
+		limiter.lock.Unlock()
+		if _, ok := limiter.buckets["10.0.0.1"]; ok {
+			t.Error("expected idle address to be forgotten")
+		}
+		if _, ok := limiter.buckets["10.0.0.2"]; !ok {
+			t.Error("expected recent address to be kept")
+		}
+	})
+}
+
+func TestConnectionLimits(t *testing.T) {
+	// Reads until the connection is closed, returning the close code.
+	closeCode := func(t *testing.T, conn *websocket.Conn) int {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) {
+					return closeErr.Code
+				}
+				t.Fatalf("expected a close frame, got %v", err)
+			}
+		}
+	}
+
+	t.Run("should close connections that send too many messages", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		conn := dialGame(t, server, instance.GameId, "")
+
+		for range MessageBurst + 5 {
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"resync"}`))
+		}
+		if code := closeCode(t, conn); code != CloseRateLimited {
+			t.Errorf("expected close code %d, got %d", CloseRateLimited, code)
+		}
+	})
+
+	t.Run("should limit all connections from an address together", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		ipMessageLimits = newIPLimiter(MessageBurst, 0.001)
+
+		// Each connection stays within its own limit, but together they exceed the address's.
+		first := dialGame(t, server, instance.GameId, "")
+		second := dialGame(t, server, instance.GameId, "")
+		for range MessageBurst / 2 {
+			first.WriteMessage(websocket.TextMessage, []byte(`{"type":"resync"}`))
+		}
+		time.Sleep(50 * time.Millisecond)
+		for range MessageBurst/2 + 1 {
+			second.WriteMessage(websocket.TextMessage, []byte(`{"type":"resync"}`))
+		}
+		if code := closeCode(t, second); code != CloseRateLimited {
+			t.Errorf("expected close code %d, got %d", CloseRateLimited, code)
+		}
+	})
+
+	t.Run("should close connections that send oversized frames", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		conn := dialGame(t, server, instance.GameId, "")
+
+		conn.WriteMessage(websocket.TextMessage, make([]byte, MaxFrameSize+1))
+		if code := closeCode(t, conn); code != websocket.CloseMessageTooBig {
+			t.Errorf("expected close code %d, got %d", websocket.CloseMessageTooBig, code)
+		}
+	})
+
+	t.Run("should limit game creation by address", func(t *testing.T) {
+		server := newTestServer(t)
+		ipCreateLimits = newIPLimiter(CreateBurst, float64(time.Second)/float64(CreateInterval))
+
+		for i := range CreateBurst + 1 {
+			res, err := http.Post(server.URL+"/api/create", "application/json", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			expected := http.StatusOK
+			if i == CreateBurst {
+				expected = http.StatusTooManyRequests
+			}
+			if res.StatusCode != expected {
+				t.Errorf("expected request %d to return %d, got %d", i, expected, res.StatusCode)
+			}
+		}
+	})
+}
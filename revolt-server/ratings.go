@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"revolt/game"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// The rating given to accounts before their first rated game.
+	InitialRating = 1500
+	// The most an account's rating can change in a single game.
+	RatingK = 32
+	// The number of recent rating changes returned with a player's stats.
+	StatsHistoryLimit = 20
+	// The default and maximum number of entries on the leaderboard.
+	DefaultLeaderboardSize = 50
+	MaxLeaderboardSize     = 200
+)
+
+var (
+	statsBucket   = []byte("stats")
+	historyBucket = []byte("history")
+)
+
+// Updates multiplayer Elo ratings from a finishing order. Each player is treated as having
+// beaten everyone who finished below them, and the changes are scaled by the number of
+// opponents so a game's total change is the same as a single two player game.
+// `ratings` is given in finishing order, starting with the winner.
+func UpdateRatings(ratings []float64) []float64 {
+	updated := make([]float64, len(ratings))
+	copy(updated, ratings)
+	if len(ratings) < 2 {
+		return updated
+	}
+
+	scale := RatingK / float64(len(ratings)-1)
+	for i := range ratings {
+		change := 0.0
+		for j := range ratings {
+			if i == j {
+				continue
+			}
+			expected := 1 / (1 + math.Pow(10, (ratings[j]-ratings[i])/400))
+			actual := 0.0
+			if i < j {
+				actual = 1
+			}
+			change += actual - expected
+		}
+		updated[i] += scale * change
+	}
+	return updated
+}
+
+// An account's competitive record.
+type PlayerStats struct {
+	AccountId string  `json:"accountId"`
+	Rating    float64 `json:"rating"`
+	Games     int     `json:"games"`
+	Wins      int     `json:"wins"`
+	// Challenges made by the player, and how many caught a bluff.
+	Challenges        int `json:"challenges"`
+	CorrectChallenges int `json:"correctChallenges"`
+	// Bluffs made by the player, and how many went unchallenged.
+	Bluffs           int `json:"bluffs"`
+	SuccessfulBluffs int `json:"successfulBluffs"`
+}
+
+// A single game's effect on an account's rating.
+type RatingChange struct {
+	GameId  string    `json:"gameId"`
+	Time    time.Time `json:"time"`
+	Placing int       `json:"placing"`
+	Players int       `json:"players"`
+	Before  float64   `json:"before"`
+	After   float64   `json:"after"`
+}
+
+// The outcome of a finished game, for the accounts which played in it.
+type GameResult struct {
+	GameId string
+	Time   time.Time
+	// Account IDs in finishing order, starting with the winner.
+	Placings []string
+	// Each account's placing among all of the game's players, alongside Placings, and the
+	// number of players. Anonymous players take up placings but aren't rated.
+	Ranks   []int
+	Players int
+	// Claims made in the game, with players identified by account ID. Claims involving
+	// anonymous players have those players left empty.
+	Claims []game.Claim
+}
+
+// Builds the result of the instance's finished game, covering only players who joined as an
+// account. Returns false if fewer than two accounts played, as there is no one to be rated
+// against. Must be called on the instance goroutine.
+func (gi *GameInstance) result() (GameResult, bool) {
+	placings := gi.Game.Placings()
+	result := GameResult{GameId: gi.GameId, Time: time.Now().UTC(), Players: len(placings)}
+	for i, playerId := range placings {
+		if account, ok := gi.Accounts[playerId]; ok {
+			result.Placings = append(result.Placings, account.Id)
+			result.Ranks = append(result.Ranks, i+1)
+		}
+	}
+	if len(result.Placings) < 2 {
+		return result, false
+	}
+
+	for _, claim := range gi.Game.Claims {
+		result.Claims = append(result.Claims, game.Claim{
+			Player:       gi.Accounts[claim.Player].Id,
+			Bluff:        claim.Bluff,
+			ChallengedBy: gi.Accounts[claim.ChallengedBy].Id,
+		})
+	}
+	return result, true
+}
+
+// Records a finished game, updating each account's stats and rating, and adding to
+// their rating history.
+func (s *AccountStore) RecordResult(result GameResult) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stats := make([]PlayerStats, len(result.Placings))
+		ratings := make([]float64, len(result.Placings))
+		byAccount := map[string]*PlayerStats{}
+		for i, accountId := range result.Placings {
+			var err error
+			stats[i], err = getStats(tx, accountId)
+			if err != nil {
+				return err
+			}
+			ratings[i] = stats[i].Rating
+			byAccount[accountId] = &stats[i]
+		}
+
+		for _, claim := range result.Claims {
+			if challenger, ok := byAccount[claim.ChallengedBy]; ok {
+				challenger.Challenges++
+				if claim.Bluff {
+					challenger.CorrectChallenges++
+				}
+			}
+			if player, ok := byAccount[claim.Player]; ok && claim.Bluff {
+				player.Bluffs++
+				if claim.ChallengedBy == "" {
+					player.SuccessfulBluffs++
+				}
+			}
+		}
+
+		updated := UpdateRatings(ratings)
+		for i := range stats {
+			stats[i].Games++
+			if result.Ranks[i] == 1 {
+				stats[i].Wins++
+			}
+			stats[i].Rating = updated[i]
+			if err := putStats(tx, stats[i]); err != nil {
+				return err
+			}
+
+			err := addHistory(tx, stats[i].AccountId, RatingChange{
+				GameId:  result.GameId,
+				Time:    result.Time,
+				Placing: result.Ranks[i],
+				Players: result.Players,
+				Before:  ratings[i],
+				After:   updated[i],
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Returns an account's stats, which are empty with the initial rating if it hasn't played.
+func (s *AccountStore) Stats(accountId string) (PlayerStats, error) {
+	var stats PlayerStats
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		stats, err = getStats(tx, accountId)
+		return err
+	})
+	return stats, err
+}
+
+// Returns an account's most recent rating changes, newest first.
+func (s *AccountStore) RatingHistory(accountId string, limit int) ([]RatingChange, error) {
+	history := []RatingChange{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(historyBucket).Bucket([]byte(accountId))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for key, value := cursor.Last(); key != nil && len(history) < limit; key, value = cursor.Prev() {
+			var change RatingChange
+			if err := json.Unmarshal(value, &change); err != nil {
+				return err
+			}
+			history = append(history, change)
+		}
+		return nil
+	})
+	return history, err
+}
+
+// Returns the stats of the highest rated accounts which have played at least one game.
+func (s *AccountStore) Leaderboard(limit int) ([]PlayerStats, error) {
+	leaderboard := []PlayerStats{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(statsBucket).ForEach(func(_ []byte, value []byte) error {
+			var stats PlayerStats
+			if err := json.Unmarshal(value, &stats); err != nil {
+				return err
+			}
+			leaderboard = append(leaderboard, stats)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(leaderboard, func(i, j int) bool {
+		return leaderboard[i].Rating > leaderboard[j].Rating
+	})
+	if len(leaderboard) > limit {
+		leaderboard = leaderboard[:limit]
+	}
+	return leaderboard, nil
+}
+
+func getStats(tx *bolt.Tx, accountId string) (PlayerStats, error) {
+	data := tx.Bucket(statsBucket).Get([]byte(accountId))
+	if data == nil {
+		return PlayerStats{AccountId: accountId, Rating: InitialRating}, nil
+	}
+	var stats PlayerStats
+	err := json.Unmarshal(data, &stats)
+	return stats, err
+}
+
+func putStats(tx *bolt.Tx, stats PlayerStats) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(statsBucket).Put([]byte(stats.AccountId), data)
+}
+
+// Appends a rating change to an account's history, keyed by sequence number so
+// entries are kept in order.
+func addHistory(tx *bolt.Tx, accountId string, change RatingChange) error {
+	bucket, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(accountId))
+	if err != nil {
+		return err
+	}
+	sequence, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequence)
+	return bucket.Put(key, data)
+}
+
+// Records a rated game's result for the accounts which played, if accounts are enabled.
+// Runs in the background, so the instance isn't held up by the database.
+func recordResult(instance *GameInstance) {
+	if accounts == nil || !instance.Rated {
+		return
+	}
+	result, ok := instance.result()
+	if !ok {
+		return
+	}
+	go func() {
+		err := accounts.RecordResult(result)
+		if err != nil {
+			instance.Logger.Error("failed to record game result", "error", err)
+			return
+		}
+		instance.Logger.Info("recorded game result", "accounts", len(result.Placings))
+	}()
+}
+
+// A leaderboard entry.
+type LeaderboardEntry struct {
+	Rank        int     `json:"rank"`
+	AccountId   string  `json:"accountId"`
+	DisplayName string  `json:"displayName"`
+	Avatar      string  `json:"avatar"`
+	Rating      float64 `json:"rating"`
+	Games       int     `json:"games"`
+	Wins        int     `json:"wins"`
+}
+
+// Sent in response to a request for a player's stats.
+type StatsResponse struct {
+	PlayerStats
+	DisplayName string `json:"displayName"`
+	Avatar      string `json:"avatar"`
+	// The fraction of the player's challenges which caught a bluff.
+	ChallengeAccuracy float64 `json:"challengeAccuracy"`
+	// The fraction of the player's bluffs which went unchallenged.
+	BluffSuccess float64        `json:"bluffSuccess"`
+	History      []RatingChange `json:"history"`
+}
+
+// Returns `n / d`, or zero if `d` is zero.
+func ratio(n int, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// Serves the highest rated accounts at `GET /leaderboard`, up to `?limit=`.
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := DefaultLeaderboardSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, MaxLeaderboardSize)
+	}
+
+	leaderboard, err := accounts.Leaderboard(limit)
+	if err != nil {
+		slog.Error("failed to read leaderboard", "error", err)
+		http.Error(w, "failed to read leaderboard", http.StatusInternalServerError)
+		return
+	}
+
+	entries := []LeaderboardEntry{}
+	for i, stats := range leaderboard {
+		entry := LeaderboardEntry{
+			Rank:      i + 1,
+			AccountId: stats.AccountId,
+			Rating:    math.Round(stats.Rating),
+			Games:     stats.Games,
+			Wins:      stats.Wins,
+		}
+		if account, err := accounts.Get(stats.AccountId); err == nil {
+			entry.DisplayName = account.Profile.DisplayName
+			entry.Avatar = account.Profile.Avatar
+		}
+		entries = append(entries, entry)
+	}
+	writeJSON(w, entries)
+}
+
+// Serves an account's stats and recent rating history at `GET /players/{id}/stats`.
+func playerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	account, err := accounts.Get(r.PathValue("id"))
+	if errors.Is(err, ErrAccountNotFound) {
+		http.Error(w, "player not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to read account", "error", err)
+		http.Error(w, "failed to read stats", http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := accounts.Stats(account.Id)
+	if err != nil {
+		slog.Error("failed to read stats", "error", err)
+		http.Error(w, "failed to read stats", http.StatusInternalServerError)
+		return
+	}
+	history, err := accounts.RatingHistory(account.Id, StatsHistoryLimit)
+	if err != nil {
+		slog.Error("failed to read rating history", "error", err)
+		http.Error(w, "failed to read stats", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, StatsResponse{
+		PlayerStats:       stats,
+		DisplayName:       account.Profile.DisplayName,
+		Avatar:            account.Profile.Avatar,
+		ChallengeAccuracy: ratio(stats.CorrectChallenges, stats.Challenges),
+		BluffSuccess:      ratio(stats.SuccessfulBluffs, stats.Bluffs),
+		History:           history,
+	})
+}
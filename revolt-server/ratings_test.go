@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"revolt/game"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUpdateRatings(t *testing.T) {
+	t.Run("should match two player Elo", func(t *testing.T) {
+		updated := UpdateRatings([]float64{1500, 1500})
+
+		if updated[0] != 1516 || updated[1] != 1484 {
+			t.Errorf("expected 1516 and 1484, got %v", updated)
+		}
+	})
+
+	t.Run("should order changes by placing and conserve rating", func(t *testing.T) {
+		ratings := []float64{1500, 1500, 1500, 1500}
+		updated := UpdateRatings(ratings)
+
+		total := 0.0
+		for i := range updated {
+			total += updated[i] - ratings[i]
+			if i > 0 && updated[i] >= updated[i-1] {
+				t.Errorf("expected placing %d to gain less than placing %d, got %v", i+1, i, updated)
+			}
+		}
+		if math.Abs(total) > 1e-9 {
+			t.Errorf("expected total change to be zero, got %f", total)
+		}
+	})
+
+	t.Run("should reward beating a stronger player more", func(t *testing.T) {
+		upset := UpdateRatings([]float64{1400, 1600})
+		expected := UpdateRatings([]float64{1600, 1400})
+
+		if upset[0]-1400 <= expected[0]-1600 {
+			t.Errorf("expected an upset to gain more, got %v and %v", upset, expected)
+		}
+	})
+}
+
+func TestRecordResult(t *testing.T) {
+	passwordCost = bcrypt.MinCost
+	setup := func(t *testing.T) (*AccountStore, Account, Account) {
+		store, err := OpenAccountStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		winner, _ := store.Create("winner", "password1")
+		loser, _ := store.Create("loser", "password1")
+		return store, winner, loser
+	}
+
+	t.Run("should update stats, ratings and history", func(t *testing.T) {
+		store, winner, loser := setup(t)
+
+		err := store.RecordResult(GameResult{
+			GameId:   "game",
+			Placings: []string{winner.Id, loser.Id},
+			Ranks:    []int{1, 2},
+			Players:  2,
+			Claims: []game.Claim{
+				{Player: winner.Id, Bluff: true},
+				{Player: loser.Id, Bluff: true, ChallengedBy: winner.Id},
+				{Player: winner.Id, Bluff: false, ChallengedBy: loser.Id},
+				{Player: "", Bluff: true, ChallengedBy: loser.Id},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stats, _ := store.Stats(winner.Id)
+		expected := PlayerStats{
+			AccountId: winner.Id, Rating: 1516, Games: 1, Wins: 1,
+			Challenges: 1, CorrectChallenges: 1, Bluffs: 1, SuccessfulBluffs: 1,
+		}
+		if stats != expected {
+			t.Errorf("expected %+v, got %+v", expected, stats)
+		}
+
+		stats, _ = store.Stats(loser.Id)
+		expected = PlayerStats{
+			AccountId: loser.Id, Rating: 1484, Games: 1, Wins: 0,
+			Challenges: 2, CorrectChallenges: 1, Bluffs: 1, SuccessfulBluffs: 0,
+		}
+		if stats != expected {
+			t.Errorf("expected %+v, got %+v", expected, stats)
+		}
+
+		history, _ := store.RatingHistory(loser.Id, 10)
+		if len(history) != 1 || history[0].Placing != 2 || history[0].Before != 1500 || history[0].After != 1484 {
+			t.Errorf("expected a rating change for the loss, got %+v", history)
+		}
+	})
+
+	t.Run("should list accounts by rating", func(t *testing.T) {
+		store, winner, loser := setup(t)
+		store.RecordResult(GameResult{GameId: "1", Placings: []string{loser.Id, winner.Id}, Ranks: []int{1, 2}, Players: 2})
+		store.RecordResult(GameResult{GameId: "2", Placings: []string{loser.Id, winner.Id}, Ranks: []int{1, 2}, Players: 2})
+
+		leaderboard, _ := store.Leaderboard(10)
+		if len(leaderboard) != 2 || leaderboard[0].AccountId != loser.Id {
+			t.Errorf("expected loser to lead, got %+v", leaderboard)
+		}
+		history, _ := store.RatingHistory(loser.Id, 10)
+		if len(history) != 2 || history[0].GameId != "2" {
+			t.Errorf("expected newest history first, got %+v", history)
+		}
+	})
+}
+
+func TestGameResult(t *testing.T) {
+	t.Run("should only include players who joined as an account", func(t *testing.T) {
+		i := NewGameInstance("")
+		i.Game.AddPlayer("0", "One")
+		i.Game.AddPlayer("1", "Two")
+		i.Game.AddPlayer("2", "Three")
+		i.Accounts["0"] = PlayerAccount{Id: "a"}
+		i.Accounts["2"] = PlayerAccount{Id: "c"}
+		i.Game.Winner = "2"
+		i.Game.Eliminated = []string{"0", "1"}
+		i.Game.Claims = []game.Claim{{Player: "1", Bluff: true, ChallengedBy: "0"}}
+
+		result, ok := i.result()
+		if !ok {
+			t.Fatal("expected a result")
+		}
+		if len(result.Placings) != 2 || result.Placings[0] != "c" || result.Placings[1] != "a" {
+			t.Errorf("expected placings [c a], got %v", result.Placings)
+		}
+		expected := game.Claim{Player: "", Bluff: true, ChallengedBy: "a"}
+		if result.Claims[0] != expected {
+			t.Errorf("expected claim %+v, got %+v", expected, result.Claims[0])
+		}
+	})
+
+	t.Run("should keep placings among anonymous players", func(t *testing.T) {
+		passwordCost = bcrypt.MinCost
+		store, err := OpenAccountStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		second, _ := store.Create("second", "password1")
+		last, _ := store.Create("last", "password1")
+
+		i := NewGameInstance("")
+		for _, id := range []string{"0", "1", "2", "3"} {
+			i.Game.AddPlayer(id, "Player "+id)
+		}
+		i.Accounts["1"] = PlayerAccount{Id: second.Id}
+		i.Accounts["3"] = PlayerAccount{Id: last.Id}
+		i.Game.Winner = "0"
+		i.Game.Eliminated = []string{"3", "2", "1"}
+
+		result, ok := i.result()
+		if !ok {
+			t.Fatal("expected a result")
+		}
+		if err := store.RecordResult(result); err != nil {
+			t.Fatal(err)
+		}
+		stats, _ := store.Stats(second.Id)
+		if stats.Wins != 0 || stats.Games != 1 || stats.Rating <= InitialRating {
+			t.Errorf("expected a rated game without a win, got %+v", stats)
+		}
+		history, _ := store.RatingHistory(last.Id, 10)
+		if len(history) != 1 || history[0].Placing != 4 || history[0].Players != 4 {
+			t.Errorf("expected 4th of 4, got %+v", history)
+		}
+	})
+
+	t.Run("should not rate a game with a single account", func(t *testing.T) {
+		i := NewGameInstance("")
+		i.Game.AddPlayer("0", "One")
+		i.Game.AddPlayer("1", "Two")
+		i.Accounts["1"] = PlayerAccount{Id: "b"}
+		i.Game.Winner = "0"
+		i.Game.Eliminated = []string{"1"}
+
+		if _, ok := i.result(); ok {
+			t.Error("expected no result")
+		}
+	})
+}
+
+func TestRatingHandlers(t *testing.T) {
+	passwordCost = bcrypt.MinCost
+	t.Run("should serve the leaderboard and player stats", func(t *testing.T) {
+		store, err := OpenAccountStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts = store
+		defer func() {
+			store.Close()
+			accounts = nil
+		}()
+		winner, _ := store.Create("winner", "password1")
+		loser, _ := store.Create("loser", "password1")
+		store.RecordResult(GameResult{
+			GameId:   "game",
+			Placings: []string{winner.Id, loser.Id},
+			Ranks:    []int{1, 2},
+			Players:  2,
+			Claims:   []game.Claim{{Player: winner.Id, Bluff: true}},
+		})
+
+		mux := http.NewServeMux()
+		registerAccountRoutes(mux)
+		server := httptest.NewServer(mux)
+		defer server.Close()
+
+		res, err := http.Get(server.URL + "/leaderboard?limit=1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var leaderboard []LeaderboardEntry
+		json.NewDecoder(res.Body).Decode(&leaderboard)
+		res.Body.Close()
+		if len(leaderboard) != 1 || leaderboard[0].DisplayName != "winner" || leaderboard[0].Rank != 1 {
+			t.Errorf("expected winner to top the leaderboard, got %+v", leaderboard)
+		}
+
+		res, err = http.Get(server.URL + "/players/" + winner.Id + "/stats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stats StatsResponse
+		json.NewDecoder(res.Body).Decode(&stats)
+		res.Body.Close()
+		if stats.Wins != 1 || stats.BluffSuccess != 1 || len(stats.History) != 1 {
+			t.Errorf("expected winner's stats, got %+v", stats)
+		}
+
+		res, _ = http.Get(server.URL + "/players/unknown/stats")
+		res.Body.Close()
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// A quick reaction a player can fire at a peer or the pending action.
+type Reaction string
+
+const (
+	DoubtReaction      Reaction = "doubt"
+	NiceBluffReaction  Reaction = "nice_bluff"
+	ThinkingReaction   Reaction = "thinking"
+	WellPlayedReaction Reaction = "well_played"
+)
+
+// Every reaction players can send.
+var Reactions = []Reaction{DoubtReaction, NiceBluffReaction, ThinkingReaction, WellPlayedReaction}
+
+const (
+	// Clients can send a burst of `ReactionBurst` reactions, then one every `ReactionInterval`.
+	ReactionBurst    = 3
+	ReactionInterval = 2 * time.Second
+)
+
+// Sends a reaction from a player to everyone in the game. Reactions aim at either a peer or the
+// pending action, and aren't kept in the game state. Must be called on the instance goroutine.
+func (gi *GameInstance) react(client *Client, payload ReactPayload) error {
+	if gi.isSpectator(client) {
+		return rejectCommand(RejectNotInGame, "spectators can't react")
+	}
+	if gi.Muted[client.Id] {
+		return rejectCommand(RejectMuted, "you have been muted by the owner")
+	}
+	if !slices.Contains(Reactions, payload.Reaction) {
+		return rejectCommand(RejectInvalidPayload, "unknown reaction: %s", payload.Reaction)
+	}
+
+	event := ReactionPayload{
+		From:      client.Id,
+		Reaction:  payload.Reaction,
+		Timestamp: time.Now(),
+	}
+	switch {
+	case payload.Target != "" && payload.PendingAction:
+		return rejectCommand(RejectInvalidPayload, "reactions can target a player or the pending action, not both")
+	case payload.Target != "":
+		if _, ok := gi.Game.Players[payload.Target]; !ok {
+			return rejectCommand(RejectNotInGame, "player with id %s not found", payload.Target)
+		}
+		if payload.Target == client.Id {
+			return rejectCommand(RejectInvalidPayload, "players can't react to themselves")
+		}
+		event.Target = payload.Target
+	case payload.PendingAction:
+		if gi.Status != InProgress || gi.Game.PendingAction.Type == "" {
+			return rejectCommand(RejectInvalidMove, "there is no pending action to react to")
+		}
+		action := gi.Game.PendingAction
+		event.Action = &action
+		event.Target = gi.Game.GetLeader().Id
+	default:
+		return rejectCommand(RejectInvalidPayload, "reactions must target a player or the pending action")
+	}
+
+	// Limited last, so rejected reactions don't use up the allowance.
+	if !client.reactionLimit.Allow() {
+		return rejectCommand(RejectRateLimited, "sending reactions too quickly")
+	}
+	gi.SendAll(ServerMessage{Type: ReactionMessage, Payload: event})
+	return nil
+}
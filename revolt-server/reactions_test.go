@@ -0,0 +1,86 @@
+package main
+
+import (
+	"revolt/game"
+	"testing"
+)
+
+func TestReactions(t *testing.T) {
+	// Creates a running game between two players, with one spectator.
+	setup := func() (*GameInstance, []*Client, *Client) {
+		i, players := newTestGame(2)
+		i.Status = InProgress
+		return i, players, addTestSpectator(i)
+	}
+
+	t.Run("should send reactions at a peer to everyone", func(t *testing.T) {
+		i, players, spectator := setup()
+
+		err := sendMessage(i, players[0], ReactMessage, ReactPayload{Reaction: DoubtReaction, Target: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, client := range []*Client{players[0], players[1], spectator} {
+			reactions := received[ReactionPayload](client, ReactionMessage)
+			if len(reactions) != 1 || reactions[0].From != "0" || reactions[0].Target != "1" || reactions[0].Action != nil {
+				t.Errorf("expected %s to receive the reaction, got %+v", client.Id, reactions)
+			}
+		}
+	})
+
+	t.Run("should send reactions at the pending action", func(t *testing.T) {
+		i, players, _ := setup()
+
+		if err := sendMessage(i, players[1], ReactMessage, ReactPayload{Reaction: ThinkingReaction, PendingAction: true}); !rejectedWith(err, RejectInvalidMove) {
+			t.Errorf("expected reaction without a pending action to be rejected, got %v", err)
+		}
+
+		i.Game.PendingAction = game.Action{Type: game.Tax}
+		if err := sendMessage(i, players[1], ReactMessage, ReactPayload{Reaction: NiceBluffReaction, PendingAction: true}); err != nil {
+			t.Fatal(err)
+		}
+		reactions := received[ReactionPayload](players[0], ReactionMessage)
+		if len(reactions) != 1 || reactions[0].Action == nil || reactions[0].Action.Type != game.Tax || reactions[0].Target != "0" {
+			t.Errorf("expected a reaction at the leader's tax, got %+v", reactions)
+		}
+	})
+
+	t.Run("should validate reactions against the game", func(t *testing.T) {
+		i, players, spectator := setup()
+
+		cases := map[string]struct {
+			client  *Client
+			payload ReactPayload
+			reason  string
+		}{
+			"unknown reaction": {players[0], ReactPayload{Reaction: "wave", Target: "1"}, RejectInvalidPayload},
+			"no target":        {players[0], ReactPayload{Reaction: DoubtReaction}, RejectInvalidPayload},
+			"two targets":      {players[0], ReactPayload{Reaction: DoubtReaction, Target: "1", PendingAction: true}, RejectInvalidPayload},
+			"missing peer":     {players[0], ReactPayload{Reaction: DoubtReaction, Target: "9"}, RejectNotInGame},
+			"themselves":       {players[0], ReactPayload{Reaction: DoubtReaction, Target: "0"}, RejectInvalidPayload},
+			"from a spectator": {spectator, ReactPayload{Reaction: DoubtReaction, Target: "0"}, RejectNotInGame},
+		}
+		for name, c := range cases {
+			if err := sendMessage(i, c.client, ReactMessage, c.payload); !rejectedWith(err, c.reason) {
+				t.Errorf("expected reaction %s to be rejected as %s, got %v", name, c.reason, err)
+			}
+		}
+		if reactions := received[ReactionPayload](players[1], ReactionMessage); len(reactions) != 0 {
+			t.Errorf("expected no reactions to be sent, got %+v", reactions)
+		}
+	})
+
+	t.Run("should rate limit reactions", func(t *testing.T) {
+		i, players, _ := setup()
+
+		for range ReactionBurst {
+			if err := sendMessage(i, players[0], ReactMessage, ReactPayload{Reaction: DoubtReaction, Target: "1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := sendMessage(i, players[0], ReactMessage, ReactPayload{Reaction: DoubtReaction, Target: "1"})
+		if !rejectedWith(err, RejectRateLimited) {
+			t.Errorf("expected reaction to be rate limited, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// The longest time allowed to connect to Redis or for it to confirm a subscription.
+	RedisTimeout = 5 * time.Second
+	// The largest bulk string or array accepted from Redis.
+	maxRedisLength = 64 * 1024 * 1024
+	// The time between attempts to reconnect to Redis, doubling after each failure up to the
+	// maximum.
+	RedisReconnectDelay    = 100 * time.Millisecond
+	RedisMaxReconnectDelay = 10 * time.Second
+)
+
+// A bus using Redis pub/sub, so nodes in separate processes can talk through a Redis server.
+// It speaks just enough of the Redis protocol (RESP) to publish and subscribe. Messages are
+// delivered on a single goroutine reading the subscriber connection, which also reconnects and
+// resubscribes if the connection is lost. Messages published while disconnected are lost.
+type RedisBus struct {
+	address string
+	// Sends PUBLISH commands and reads their replies.
+	publishLock sync.Mutex
+	publisher   *redisConn
+	// Sends SUBSCRIBE and UNSUBSCRIBE commands. Replies are read by `receive`.
+	subscribeLock sync.Mutex
+	subscriber    *redisConn
+	onReconnect   func()
+
+	subscriptions subscriptions
+	// Channels waiting for a subscription to be confirmed, keyed by subject.
+	confirmLock sync.Mutex
+	confirms    map[string][]chan struct{}
+
+	connected atomic.Bool
+	closed    atomic.Bool
+	// Closed when the bus is closed, to stop waiting to reconnect.
+	done chan struct{}
+}
+
+// Connects to the Redis server at `address`, such as `localhost:6379`.
+func DialRedis(address string) (*RedisBus, error) {
+	publisher, err := dialRedisConn(address)
+	if err != nil {
+		return nil, err
+	}
+	subscriber, err := dialRedisConn(address)
+	if err != nil {
+		publisher.Close()
+		return nil, err
+	}
+	b := &RedisBus{
+		address:    address,
+		publisher:  publisher,
+		subscriber: subscriber,
+		confirms:   make(map[string][]chan struct{}),
+		done:       make(chan struct{}),
+	}
+	b.connected.Store(true)
+	go b.receive()
+	return b, nil
+}
+
+func (b *RedisBus) Publish(subject string, message []byte) error {
+	if b.closed.Load() {
+		return ErrBusClosed
+	}
+	b.publishLock.Lock()
+	defer b.publishLock.Unlock()
+	// Don't hang on a dead connection, which would also hold up reconnecting.
+	b.publisher.conn.SetDeadline(time.Now().Add(RedisTimeout))
+	if err := b.publisher.WriteCommand("PUBLISH", subject, string(message)); err != nil {
+		return err
+	}
+	_, err := b.publisher.ReadValue()
+	return err
+}
+
+// Subscribes to `subject`, waiting for Redis to confirm the first subscription to a subject so
+// messages published once this returns are received.
+func (b *RedisBus) Subscribe(subject string, handler func([]byte)) (func(), error) {
+	if b.closed.Load() {
+		return nil, ErrBusClosed
+	}
+	id, first := b.subscriptions.add(subject, handler)
+	unsubscribe := func() {
+		if b.subscriptions.remove(subject, id) && !b.closed.Load() {
+			b.subscribeLock.Lock()
+			defer b.subscribeLock.Unlock()
+			b.subscriber.WriteCommand("UNSUBSCRIBE", subject)
+		}
+	}
+	if !first {
+		return unsubscribe, nil
+	}
+
+	confirmed := make(chan struct{})
+	b.confirmLock.Lock()
+	b.confirms[subject] = append(b.confirms[subject], confirmed)
+	b.confirmLock.Unlock()
+
+	b.subscribeLock.Lock()
+	err := b.subscriber.WriteCommand("SUBSCRIBE", subject)
+	b.subscribeLock.Unlock()
+	if err != nil {
+		b.subscriptions.remove(subject, id)
+		return nil, err
+	}
+	select {
+	case <-confirmed:
+		return unsubscribe, nil
+	case <-time.After(RedisTimeout):
+		b.subscriptions.remove(subject, id)
+		return nil, fmt.Errorf("timed out subscribing to %s", subject)
+	}
+}
+
+// Reads pushed messages and subscription confirmations, reconnecting whenever the connection
+// is lost, until the bus is closed.
+func (b *RedisBus) receive() {
+	for {
+		err := b.read()
+		if b.closed.Load() {
+			return
+		}
+		b.connected.Store(false)
+		slog.Error("lost connection to redis, reconnecting", "error", err)
+		if !b.reconnect() {
+			return
+		}
+		slog.Info("reconnected to redis")
+		b.subscribeLock.Lock()
+		handler := b.onReconnect
+		b.subscribeLock.Unlock()
+		if handler != nil {
+			go handler()
+		}
+	}
+}
+
+// Calls `handler` each time the bus reconnects, so callers can recover anything published
+// while it was disconnected.
+func (b *RedisBus) OnReconnect(handler func()) {
+	b.subscribeLock.Lock()
+	defer b.subscribeLock.Unlock()
+	b.onReconnect = handler
+}
+
+// Reads from the subscriber connection until it fails.
+func (b *RedisBus) read() error {
+	for {
+		value, err := b.subscriber.ReadValue()
+		if err != nil {
+			return err
+		}
+		push, ok := value.([]any)
+		if !ok || len(push) < 3 {
+			continue
+		}
+		kind, _ := push[0].(string)
+		subject, _ := push[1].(string)
+		switch kind {
+		case "message":
+			message, _ := push[2].(string)
+			b.subscriptions.deliver(subject, []byte(message))
+		case "subscribe":
+			b.confirmLock.Lock()
+			waiting := b.confirms[subject]
+			delete(b.confirms, subject)
+			b.confirmLock.Unlock()
+			for _, confirmed := range waiting {
+				close(confirmed)
+			}
+		}
+	}
+}
+
+// Replaces both connections, retrying with backoff, and subscribes the new subscriber to every
+// subject with handlers. Returns false if the bus is closed first.
+func (b *RedisBus) reconnect() bool {
+	delay := RedisReconnectDelay
+	for {
+		select {
+		case <-b.done:
+			return false
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, RedisMaxReconnectDelay)
+
+		publisher, err := dialRedisConn(b.address)
+		if err != nil {
+			slog.Warn("couldn't reconnect to redis", "error", err, "retry", delay)
+			continue
+		}
+		subscriber, err := dialRedisConn(b.address)
+		if err != nil {
+			publisher.Close()
+			slog.Warn("couldn't reconnect to redis", "error", err, "retry", delay)
+			continue
+		}
+
+		b.publishLock.Lock()
+		b.subscribeLock.Lock()
+		if b.closed.Load() {
+			b.subscribeLock.Unlock()
+			b.publishLock.Unlock()
+			publisher.Close()
+			subscriber.Close()
+			return false
+		}
+		b.publisher.Close()
+		b.subscriber.Close()
+		b.publisher, b.subscriber = publisher, subscriber
+		if subjects := b.subscriptions.names(); len(subjects) > 0 {
+			err = subscriber.WriteCommand(append([]string{"SUBSCRIBE"}, subjects...)...)
+		}
+		b.subscribeLock.Unlock()
+		b.publishLock.Unlock()
+		if err != nil {
+			slog.Warn("couldn't resubscribe to redis", "error", err, "retry", delay)
+			continue
+		}
+		b.connected.Store(true)
+		return true
+	}
+}
+
+func (b *RedisBus) Connected() bool {
+	return b.connected.Load() && !b.closed.Load()
+}
+
+func (b *RedisBus) Close() error {
+	if b.closed.Swap(true) {
+		return nil
+	}
+	close(b.done)
+	b.publishLock.Lock()
+	defer b.publishLock.Unlock()
+	b.subscribeLock.Lock()
+	defer b.subscribeLock.Unlock()
+	b.subscriber.Close()
+	return b.publisher.Close()
+}
+
+// A connection speaking RESP, the Redis protocol.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialRedisConn(address string) (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", address, RedisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return newRedisConn(conn), nil
+}
+
+func newRedisConn(conn net.Conn) *redisConn {
+	return &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// An error reply from Redis.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Sends a command as an array of bulk strings.
+func (c *redisConn) WriteCommand(args ...string) error {
+	buffer := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buffer = append(buffer, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buffer = append(buffer, arg...)
+		buffer = append(buffer, "\r\n"...)
+	}
+	_, err := c.conn.Write(buffer)
+	return err
+}
+
+// Reads a single value. Simple and bulk strings are returned as strings, integers as int64,
+// arrays as []any and nulls as nil. Error replies are returned as a `redisError`.
+func (c *redisConn) ReadValue() (any, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(body)
+		if err != nil || length > maxRedisLength {
+			return nil, errors.New("redis: invalid bulk string length")
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		bulk := make([]byte, length+2)
+		if _, err := io.ReadFull(c.reader, bulk); err != nil {
+			return nil, err
+		}
+		return string(bulk[:length]), nil
+	case '*':
+		length, err := strconv.Atoi(body)
+		if err != nil || length > maxRedisLength {
+			return nil, errors.New("redis: invalid array length")
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		values := make([]any, length)
+		for i := range values {
+			if values[i], err = c.ReadValue(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"revolt/game"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A JSON Schema document. Only the keywords the protocol needs are supported.
+type Schema struct {
+	Schema string `json:"$schema,omitempty"`
+	Id     string `json:"$id,omitempty"`
+	Ref    string `json:"$ref,omitempty"`
+	Title  string `json:"title,omitempty"`
+	// A type name, or a list of names for values which can take more than one type.
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Const                any                `json:"const,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+
+	// Non-standard keyword holding the protocol version the schema describes.
+	ProtocolVersion int `json:"x-protocol-version,omitempty"`
+}
+
+// Allowed values for string enum types in the protocol.
+var schemaEnums = map[reflect.Type][]string{
+	// Cards and actions are empty in state broadcasts when nothing is pending.
+	reflect.TypeFor[game.Card]():       enum(append([]game.Card{""}, game.Cards...)),
+	reflect.TypeFor[game.ActionType](): enum(append([]game.ActionType{""}, game.ActionTypes...)),
+	reflect.TypeFor[game.TurnState]():  enum(game.TurnStates),
+	reflect.TypeFor[GameStatus]():      enum(GameStatuses),
+	reflect.TypeFor[UpdateMode]():      enum(UpdateModes),
+	reflect.TypeFor[ChatChannel]():     enum(ChatChannels),
+	reflect.TypeFor[Reaction]():        enum(Reactions),
+}
+
+// Converts a list of string constants to enum values.
+func enum[T ~string](values []T) []string {
+	names := make([]string, len(values))
+	for i, value := range values {
+		names[i] = string(value)
+	}
+	return names
+}
+
+// Generates schemas from Go types, collecting named structs as shared definitions.
+type schemaGenerator struct {
+	defs map[string]*Schema
+}
+
+// Returns the schema for a Go type, adding a definition and returning a reference for structs.
+func (g *schemaGenerator) schemaFor(t reflect.Type) *Schema {
+	if values, ok := schemaEnums[t]; ok {
+		return &Schema{Type: "string", Enum: values}
+	}
+	if t == reflect.TypeFor[time.Time]() {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	if t == reflect.TypeFor[Payload]() {
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schemaFor(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	// Nil slices and maps are encoded as null.
+	case reflect.Slice:
+		return &Schema{Type: []string{"array", "null"}, Items: g.schemaFor(t.Elem())}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: []string{"object", "null"}, AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.defs[name]; !ok {
+			// Reserve the name first in case the struct refers to itself.
+			g.defs[name] = &Schema{}
+			*g.defs[name] = *g.structSchema(t)
+		}
+		return &Schema{Ref: "#/$defs/" + name}
+	default:
+		// Interfaces and anything else accept any value.
+		return &Schema{}
+	}
+}
+
+// Builds an object schema from a struct's exported, JSON tagged fields.
+// Fields aren't marked as required, as the server accepts messages with fields left out.
+func (g *schemaGenerator) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	g.addFields(schema, t)
+	return schema
+}
+
+func (g *schemaGenerator) addFields(schema *Schema, t reflect.Type) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		// Embedded structs without a name have their fields promoted.
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			g.addFields(schema, field.Type)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = g.schemaFor(field.Type)
+	}
+}
+
+// Builds the schema for a message envelope with a fixed type and the given payload.
+// Any other fields of the envelope type are included alongside.
+func (g *schemaGenerator) envelope(envelope reflect.Type, messageType string, payload any) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	g.addFields(schema, envelope)
+	delete(schema.Properties, "payload")
+	schema.Properties["type"] = &Schema{Type: "string", Const: messageType}
+	schema.Required = []string{"type"}
+
+	if payload != nil {
+		schema.Properties["payload"] = g.schemaFor(reflect.TypeOf(payload))
+		schema.Required = append(schema.Required, "payload")
+	}
+	return schema
+}
+
+// Generates a JSON Schema describing every inbound and outbound message in the protocol.
+func ProtocolSchema() *Schema {
+	g := schemaGenerator{defs: map[string]*Schema{}}
+
+	inbound := &Schema{}
+	for _, messageType := range sortedKeys(MessagePayloads) {
+		inbound.OneOf = append(inbound.OneOf, g.envelope(reflect.TypeFor[Message](), string(messageType), MessagePayloads[messageType]))
+	}
+
+	outbound := &Schema{}
+	for _, messageType := range sortedKeys(ServerMessagePayloads) {
+		payload := ServerMessagePayloads[messageType]
+		// State broadcasts are sent without an envelope.
+		if messageType == StateMessage {
+			outbound.OneOf = append(outbound.OneOf, g.schemaFor(reflect.TypeOf(payload)))
+			continue
+		}
+		outbound.OneOf = append(outbound.OneOf, g.envelope(reflect.TypeFor[ServerMessage](), string(messageType), payload))
+	}
+
+	g.defs["InboundMessage"] = inbound
+	g.defs["OutboundMessage"] = outbound
+
+	return &Schema{
+		Schema:          "https://json-schema.org/draft/2020-12/schema",
+		Id:              "revolt-protocol",
+		Title:           "Revolt websocket protocol",
+		ProtocolVersion: ProtocolVersion,
+		OneOf: []*Schema{
+			{Ref: "#/$defs/InboundMessage"},
+			{Ref: "#/$defs/OutboundMessage"},
+		},
+		Defs: g.defs,
+	}
+}
+
+// Returns the keys of a map in sorted order, so generated schemas are stable.
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// Serves the protocol schema.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, ProtocolSchema())
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Checks a decoded JSON value against a schema, returning a description of each problem
+// found. Only the keywords the generator uses are checked.
+func validateSchema(root *Schema, schema *Schema, value any, path string) []string {
+	if schema.Ref != "" {
+		return validateSchema(root, root.Defs[strings.TrimPrefix(schema.Ref, "#/$defs/")], value, path)
+	}
+	if schema.OneOf != nil {
+		// Report the problems with the closest variant if none match.
+		matches := 0
+		var closest []string
+		for _, variant := range schema.OneOf {
+			problems := validateSchema(root, variant, value, path)
+			if len(problems) == 0 {
+				matches++
+			} else if closest == nil || len(problems) < len(closest) {
+				closest = problems
+			}
+		}
+		switch matches {
+		case 0:
+			return closest
+		case 1:
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: expected one matching variant, got %d", path, matches)}
+	}
+
+	if schema.Type != nil {
+		types, ok := schema.Type.([]string)
+		if !ok {
+			types = []string{schema.Type.(string)}
+		}
+		if !slices.Contains(types, jsonType(value)) {
+			return []string{fmt.Sprintf("%s: expected %v, got %s", path, types, jsonType(value))}
+		}
+	}
+	if schema.Const != nil && value != schema.Const {
+		return []string{fmt.Sprintf("%s: expected %v, got %v", path, schema.Const, value)}
+	}
+	if schema.Enum != nil && !slices.Contains(schema.Enum, value.(string)) {
+		return []string{fmt.Sprintf("%s: %q isn't one of %v", path, value, schema.Enum)}
+	}
+
+	problems := []string{}
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: missing %s", path, name))
+			}
+		}
+		for name, field := range v {
+			fieldSchema := schema.Properties[name]
+			if fieldSchema == nil {
+				fieldSchema = schema.AdditionalProperties
+			}
+			if fieldSchema == nil {
+				problems = append(problems, fmt.Sprintf("%s: unexpected field %s", path, name))
+				continue
+			}
+			problems = append(problems, validateSchema(root, fieldSchema, field, path+"/"+name)...)
+		}
+	case []any:
+		for n, item := range v {
+			if schema.Items != nil {
+				problems = append(problems, validateSchema(root, schema.Items, item, fmt.Sprintf("%s/%d", path, n))...)
+			}
+		}
+	}
+	return problems
+}
+
+// Returns the JSON Schema type name of a decoded JSON value.
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+func TestProtocolSchema(t *testing.T) {
+	t.Run("should describe every inbound message type", func(t *testing.T) {
+		schema := ProtocolSchema()
+
+		types := map[any]bool{}
+		for _, variant := range schema.Defs["InboundMessage"].OneOf {
+			types[variant.Properties["type"].Const] = true
+		}
+		for messageType := range MessagePayloads {
+			if !types[string(messageType)] {
+				t.Errorf("expected schema for message type %s", messageType)
+			}
+		}
+	})
+
+	t.Run("should generate definitions from struct fields", func(t *testing.T) {
+		schema := ProtocolSchema()
+
+		peer, ok := schema.Defs["Peer"]
+		if !ok {
+			t.Fatal("expected a Peer definition")
+		}
+		if peer.Properties["credits"].Type != "integer" {
+			t.Errorf("expected credits to be an integer, got %+v", peer.Properties["credits"])
+		}
+		cards := peer.Properties["cards"]
+		if !reflect.DeepEqual(cards.Type, []string{"array", "null"}) || cards.Items.Ref != "#/$defs/CardState" {
+			t.Errorf("expected cards to be a nullable array of CardState, got %+v", cards)
+		}
+	})
+
+	t.Run("should list enum values for string types", func(t *testing.T) {
+		schema := ProtocolSchema()
+
+		status := schema.Defs["ClientStateBroadcast"].Properties["status"]
+		expected := []string{"lobby", "in_progress", "complete"}
+		if !reflect.DeepEqual(status.Enum, expected) {
+			t.Errorf("expected status enum %v, got %v", expected, status.Enum)
+		}
+	})
+
+	t.Run("should describe real state broadcasts", func(t *testing.T) {
+		schema := ProtocolSchema()
+		i := NewGameInstance("0")
+		for _, id := range []string{"0", "1"} {
+			i.Game.AddPlayer(id, "Player "+id)
+			i.Ready[id] = true
+		}
+		spectator := &Client{Id: "s", Spectator: true}
+		i.Spectators["s"] = spectator
+
+		check := func(t *testing.T, client *Client) {
+			t.Helper()
+			bytes, err := json.Marshal(i.ToClientStateBroadcast(client))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value any
+			json.Unmarshal(bytes, &value)
+			for _, problem := range validateSchema(schema, schema.Defs["OutboundMessage"], value, "") {
+				t.Error(problem)
+			}
+		}
+
+		// Nobody holds cards in the lobby.
+		check(t, &Client{Id: "0"})
+		check(t, spectator)
+		i.start()
+		check(t, &Client{Id: "0"})
+		check(t, spectator)
+	})
+
+	t.Run("should serialise with the protocol version", func(t *testing.T) {
+		bytes, err := json.Marshal(ProtocolSchema())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var document map[string]any
+		json.Unmarshal(bytes, &document)
+		if document["x-protocol-version"] != float64(ProtocolVersion) {
+			t.Errorf("expected protocol version %d, got %v", ProtocolVersion, document["x-protocol-version"])
+		}
+	})
+}
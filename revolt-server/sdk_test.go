@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"revolt/client"
+	"revolt/game"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientSDK(t *testing.T) {
+	setup := func(t *testing.T) *httptest.Server {
+		startCountdown = 0
+		t.Cleanup(func() { startCountdown = DefaultStartCountdown })
+		return newTestServer(t)
+	}
+
+	join := func(t *testing.T, server *httptest.Server, game string, options client.Options) *client.Client {
+		t.Helper()
+		address, err := client.GameURL(server.URL, game)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := client.Dial(context.Background(), address, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	// Waits for a state matching `match`, failing the test if none arrives.
+	waitFor := func(t *testing.T, c *client.Client, match func(client.State) bool) client.State {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case state := <-c.States():
+				if match(state) {
+					return state
+				}
+			case <-timeout:
+				t.Fatal("timed out waiting for state")
+			}
+		}
+	}
+
+	t.Run("should play a turn", func(t *testing.T) {
+		server := setup(t)
+		ctx := context.Background()
+		created, err := client.CreateGame(ctx, server.URL, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		alice := join(t, server, created.Id, client.Options{Name: "Alice", Token: created.Token})
+		bob := join(t, server, created.InviteCode, client.Options{Name: "Bob"})
+		if alice.Id() != created.PlayerId {
+			t.Errorf("expected the owner to resume their session, got %s", alice.Id())
+		}
+
+		waitFor(t, alice, func(s client.State) bool { return len(s.Peers) == 1 })
+		for _, c := range []*client.Client{alice, bob} {
+			if err := c.Ready(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := alice.StartGame(ctx); err != nil {
+			t.Fatal(err)
+		}
+		state := waitFor(t, alice, func(s client.State) bool { return s.Status == string(InProgress) })
+
+		leader, other := alice, bob
+		if !state.Self.Leading {
+			leader, other = bob, alice
+		}
+		if err := other.CommitTurn(ctx); err == nil {
+			t.Error("expected committing with no pending action to be rejected")
+		}
+		if err := leader.AttemptAction(ctx, game.Action{Type: game.ForeignAid}); err != nil {
+			t.Fatal(err)
+		}
+		if err := other.CommitTurn(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := leader.EndTurn(ctx); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, other, func(s client.State) bool { return s.Self.Leading && s.TurnState == game.Default })
+	})
+
+	t.Run("should resume its session after the server drops it", func(t *testing.T) {
+		server := setup(t)
+		instance := NewGameInstance("")
+		im.StartInstance(instance)
+		c := join(t, server, instance.GameId, client.Options{Name: "Alice", ReconnectDelay: 10 * time.Millisecond})
+		id := c.Id()
+
+		instance.Do(func() { instance.Clients[id].Close() })
+		for event := range c.Events() {
+			if _, ok := event.(client.Reconnected); ok {
+				break
+			}
+		}
+		if c.Id() != id {
+			t.Errorf("expected to rejoin as %s, got %s", id, c.Id())
+		}
+		if err := c.Chat(context.Background(), "back again"); err != nil {
+			t.Errorf("expected to chat after reconnecting, got %v", err)
+		}
+	})
+
+	t.Run("should report why it couldn't join", func(t *testing.T) {
+		server := setup(t)
+		address, _ := client.GameURL(server.URL, "missing")
+		_, err := client.Dial(context.Background(), address, client.Options{})
+		if err == nil || !strings.Contains(err.Error(), "instance not found") {
+			t.Errorf("expected the game not to be found, got %v", err)
+		}
+	})
+}
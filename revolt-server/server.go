@@ -2,74 +2,170 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"revolt/game"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-const NameKey = "name"
-
-func remove(array []string, value string) (ret []string) {
-	for _, s := range array {
-		if s != value {
-			ret = append(ret, s)
-		}
-	}
-	return
-}
+const (
+	NameKey = "name"
+	// Set to `true` to watch a game rather than play.
+	SpectateKey = "spectate"
+)
 
-// Writes an error message to a websocket connection and closes it.
+// Closes a websocket connection the server refused, giving the reason in the close frame.
 func errorAndClose(conn *websocket.Conn, error string) {
-	conn.WriteMessage(websocket.CloseMessage, []byte(fmt.Sprintf(`{"error":"%s"}`, error)))
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, error))
 	conn.Close()
 }
 
-// Unmarshals a payload interface, allowing type assignment.
-func UnmarshalPayload(payload interface{}, v any) error {
-	bytes, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-	err = json.Unmarshal(bytes, v)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 // Struct for tracking instances
 type InstanceManager struct {
+	lock sync.RWMutex
 	// Maps IDs to game instance pointers (this allows modification)
 	Instances map[string]*GameInstance
+	// Maps invite codes to game IDs.
+	Codes map[string]string
+	// The cluster this node belongs to, or nil if it runs alone.
+	cluster *Cluster
 }
 
 // Global instance store.
 var im InstanceManager
 
+// Registers an instance, giving it an invite code that isn't used by any other instance, and
+// tells the rest of the cluster this node owns it.
 func (im *InstanceManager) RegisterInstance(instance *GameInstance) {
+	im.lock.Lock()
 	im.Instances[instance.GameId] = instance
+	for {
+		code := newInviteCode()
+		if _, taken := im.Codes[code]; !taken && !im.cluster.HasCode(code) {
+			instance.InviteCode = code
+			im.Codes[code] = instance.GameId
+			break
+		}
+	}
+	im.lock.Unlock()
+	metrics.Instances.Inc(string(instance.Status))
+	im.cluster.Announce(instance)
+}
+
+// Registers an instance and starts running it.
+func (im *InstanceManager) StartInstance(instance *GameInstance) {
+	im.RegisterInstance(instance)
+	go instance.Run()
+}
+
+// Looks up an instance by ID.
+func (im *InstanceManager) GetInstance(id string) (*GameInstance, bool) {
+	im.lock.RLock()
+	defer im.lock.RUnlock()
+	instance, ok := im.Instances[id]
+	return instance, ok
+}
+
+// Looks up an instance by either its ID or its invite code.
+func (im *InstanceManager) FindInstance(key string) (*GameInstance, bool) {
+	im.lock.RLock()
+	defer im.lock.RUnlock()
+	if instance, ok := im.Instances[key]; ok {
+		return instance, true
+	}
+	instance, ok := im.Instances[im.Codes[normaliseInviteCode(key)]]
+	return instance, ok
+}
+
+// Returns all registered instances.
+func (im *InstanceManager) ListInstances() []*GameInstance {
+	im.lock.RLock()
+	defer im.lock.RUnlock()
+	instances := make([]*GameInstance, 0, len(im.Instances))
+	for _, instance := range im.Instances {
+		instances = append(instances, instance)
+	}
+	return instances
+}
+
+// Stops an instance, disconnecting its clients, and removes it from the manager.
+func (im *InstanceManager) DeleteInstance(id string) bool {
+	im.lock.Lock()
+	instance, ok := im.Instances[id]
+	delete(im.Instances, id)
+	if ok {
+		delete(im.Codes, instance.InviteCode)
+	}
+	im.lock.Unlock()
+	if !ok {
+		return false
+	}
+	im.cluster.Withdraw(instance)
+
+	status := instance.Status
+	instance.Do(func() {
+		status = instance.Status
+	})
+	instance.Stop()
+	metrics.Instances.Dec(string(status))
+	return true
 }
 
 // WebSocket handler.
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	CheckOrigin:     func(r *http.Request) bool { return allowedOrigins.Allowed(r) },
+}
+
+// Upgrades a request to a websocket connection, limiting the size of frames it accepts.
+func upgrade(w http.ResponseWriter, r *http.Request, header http.Header) (*websocket.Conn, error) {
+	conn, err := upgrader.Upgrade(w, r, header)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadLimit(MaxFrameSize)
+	return conn, nil
 }
 
 // Primary websocket connection handler.
 func websocketHandler(w http.ResponseWriter, r *http.Request) {
+	serveWebsocket(&im, w, r)
+}
+
+// Connects a websocket to an instance in `instances`, or to the node owning the instance if it
+// is elsewhere in the cluster.
+func serveWebsocket(instances *InstanceManager, w http.ResponseWriter, r *http.Request) {
+	negotiation := negotiateProtocol(r)
+	header := http.Header{}
+	if negotiation.Subprotocol != "" {
+		header.Set("Sec-Websocket-Protocol", negotiation.Subprotocol)
+	}
+
 	// Create a new websocket connection.
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := upgrade(w, r, header)
 	if err != nil {
-		log.Println(err)
+		slog.Warn("websocket upgrade failed", "error", err)
 		return
 	}
 
-	// Check the path for an instance ID.
+	if negotiation.Err != nil {
+		slog.Warn("rejected client", "error", negotiation.Err)
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseProtocolError, negotiation.Err.Error()))
+		conn.Close()
+		return
+	}
+
+	// Check the path for an instance ID or invite code.
 	path := strings.Split(r.URL.Path, "/")[1:]
 	if len(path) != 1 {
 		errorAndClose(conn, "missing ID in URL")
@@ -77,212 +173,215 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	id := path[0]
-	instance, ok := im.Instances[id]
+	instance, ok := instances.FindInstance(id)
 	if !ok {
+		if node, instanceId, found := instances.cluster.Locate(id); found {
+			instances.cluster.proxy(conn, r, node, instanceId)
+			return
+		}
 		errorAndClose(conn, "instance not found")
 		return
 	}
 
-	// Extract a name from the URL if present
-	name := r.URL.Query().Get(NameKey)
-	client := NewClient(conn, name)
-
-	go client.HandleMessages()
-	client.Log("new client connected with name %s", client.Name)
-
-	// Holds a pointer to the client's current game instance.
-	var currentInstance *GameInstance
-
-	// Set the owner to the first client to connect.
-	if len(instance.Clients) == 0 {
-		instance.OwnerId = client.Id
+	client, err := newSessionClient(r, instance, websocketTransport{conn})
+	if err != nil {
+		errorAndClose(conn, err.Error())
+		return
+	}
+	if negotiation.Version == 0 {
+		client.Logger.Warn("client did not advertise a protocol version")
 	}
+	client.Send(helloMessage(client, negotiation))
 
-	currentInstance = instance
-	instance.Register <- &client
-	instance.SendState <- true
+	if !instance.Join(client) {
+		errorAndClose(conn, "instance not found")
+		return
+	}
+	go client.HandleMessages()
+	client.Logger.Info("client connected")
+	metrics.ConnectedClients.Inc("")
 
 	for {
-		_, bytes, err := conn.ReadMessage()
+		frameType, bytes, err := conn.ReadMessage()
 		if err != nil {
-			// If the client closed the connection, make sure to stop their handler.
-			ce, ok := err.(*websocket.CloseError)
-			if !ok {
-				log.Println(err)
-				return
-			}
-			switch ce.Code {
-			case
+			// Whatever the reason the connection ended, remove the client from its instance.
+			if websocket.IsCloseError(err,
 				websocket.CloseNormalClosure,
 				websocket.CloseGoingAway,
-				websocket.CloseNoStatusReceived:
-
-				client.Log("connection closed by client")
+				websocket.CloseNoStatusReceived,
+			) {
+				client.Logger.Info("connection closed by client")
+			} else {
+				client.Logger.Warn("connection error", "error", err)
+			}
 
-				if currentInstance != nil {
-					delete(currentInstance.Clients, client.Id)
-					delete(currentInstance.Game.Players, client.Id)
-					currentInstance.Game.Order = remove(currentInstance.Game.Order, client.Id)
-					currentInstance.SendState <- true
-				}
-				close(client.Send)
+			metrics.ConnectedClients.Dec("")
+			instance.Leave(client)
+			return
+		}
 
-				log.Println(err)
-				return
-			}
+		// Clients sending faster than they're allowed to are disconnected.
+		if !client.allowMessage() {
+			client.Logger.Warn("client exceeded message rate limit, disconnecting")
+			metrics.RateLimited.Inc("message")
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(CloseRateLimited, "rate limited"), time.Now().Add(WriteTimeout))
+			metrics.ConnectedClients.Dec("")
+			instance.Leave(client)
+			conn.Close()
+			return
 		}
 
 		// Parse the received message.
 		var message Message
-		err = json.Unmarshal(bytes, &message)
+		err = codecForFrame(frameType).Unmarshal(bytes, &message)
 		if err != nil {
-			client.Log("invalid message payload: %s", message.Payload)
+			client.Logger.Warn("invalid message", "error", err)
+			metrics.CommandsRejected.Inc(RejectMalformed)
 			continue
 		}
 
-		log.Printf("received message %+v", message)
-
-		switch message.Type {
-		case StartGameMessage:
-			if currentInstance == nil {
-				client.Log("can't start game (not connected to one)")
-				break
-			}
-			if client.Id != currentInstance.OwnerId {
-				client.Log("can't start game (owned by %s)", currentInstance.OwnerId)
-				break
-			}
-			currentInstance.Game.Deal()
-
-			// TODO remove, only for debug purposes.
-			for _, p := range currentInstance.Game.Players {
-				p.Credits += 5
-			}
-
-			currentInstance.Status = InProgress
-			currentInstance.SendState <- true
+		receiveMessage(instance, client, message)
+	}
+}
 
-		case AttemptActionMessage:
-			if currentInstance == nil {
-				client.Log("can't attempt action - not connected to game")
-				break
-			}
-			var payload AttemptActionPayload
-			err = UnmarshalPayload(message.Payload, &payload)
-			if err != nil {
-				client.Log("error reading message: %s", err)
-				break
-			}
-			err = currentInstance.Game.AttemptAction(payload.Action)
-			if err != nil {
-				client.Log("couldn't attempt action: %s", err)
-				break
+// Creates a client for a connection to `instance`, with the options given in the URL.
+// A client connecting with the token of an existing session resumes it, taking over the
+// session's player, and a client connecting with an account token joins as that account.
+// Every client is issued a fresh token. New players joining a game with a password must give
+// it with `?password=`.
+func newSessionClient(r *http.Request, instance *GameInstance, transport Transport) (*Client, error) {
+	query := r.URL.Query()
+	client := NewClient(transport, query.Get(NameKey), instance.Logger)
+	client.Spectator = query.Get(SpectateKey) == "true"
+	client.Address = clientIP(r)
+	preferences := Preferences{}
+	// Players who are already in the game don't need the password again.
+	admitted := false
+
+	if token := query.Get(AccountKey); token != "" {
+		if accounts == nil {
+			return nil, errors.New("accounts are disabled")
+		}
+		account, err := authenticateAccount(token)
+		if err != nil {
+			return nil, err
+		}
+		client.Name = account.Profile.DisplayName
+		client.Account = &PlayerAccount{Id: account.Id, Avatar: account.Profile.Avatar}
+		preferences = account.Profile.Preferences
+
+		// An account already playing takes over its existing player.
+		instance.Do(func() {
+			if id, ok := instance.playerForAccount(account.Id); ok {
+				client.Id = id
+				admitted = true
 			}
-			currentInstance.SendState <- true
+		})
+		client.Logger = instance.Logger.With("client", client.Id, "name", client.Name, "account", account.Id)
+	}
 
-		case AttemptBlockMessage:
-			if currentInstance == nil {
-				client.Log("can't attempt block - not connected to game")
-				break
-			}
-			var payload AttemptBlockPayload
-			err = UnmarshalPayload(message.Payload, &payload)
-			if err != nil {
-				client.Log("error reading message: %s", err)
-				break
-			}
-			// Set initiator - even if provided, we don't want to allow impersonating other players.
-			payload.Block.Initiator = client.Id
-			err = currentInstance.Game.AttemptBlock(payload.Block)
-			if err != nil {
-				client.Log("couldn't attempt block: %s", err)
-				break
-			}
-			currentInstance.SendState <- true
+	if token := query.Get(TokenKey); token != "" {
+		session, err := sessions.Verify(token)
+		if err != nil {
+			return nil, err
+		}
+		if session.GameId != instance.GameId {
+			return nil, ErrInvalidToken
+		}
+		client.Id = session.PlayerId
+		client.Logger = instance.Logger.With("client", client.Id, "name", client.Name)
+		admitted = true
+	}
+	if !admitted {
+		if err := instance.CheckPassword(query.Get(PasswordKey)); err != nil {
+			return nil, err
+		}
+	}
+	client.Token = sessions.Issue(instance.GameId, client.Id)
 
-		case ChallengeMessage:
-			if currentInstance == nil {
-				client.Log("can't attempt block - not connected to game")
-				break
-			}
-			var payload ChallengePayload
-			err = UnmarshalPayload(message.Payload, &payload)
-			if err != nil {
-				client.Log("error reading message: %s", err)
-				break
-			}
-			// Set initiator - even if provided, we don't want to allow impersonating other players.
-			payload.Challenge.Initiator = client.Id
-			err = currentInstance.Game.Challenge(payload.Challenge)
-			if err != nil {
-				client.Log("couldn't attempt challenge: %s", err)
-				break
-			}
-			currentInstance.SendState <- true
+	updates := UpdateMode(query.Get(UpdatesKey))
+	if updates == "" {
+		updates = preferences.UpdateMode
+	}
+	if updates == DeltaUpdates {
+		client.UpdateMode = DeltaUpdates
+	}
+	encoding := query.Get(EncodingKey)
+	if encoding == "" {
+		encoding = preferences.Encoding
+	}
+	client.Codec = codecByName(encoding)
+	return client, nil
+}
 
-		case ResolveDeathMessage:
-			if currentInstance == nil {
-				client.Log("can't resolve death - not connected to game")
-				break
-			}
-			var payload ResolveDeathPayload
-			err = UnmarshalPayload(message.Payload, &payload)
-			if err != nil {
-				client.Log("error reading message: %s", err)
-				break
-			}
-			err = currentInstance.Game.ResolveDeath(payload.Card)
-			if err != nil {
-				client.Log("couldn't resolve death action: %s", err)
-				break
-			}
-			currentInstance.SendState <- true
+// Passes a message received from a client, over any transport, to its instance.
+// Returns false if the instance has been stopped.
+func receiveMessage(instance *GameInstance, client *Client, message Message) bool {
+	client.Logger.Debug("received message", "type", message.Type, "payload", message.Payload)
+	metrics.MessagesReceived.Inc(messageLabel(message.Type))
+	return instance.Submit(client, message)
+}
 
-		case CommitTurnMessage:
-			if currentInstance == nil {
-				client.Log("can't commit turn - not connected to game")
-				break
-			}
-			err = currentInstance.Game.CommitTurn()
-			if err != nil {
-				client.Log("couldn't commit action: %s", err)
-				break
-			}
-			currentInstance.SendState <- true
+// The largest request body accepted by HTTP endpoints.
+const MaxBodySize = 64 * 1024
 
-		case EndTurnMessage:
-			if currentInstance == nil {
-				client.Log("can't end turn - not connected to game")
-				break
-			}
-			err = currentInstance.Game.EndTurn()
-			if err != nil {
-				client.Log("couldn't end turn: %s", err)
-				break
-			}
-			currentInstance.SendState <- true
-		}
+// Decodes a request body with `codec`.
+func decodeBody(r *http.Request, codec Codec, v any) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize))
+	if err != nil {
+		return err
 	}
+	return codec.Unmarshal(body, v)
 }
 
+// Creates a game. The creator is made its owner, and is sent a session token to join it with,
+// so the game is owned by whoever created it rather than whoever connects first. The request
+// body can optionally set a password needed to join.
 func createGameHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
 		return
 	}
 
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// Register the instance in the global context.
-	instance := NewGameInstance("")
-	im.RegisterInstance(&instance)
+	if !ipCreateLimits.Allow(clientIP(r)) {
+		metrics.RateLimited.Inc("create")
+		w.Header().Set("Retry-After", strconv.Itoa(int(CreateInterval.Seconds())))
+		http.Error(w, "creating games too quickly", http.StatusTooManyRequests)
+		return
+	}
 
-	// Run handler for client connections and message broadcasts.
-	go instance.Run()
+	// The body is optional, so an empty one creates a game with the default settings.
+	var request CreateGameRequest
+	if r.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize))
+		if err == nil && len(body) > 0 {
+			err = json.Unmarshal(body, &request)
+		}
+		if err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+	}
 
-	bytes, err := json.Marshal(ConnectionResponse{Id: instance.GameId})
+	// Register the instance in the global context, and run its handler for client connections
+	// and message broadcasts.
+	ownerId := game.Id()
+	instance := NewGameInstance(ownerId)
+	if err := instance.SetPassword(request.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	im.StartInstance(instance)
+
+	bytes, err := json.Marshal(ConnectionResponse{
+		Id:         instance.GameId,
+		InviteCode: instance.InviteCode,
+		PlayerId:   ownerId,
+		Token:      sessions.Issue(instance.GameId, ownerId),
+	})
 	if err != nil {
-		log.Println("failed to send id of new game")
+		instance.Logger.Error("failed to send id of new game", "error", err)
 		return
 	}
 	w.Write(bytes)
@@ -291,22 +390,97 @@ func createGameHandler(w http.ResponseWriter, r *http.Request) {
 func initInstanceManager() {
 	im = InstanceManager{
 		Instances: make(map[string]*GameInstance),
+		Codes:     make(map[string]string),
 	}
 }
 
-func RunServer() error {
-	host := "localhost:8080"
-	log.Printf("server up on %s", host)
+func RunServer(config Config) error {
+	host := config.Host
+	slog.Info("server up", "host", host)
 
 	initInstanceManager()
+	startCountdown = config.StartCountdown
+	chatFilter = NewWordFilter(strings.Split(config.ChatFilter, ","))
+	allowedOrigins = NewOriginPolicy(strings.Split(config.AllowedOrigins, ","))
+	if config.SessionKey != "" {
+		sessions = NewSessionManager([]byte(config.SessionKey))
+	} else {
+		slog.Warn("no session key configured, sessions won't survive a restart")
+	}
+	if config.Database != "" {
+		store, err := OpenAccountStore(config.Database)
+		if err != nil {
+			return fmt.Errorf("couldn't open database: %w", err)
+		}
+		defer store.Close()
+		accounts = store
+	}
 
-	mux := http.NewServeMux()
-	mux.Handle("/create", http.HandlerFunc(createGameHandler))
-	mux.Handle("/{id}", http.HandlerFunc(websocketHandler))
+	if config.Bus != "" {
+		bus, err := OpenBus(config.Bus)
+		if err != nil {
+			return fmt.Errorf("couldn't connect to bus: %w", err)
+		}
+		defer bus.Close()
+		nodeId := config.NodeId
+		if nodeId == "" {
+			nodeId = game.Id()
+		}
+		cluster := NewCluster(nodeId, bus, &im)
+		if err := cluster.Start(); err != nil {
+			return fmt.Errorf("couldn't join cluster: %w", err)
+		}
+		defer cluster.Close()
+	}
 
-	err := http.ListenAndServe(host, mux)
+	tlsConfig, err := loadTLSConfig(config)
 	if err != nil {
-		return err
+		return fmt.Errorf("couldn't load TLS certificate: %w", err)
+	}
+	server := &http.Server{Addr: host, Handler: NewServeMux(config), TLSConfig: tlsConfig}
+
+	go matchmaker.Run()
+
+	ready.Store(true)
+	if tlsConfig == nil {
+		return server.ListenAndServe()
+	}
+
+	slog.Info("serving over TLS")
+	server.Handler = withHSTS(server.Handler)
+	if config.RedirectHost != "" {
+		go func() {
+			err := http.ListenAndServe(config.RedirectHost, redirectToHTTPS(host))
+			slog.Error("stopped redirecting to HTTPS", "error", err)
+		}()
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// Builds the server's routes. HTTP endpoints are served under `/api` and websockets under
+// `/ws`, leaving every other path to the frontend, if one was built in.
+func NewServeMux(config Config) *http.ServeMux {
+	api := http.NewServeMux()
+	api.Handle("/create", http.HandlerFunc(createGameHandler))
+	api.Handle("/schema", http.HandlerFunc(schemaHandler))
+	api.Handle("/{id}/events", http.HandlerFunc(eventsHandler))
+	api.Handle("/{id}/commands", http.HandlerFunc(commandsHandler))
+	registerAdminRoutes(api, config.AdminToken)
+	registerAccountRoutes(api)
+
+	sockets := http.NewServeMux()
+	sockets.Handle("/matchmake", http.HandlerFunc(matchmakeHandler))
+	sockets.Handle("/{id}", http.HandlerFunc(websocketHandler))
+	registerTournamentRoutes(api, sockets)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", http.HandlerFunc(metricsHandler))
+	mux.Handle("/healthz", http.HandlerFunc(healthHandler))
+	mux.Handle("/readyz", http.HandlerFunc(readyHandler))
+	mux.Handle("/api/", withCORS(http.StripPrefix("/api", api)))
+	mux.Handle("/ws/", http.StripPrefix("/ws", sockets))
+	if frontendFiles != nil {
+		mux.Handle("/", frontendHandler(frontendFiles))
 	}
-	return nil
+	return mux
 }
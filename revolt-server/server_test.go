@@ -1,10 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"io"
+	"io/fs"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
+	"revolt/game"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestCreateGameHandler(t *testing.T) {
@@ -61,3 +67,93 @@ func TestCreateGameHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestServeMux(t *testing.T) {
+	setup := func(t *testing.T, files fs.FS) *httptest.Server {
+		frontendFiles = files
+		t.Cleanup(func() { frontendFiles = nil })
+		return newTestServer(t)
+	}
+
+	get := func(t *testing.T, url string) (*http.Response, string) {
+		t.Helper()
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res, string(body)
+	}
+
+	frontend := fstest.MapFS{
+		"index.html":       {Data: []byte("<html>app</html>")},
+		"assets/index.js":  {Data: []byte("console.log('app')")},
+		"favicon.svg":      {Data: []byte("<svg></svg>")},
+		"assets/index.css": {Data: []byte("body {}")},
+	}
+
+	t.Run("should serve the API and websockets under their prefixes", func(t *testing.T) {
+		server := setup(t, nil)
+
+		res, err := http.Post(server.URL+"/api/create", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var created ConnectionResponse
+		json.NewDecoder(res.Body).Decode(&created)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", res.StatusCode)
+		}
+
+		dialGame(t, server, created.Id, "")
+
+		if res, _ := get(t, server.URL+"/healthz"); res.StatusCode != http.StatusOK {
+			t.Errorf("expected health checks to stay at the root, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("should serve the frontend's files at the root", func(t *testing.T) {
+		server := setup(t, frontend)
+
+		if _, body := get(t, server.URL+"/"); body != "<html>app</html>" {
+			t.Errorf("expected the index page, got %q", body)
+		}
+		res, body := get(t, server.URL+"/assets/index.js")
+		if body != "console.log('app')" {
+			t.Errorf("expected the script, got %q", body)
+		}
+		if !strings.Contains(res.Header.Get("Cache-Control"), "immutable") {
+			t.Errorf("expected bundled assets to be cached, got %q", res.Header.Get("Cache-Control"))
+		}
+		if res, _ := get(t, server.URL+"/favicon.svg"); res.Header.Get("Cache-Control") != "" {
+			t.Error("expected unbundled files not to be cached indefinitely")
+		}
+	})
+
+	t.Run("should send the frontend's entry point for paths it routes", func(t *testing.T) {
+		server := setup(t, frontend)
+
+		res, body := get(t, server.URL+"/"+game.Id())
+		if res.StatusCode != http.StatusOK || body != "<html>app</html>" {
+			t.Errorf("expected the index page, got %d %q", res.StatusCode, body)
+		}
+	})
+
+	t.Run("should not send the frontend for unknown API paths", func(t *testing.T) {
+		server := setup(t, frontend)
+
+		if res, _ := get(t, server.URL+"/api/unknown"); res.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("should leave the root unrouted without a frontend", func(t *testing.T) {
+		server := setup(t, nil)
+
+		if res, _ := get(t, server.URL+"/"); res.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", res.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// The query parameter clients use to resume a session when reconnecting.
+const TokenKey = "token"
+
+// How long a session token remains valid after it is issued.
+const SessionLifetime = 24 * time.Hour
+
+// How long an account token remains valid after logging in.
+const AccountSessionLifetime = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidToken = errors.New("invalid session token")
+	ErrExpiredToken = errors.New("session token has expired")
+)
+
+// The claims held in a session token. Game tokens tie a player to a game, while account
+// tokens identify a logged in account.
+type Session struct {
+	GameId    string `json:"game,omitempty"`
+	PlayerId  string `json:"player,omitempty"`
+	AccountId string `json:"account,omitempty"`
+	Expires   int64  `json:"exp"`
+}
+
+// Issues and verifies session tokens, signed with HMAC-SHA256.
+// A token is the base64url encoded session claims and signature, separated by a dot.
+type SessionManager struct {
+	key []byte
+	now func() time.Time
+}
+
+// Global session store. Uses a random key until one is configured, so tokens don't survive
+// a restart unless `REVOLT_SESSION_KEY` is set.
+var sessions = NewSessionManager(randomKey())
+
+func NewSessionManager(key []byte) *SessionManager {
+	return &SessionManager{key: key, now: time.Now}
+}
+
+// Generates a random signing key.
+func randomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// Issues a token identifying `playerId` as a player in `gameId`.
+func (s *SessionManager) Issue(gameId string, playerId string) string {
+	return s.issue(Session{
+		GameId:   gameId,
+		PlayerId: playerId,
+		Expires:  s.now().Add(SessionLifetime).Unix(),
+	})
+}
+
+// Issues a token identifying a logged in account.
+func (s *SessionManager) IssueAccount(accountId string) string {
+	return s.issue(Session{
+		AccountId: accountId,
+		Expires:   s.now().Add(AccountSessionLifetime).Unix(),
+	})
+}
+
+func (s *SessionManager) issue(session Session) string {
+	claims, _ := json.Marshal(session)
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.sign(payload))
+}
+
+// Checks a token's signature and expiry, returning the session it holds.
+func (s *SessionManager) Verify(token string) (Session, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return Session{}, ErrInvalidToken
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decoded, s.sign(payload)) {
+		return Session{}, ErrInvalidToken
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Session{}, ErrInvalidToken
+	}
+	var session Session
+	err = json.Unmarshal(claims, &session)
+	isGame := session.GameId != "" && session.PlayerId != ""
+	if err != nil || (!isGame && session.AccountId == "") {
+		return Session{}, ErrInvalidToken
+	}
+	if s.now().Unix() >= session.Expires {
+		return Session{}, ErrExpiredToken
+	}
+	return session, nil
+}
+
+func (s *SessionManager) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSessionManager(t *testing.T) {
+	t.Run("should verify tokens it issued", func(t *testing.T) {
+		s := NewSessionManager([]byte("key"))
+
+		session, err := s.Verify(s.Issue("game", "player"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session.GameId != "game" || session.PlayerId != "player" {
+			t.Errorf("expected session for player in game, got %+v", session)
+		}
+	})
+
+	t.Run("should reject tokens signed with another key", func(t *testing.T) {
+		token := NewSessionManager([]byte("other")).Issue("game", "player")
+
+		_, err := NewSessionManager([]byte("key")).Verify(token)
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected invalid token, got %v", err)
+		}
+	})
+
+	t.Run("should reject tampered tokens", func(t *testing.T) {
+		s := NewSessionManager([]byte("key"))
+		_, signature, _ := strings.Cut(s.Issue("game", "player"), ".")
+		forged := NewSessionManager([]byte("key")).Issue("game", "other")
+		claims, _, _ := strings.Cut(forged, ".")
+
+		for _, token := range []string{"", "garbage", claims + "." + signature} {
+			if _, err := s.Verify(token); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("expected %q to be invalid, got %v", token, err)
+			}
+		}
+	})
+
+	t.Run("should reject expired tokens", func(t *testing.T) {
+		s := NewSessionManager([]byte("key"))
+		token := s.Issue("game", "player")
+
+		s.now = func() time.Time { return time.Now().Add(SessionLifetime) }
+		if _, err := s.Verify(token); !errors.Is(err, ErrExpiredToken) {
+			t.Errorf("expected expired token, got %v", err)
+		}
+	})
+}
+
+func TestResumeSession(t *testing.T) {
+	// Connects to an instance, returning the connection and its hello message.
+	connect := func(t *testing.T, server *httptest.Server, instance *GameInstance, query string) (*websocket.Conn, HelloPayload, error) {
+		t.Helper()
+		conn := dialGame(t, server, instance.GameId, query)
+		var hello struct {
+			Type    ServerMessageType `json:"type"`
+			Payload HelloPayload      `json:"payload"`
+		}
+		_, bytes, err := conn.ReadMessage()
+		if err != nil {
+			return conn, hello.Payload, err
+		}
+		json.Unmarshal(bytes, &hello)
+		return conn, hello.Payload, nil
+	}
+
+	t.Run("should issue full length player IDs", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+
+		_, hello, err := connect(t, server, instance, "?name=One")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hello.ClientId) != 36 {
+			t.Errorf("expected a full length ID, got %s", hello.ClientId)
+		}
+	})
+
+	t.Run("should keep a player's seat in a running game and resume it with their token", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		conn, hello, _ := connect(t, server, instance, "?name=One")
+		connect(t, server, instance, "?name=Two")
+		waitForClients(t, instance, 2)
+
+		instance.Do(func() { instance.SetStatus(InProgress) })
+		conn.Close()
+		waitForClients(t, instance, 1)
+
+		instance.Do(func() {
+			if _, ok := instance.Game.Players[hello.ClientId]; !ok {
+				t.Error("expected disconnected player to keep their seat")
+			}
+		})
+
+		_, resumed, err := connect(t, server, instance, "?token="+hello.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumed.ClientId != hello.ClientId {
+			t.Errorf("expected to resume as %s, got %s", hello.ClientId, resumed.ClientId)
+		}
+		waitForClients(t, instance, 2)
+		instance.Do(func() {
+			if len(instance.Game.Players) != 2 {
+				t.Errorf("expected 2 players, got %d", len(instance.Game.Players))
+			}
+			if instance.Clients[hello.ClientId].Name != "One" {
+				t.Errorf("expected resumed client to keep its name")
+			}
+		})
+	})
+
+	t.Run("should remove players who leave the lobby", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		conn, _, _ := connect(t, server, instance, "?name=One")
+		waitForClients(t, instance, 1)
+
+		conn.Close()
+		waitForClients(t, instance, 0)
+		instance.Do(func() {
+			if len(instance.Game.Players) != 0 {
+				t.Errorf("expected no players, got %d", len(instance.Game.Players))
+			}
+		})
+	})
+
+	t.Run("should refuse tokens for other games", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+
+		token := sessions.Issue("other", "player")
+		conn, _, err := connect(t, server, instance, "?token="+token)
+		if err == nil {
+			t.Error("expected connection to be closed")
+		}
+		conn.Close()
+
+		if _, ok := instance.Authenticate(token); ok {
+			t.Error("expected token for another game to be refused")
+		}
+	})
+}
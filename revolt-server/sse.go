@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// How often an idle event stream is sent a keepalive comment.
+const KeepAliveInterval = 25 * time.Second
+
+// Streams messages to a new client as Server-Sent Events, for networks where websockets are
+// unavailable. The first event is the `hello` message holding the client's token, which must
+// be sent with commands posted to `/{id}/commands`.
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	instance, ok := im.FindInstance(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "instance not found", http.StatusNotFound)
+		return
+	}
+
+	transport := newSSETransport(w)
+	client, err := newSessionClient(r, instance, transport)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	// Events are text, so binary encodings aren't available.
+	client.Codec = jsonCodec
+	client.Send(helloMessage(client, ProtocolNegotiation{Version: ProtocolVersion}))
+
+	if !instance.Join(client) {
+		http.Error(w, "instance not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	client.Logger.Info("client connected", "transport", "sse")
+	metrics.ConnectedClients.Inc("")
+
+	// Stop writing when the client goes away, and keep the stream alive while it's idle.
+	go func() {
+		ticker := time.NewTicker(KeepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				client.Logger.Info("event stream closed by client")
+				client.Close()
+				return
+			case <-ticker.C:
+				if err := transport.KeepAlive(); err != nil {
+					client.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	// Writing happens on the handler goroutine, as the response ends when the handler returns.
+	client.HandleMessages()
+	metrics.ConnectedClients.Dec("")
+	instance.Leave(client)
+}
+
+// Accepts a single message for a client, authenticated by the client's token as a bearer token.
+// The message is applied exactly as if it had arrived over the client's connection, and any
+// acknowledgement is sent over the client's event stream.
+func commandsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+
+	instance, ok := im.FindInstance(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "instance not found", http.StatusNotFound)
+		return
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	client, ok := instance.Authenticate(token)
+	if !ok {
+		http.Error(w, "unauthorised", http.StatusUnauthorized)
+		return
+	}
+
+	if !client.allowMessage() {
+		client.Logger.Warn("client exceeded message rate limit, disconnecting")
+		metrics.RateLimited.Inc("message")
+		client.Close()
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+
+	codec := jsonCodec
+	if r.Header.Get("Content-Type") == "application/msgpack" {
+		codec = msgpackCodec
+	}
+	var message Message
+	err := decodeBody(r, codec, &message)
+	if err != nil {
+		client.Logger.Warn("invalid message", "error", err)
+		metrics.CommandsRejected.Inc(RejectMalformed)
+		http.Error(w, "invalid message", http.StatusBadRequest)
+		return
+	}
+
+	if !receiveMessage(instance, client, message) {
+		http.Error(w, "instance not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
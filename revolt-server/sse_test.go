@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventStream(t *testing.T) {
+	// Reads the next event from a stream, decoding its type and payload.
+	readEvent := func(t *testing.T, events *bufio.Reader, payload any) ServerMessageType {
+		t.Helper()
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				continue
+			}
+			var message struct {
+				Type    ServerMessageType `json:"type"`
+				Payload json.RawMessage   `json:"payload"`
+			}
+			json.Unmarshal([]byte(data), &message)
+			if payload != nil {
+				json.Unmarshal(message.Payload, payload)
+			}
+			return message.Type
+		}
+	}
+
+	connect := func(t *testing.T, server *httptest.Server, instance *GameInstance) (*bufio.Reader, HelloPayload) {
+		t.Helper()
+		res, err := http.Get(server.URL + "/api/" + instance.GameId + "/events?name=Test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+			t.Fatalf("expected an event stream, got %s", contentType)
+		}
+
+		events := bufio.NewReader(res.Body)
+		var hello HelloPayload
+		if messageType := readEvent(t, events, &hello); messageType != HelloMessage {
+			t.Fatalf("expected hello first, got %s", messageType)
+		}
+		return events, hello
+	}
+
+	post := func(t *testing.T, server *httptest.Server, key string, token string, body string) int {
+		t.Helper()
+		req, _ := http.NewRequest("POST", server.URL+"/api/"+key+"/commands", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	t.Run("should stream state broadcasts", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		events, hello := connect(t, server, instance)
+		if hello.Token == "" {
+			t.Error("expected hello to include a token")
+		}
+
+		if messageType := readEvent(t, events, nil); messageType != StateMessage {
+			t.Fatalf("expected a state broadcast, got %s", messageType)
+		}
+	})
+
+	t.Run("should apply commands authenticated with the client's token", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		events, hello := connect(t, server, instance)
+
+		status := post(t, server, instance.GameId, hello.Token, `{"type":"ready","requestId":"a"}`)
+		if status != http.StatusAccepted {
+			t.Fatalf("expected status 202, got %d", status)
+		}
+
+		for {
+			var ack AckPayload
+			if readEvent(t, events, &ack) != AckMessage {
+				continue
+			}
+			if ack.RequestId != "a" || ack.Status != AckApplied {
+				t.Errorf("expected command to be applied, got %+v", ack)
+			}
+			break
+		}
+	})
+
+	t.Run("should accept commands addressed by invite code", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		_, hello := connect(t, server, instance)
+
+		status := post(t, server, instance.InviteCode, hello.Token, `{"type":"ready"}`)
+		if status != http.StatusAccepted {
+			t.Errorf("expected status 202, got %d", status)
+		}
+	})
+
+	t.Run("should reject commands without a valid token", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		connect(t, server, instance)
+
+		if status := post(t, server, instance.GameId, "wrong", `{"type":"start_game"}`); status != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", status)
+		}
+		if status := post(t, server, instance.GameId, "", `{"type":"start_game"}`); status != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d", status)
+		}
+	})
+
+	t.Run("should not include tokens in state broadcasts", func(t *testing.T) {
+		server, instance := newTestServer(t), startTestInstance()
+		_, hello := connect(t, server, instance)
+		other, _ := connect(t, server, instance)
+
+		for {
+			line, _ := other.ReadString('\n')
+			if strings.Contains(line, hello.Token) {
+				t.Fatal("expected token not to be sent to other clients")
+			}
+			if strings.Contains(line, `"type":"state"`) && strings.Contains(line, hello.ClientId) {
+				break
+			}
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// How long browsers should only connect over HTTPS once they've been sent a HSTS header.
+	HSTSMaxAge = 365 * 24 * time.Hour
+	// How long self-signed certificates are valid for.
+	SelfSignedValidity = 30 * 24 * time.Hour
+)
+
+// Returns the TLS configuration the server should use, or nil if it should serve plain HTTP.
+// A certificate and key from files take precedence over a self-signed certificate.
+func loadTLSConfig(config Config) (*tls.Config, error) {
+	var certificate tls.Certificate
+	var err error
+	switch {
+	case config.TLSCert != "" || config.TLSKey != "":
+		certificate, err = tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	case config.TLSSelfSigned:
+		slog.Warn("using a self-signed certificate, which browsers won't trust")
+		host, _, _ := net.SplitHostPort(config.Host)
+		certificate, err = selfSignedCertificate(host)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// Generates a certificate for local development, signed by its own key. It is valid for
+// `localhost`, the loopback addresses and any other `hosts`.
+func selfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Revolt development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(SelfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" && host != "localhost" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// Tells browsers to only use HTTPS for responses sent over TLS.
+func withHSTS(next http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(HSTSMaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Redirects plain HTTP requests to the same URL over HTTPS, on the port of `tlsHost`.
+func redirectToHTTPS(tlsHost string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsHost)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
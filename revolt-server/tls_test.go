@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTLS(t *testing.T) {
+	t.Run("should generate certificates for local hosts", func(t *testing.T) {
+		certificate, err := selfSignedCertificate("revolt.test", "10.0.0.5")
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, host := range []string{"localhost", "127.0.0.1", "::1", "revolt.test", "10.0.0.5"} {
+			if err := leaf.VerifyHostname(host); err != nil {
+				t.Errorf("expected certificate to be valid for %s: %v", host, err)
+			}
+		}
+	})
+
+	t.Run("should only load a TLS configuration when asked to", func(t *testing.T) {
+		config, err := loadTLSConfig(Config{Host: "localhost:8080"})
+		if config != nil || err != nil {
+			t.Errorf("expected plain HTTP, got %v %v", config, err)
+		}
+
+		config, err = loadTLSConfig(Config{Host: "localhost:8080", TLSSelfSigned: true})
+		if err != nil || len(config.Certificates) != 1 {
+			t.Errorf("expected a self-signed certificate, got %v", err)
+		}
+
+		_, err = loadTLSConfig(Config{TLSCert: "missing.pem", TLSKey: "missing.key", TLSSelfSigned: true})
+		if err == nil {
+			t.Error("expected missing certificate files to be an error")
+		}
+	})
+
+	t.Run("should send HSTS headers over TLS only", func(t *testing.T) {
+		certificate, _ := selfSignedCertificate()
+		handler := withHSTS(http.HandlerFunc(healthHandler))
+
+		secure := httptest.NewUnstartedServer(handler)
+		secure.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+		secure.StartTLS()
+		defer secure.Close()
+		pool := x509.NewCertPool()
+		leaf, _ := x509.ParseCertificate(certificate.Certificate[0])
+		pool.AddCert(leaf)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+		res, err := client.Get(secure.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.Header.Get("Strict-Transport-Security") != "max-age=31536000" {
+			t.Errorf("expected a HSTS header, got %q", res.Header.Get("Strict-Transport-Security"))
+		}
+
+		plain := httptest.NewServer(handler)
+		defer plain.Close()
+		res, err = http.Get(plain.URL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.Header.Get("Strict-Transport-Security") != "" {
+			t.Error("expected no HSTS header over plain HTTP")
+		}
+	})
+
+	t.Run("should redirect plain HTTP to HTTPS", func(t *testing.T) {
+		cases := map[string]string{
+			"localhost:8443": "https://revolt.example:8443/ws/1234?name=One",
+			":443":           "https://revolt.example/ws/1234?name=One",
+		}
+		for tlsHost, expected := range cases {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://revolt.example:8080/ws/1234?name=One", nil)
+			redirectToHTTPS(tlsHost).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusPermanentRedirect {
+				t.Errorf("expected status 308, got %d", rr.Code)
+			}
+			if location := rr.Header().Get("Location"); location != expected {
+				t.Errorf("expected redirect to %s, got %s", expected, location)
+			}
+		}
+	})
+}
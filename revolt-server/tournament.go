@@ -0,0 +1,683 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"revolt/game"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long seated players have to join their table and ready up before forfeiting.
+const NoShowTimeout = 5 * time.Minute
+
+var (
+	ErrTournamentStarted = errors.New("tournament has already started")
+	ErrAlreadyRegistered = errors.New("already registered")
+	ErrTooFewPlayers     = errors.New("at least two players must register")
+	ErrNotSeated         = errors.New("not seated at a table in the current round")
+)
+
+// The status of a tournament.
+type TournamentStatus string
+
+const (
+	TournamentRegistering TournamentStatus = "registering"
+	TournamentRunning     TournamentStatus = "running"
+	TournamentComplete    TournamentStatus = "complete"
+)
+
+// The status of a player within a tournament.
+type EntrantStatus string
+
+const (
+	EntrantActive     EntrantStatus = "active"
+	EntrantEliminated EntrantStatus = "eliminated"
+	EntrantForfeited  EntrantStatus = "forfeited"
+	EntrantChampion   EntrantStatus = "champion"
+)
+
+// A registered player in a tournament.
+type Entrant struct {
+	AccountId string `json:"accountId"`
+	Name      string `json:"name"`
+	// The player's rating when they registered. Higher seeds receive byes first.
+	Seed   float64       `json:"seed"`
+	Status EntrantStatus `json:"status"`
+	// The last round the player played in.
+	Round int `json:"round"`
+}
+
+// A game played as part of a tournament round.
+type TournamentTable struct {
+	GameId string `json:"gameId"`
+	// Account IDs of the players seated at the table.
+	Players []string `json:"players"`
+	// Account IDs in finishing order once the table is complete, with forfeits last.
+	Placings []string `json:"placings,omitempty"`
+	// Account IDs of players who didn't join and ready up in time, or left before it finished.
+	Forfeits []string `json:"forfeits,omitempty"`
+	Complete bool     `json:"complete"`
+
+	// Maps account IDs to the player IDs of their reserved seats. Never modified.
+	seats    map[string]string
+	instance *GameInstance
+}
+
+type TournamentRound struct {
+	Number int                `json:"number"`
+	Tables []*TournamentTable `json:"tables"`
+	// Account IDs of players who advance without playing this round.
+	Byes []string `json:"byes"`
+}
+
+// A knockout tournament played over rounds of simultaneous tables. The top players from each
+// table advance to the next round, until a single player remains.
+type Tournament struct {
+	Id          string
+	Name        string
+	OrganiserId string
+	TableSize   int
+	// The number of players from each table who advance. Only the winner of a final advances.
+	Advance       int
+	NoShowTimeout time.Duration
+
+	lock     sync.Mutex
+	status   TournamentStatus
+	entrants map[string]*Entrant
+	rounds   []*TournamentRound
+	champion string
+	// Clients following the tournament feed.
+	feed   map[*Client]bool
+	logger *slog.Logger
+}
+
+// Creates a tournament open for registration.
+func NewTournament(name string, organiserId string, tableSize int, advance int) *Tournament {
+	id := game.Id()
+	return &Tournament{
+		Id:            id,
+		Name:          name,
+		OrganiserId:   organiserId,
+		TableSize:     tableSize,
+		Advance:       advance,
+		NoShowTimeout: NoShowTimeout,
+		status:        TournamentRegistering,
+		entrants:      map[string]*Entrant{},
+		feed:          map[*Client]bool{},
+		logger:        slog.Default().With("tournament", id),
+	}
+}
+
+// Registers an account to play.
+func (t *Tournament) Register(account Account, seed float64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.status != TournamentRegistering {
+		return ErrTournamentStarted
+	}
+	if _, ok := t.entrants[account.Id]; ok {
+		return ErrAlreadyRegistered
+	}
+	t.entrants[account.Id] = &Entrant{
+		AccountId: account.Id,
+		Name:      account.Profile.DisplayName,
+		Seed:      seed,
+		Status:    EntrantActive,
+	}
+	t.publish()
+	return nil
+}
+
+// Closes registration and seats the first round.
+func (t *Tournament) Start() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.status != TournamentRegistering {
+		return ErrTournamentStarted
+	}
+	if len(t.entrants) < 2 {
+		return ErrTooFewPlayers
+	}
+	t.status = TournamentRunning
+	t.logger.Info("tournament started", "players", len(t.entrants))
+
+	players := []string{}
+	for id := range t.entrants {
+		players = append(players, id)
+	}
+	t.startRound(players)
+	t.publish()
+	return nil
+}
+
+// Returns the player IDs given `players`, ordered from the highest seed.
+// Must be called with the lock held.
+func (t *Tournament) bySeed(players []string) []string {
+	sorted := slices.Clone(players)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := t.entrants[sorted[i]], t.entrants[sorted[j]]
+		if a.Seed != b.Seed {
+			return a.Seed > b.Seed
+		}
+		return a.AccountId < b.AccountId
+	})
+	return sorted
+}
+
+// Splits players into byes and tables for a round. Players are spread evenly over as few
+// tables as possible, and when that would leave someone alone at a table the highest seeds
+// get byes instead. Players are dealt across tables in seed order, so seeds are spread out.
+func planRound(players []string, tableSize int) (byes []string, tables [][]string) {
+	count := (len(players) + tableSize - 1) / tableSize
+	byeCount := max(0, 2*count-len(players))
+	byes = players[:byeCount]
+	count -= byeCount
+
+	tables = make([][]string, count)
+	for i, player := range players[byeCount:] {
+		tables[i%count] = append(tables[i%count], player)
+	}
+	return byes, tables
+}
+
+// Seats a round for the given players, or crowns a champion if only one remains.
+// Must be called with the lock held.
+func (t *Tournament) startRound(players []string) {
+	players = t.bySeed(players)
+	if len(players) == 1 {
+		t.status = TournamentComplete
+		t.champion = players[0]
+		t.entrants[players[0]].Status = EntrantChampion
+		t.logger.Info("tournament complete", "champion", players[0])
+		return
+	}
+
+	byes, tables := planRound(players, t.TableSize)
+	round := &TournamentRound{Number: len(t.rounds) + 1, Byes: byes}
+	t.rounds = append(t.rounds, round)
+	for _, id := range players {
+		t.entrants[id].Round = round.Number
+	}
+
+	for _, seated := range tables {
+		instance := NewGameInstance("")
+		instance.Seats = map[string]Seat{}
+		table := &TournamentTable{
+			GameId:   instance.GameId,
+			Players:  seated,
+			seats:    map[string]string{},
+			instance: instance,
+		}
+		for _, accountId := range seated {
+			entrant := t.entrants[accountId]
+			playerId := game.Id()
+			table.seats[accountId] = playerId
+			instance.Seats[playerId] = Seat{Name: entrant.Name, Account: &PlayerAccount{Id: accountId}}
+		}
+		instance.OnComplete = func(instance *GameInstance) {
+			t.finishTable(table, tablePlacings(instance))
+		}
+		round.Tables = append(round.Tables, table)
+
+		im.StartInstance(instance)
+		time.AfterFunc(t.NoShowTimeout, func() { t.checkNoShows(table) })
+	}
+	t.logger.Info("round started", "round", round.Number, "tables", len(round.Tables), "byes", len(byes))
+}
+
+// Returns the account IDs of a table's players in the order they finished.
+// Must be called on the instance goroutine.
+func tablePlacings(instance *GameInstance) []string {
+	placings := []string{}
+	for _, playerId := range instance.Game.Placings() {
+		if seat, ok := instance.Seats[playerId]; ok && seat.Account != nil {
+			placings = append(placings, seat.Account.Id)
+		}
+	}
+	return placings
+}
+
+// Forfeits players who haven't joined their table and readied in time, or who have since
+// disconnected, and releases their seats. The table's game starts with the players left, or
+// if fewer than two are left it is closed, advancing whoever is.
+func (t *Tournament) checkNoShows(table *TournamentTable) {
+	present := []string{}
+	table.instance.Do(func() {
+		kept := table.instance.releaseNoShows()
+		for accountId, playerId := range table.seats {
+			if slices.Contains(kept, playerId) {
+				present = append(present, accountId)
+			}
+		}
+	})
+
+	t.lock.Lock()
+	if table.Complete {
+		t.lock.Unlock()
+		return
+	}
+	for _, accountId := range table.Players {
+		if !slices.Contains(present, accountId) && !slices.Contains(table.Forfeits, accountId) {
+			table.Forfeits = append(table.Forfeits, accountId)
+		}
+	}
+	t.publish()
+	t.lock.Unlock()
+
+	if len(present) < 2 {
+		t.logger.Info("closing table with too few players", "game", table.GameId, "players", len(present))
+		im.DeleteInstance(table.GameId)
+		t.lock.Lock()
+		t.recordTable(table, t.bySeed(present))
+		t.lock.Unlock()
+	}
+}
+
+// Records the result of a table's game.
+func (t *Tournament) finishTable(table *TournamentTable, placings []string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.recordTable(table, placings)
+}
+
+// Records a table's result, starting the next round once every table is complete.
+// Must be called with the lock held.
+func (t *Tournament) recordTable(table *TournamentTable, placings []string) {
+	if table.Complete {
+		return
+	}
+	round := t.rounds[len(t.rounds)-1]
+	// Someone must be knocked out at every table, or rounds of small tables would repeat
+	// forever.
+	advance := min(t.Advance, len(table.Players)-1)
+	if len(round.Tables) == 1 {
+		advance = 1
+	}
+
+	// Anyone who didn't finish forfeits, even if they joined.
+	for _, accountId := range table.Players {
+		if !slices.Contains(placings, accountId) && !slices.Contains(table.Forfeits, accountId) {
+			table.Forfeits = append(table.Forfeits, accountId)
+		}
+	}
+	table.Placings = append(slices.Clone(placings), table.Forfeits...)
+	table.Complete = true
+
+	for i, accountId := range table.Placings {
+		entrant := t.entrants[accountId]
+		switch {
+		case slices.Contains(table.Forfeits, accountId):
+			entrant.Status = EntrantForfeited
+		case i >= advance:
+			entrant.Status = EntrantEliminated
+		}
+	}
+	t.logger.Info("table complete", "round", round.Number, "game", table.GameId, "placings", table.Placings)
+
+	for _, other := range round.Tables {
+		if !other.Complete {
+			t.publish()
+			return
+		}
+	}
+
+	advancing := slices.Clone(round.Byes)
+	for _, other := range round.Tables {
+		for _, accountId := range other.Placings {
+			if t.entrants[accountId].Status == EntrantActive {
+				advancing = append(advancing, accountId)
+			}
+		}
+	}
+	if len(advancing) == 0 {
+		t.status = TournamentComplete
+		t.logger.Info("tournament complete without a champion")
+	} else {
+		t.startRound(advancing)
+	}
+	t.publish()
+}
+
+// Returns the reserved seat for an account at its table in the current round.
+func (t *Tournament) Seat(accountId string) (MatchFoundPayload, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.status != TournamentRunning {
+		return MatchFoundPayload{}, ErrNotSeated
+	}
+	for _, table := range t.rounds[len(t.rounds)-1].Tables {
+		if playerId, ok := table.seats[accountId]; ok && !table.Complete {
+			return MatchFoundPayload{
+				GameId:   table.GameId,
+				PlayerId: playerId,
+				Token:    sessions.Issue(table.GameId, playerId),
+			}, nil
+		}
+	}
+	return MatchFoundPayload{}, ErrNotSeated
+}
+
+// A tournament's bracket and standings.
+type TournamentSnapshot struct {
+	Id        string             `json:"id"`
+	Name      string             `json:"name"`
+	Status    TournamentStatus   `json:"status"`
+	TableSize int                `json:"tableSize"`
+	Advance   int                `json:"advance"`
+	Champion  string             `json:"champion,omitempty"`
+	Rounds    []*TournamentRound `json:"rounds"`
+	// Entrants ordered by how far they got, then by seed.
+	Standings []Entrant `json:"standings"`
+}
+
+// Encodes the tournament's current state as a feed message.
+// Must be called with the lock held, as the snapshot shares the tournament's rounds.
+func (t *Tournament) snapshot() ([]byte, error) {
+	standings := []Entrant{}
+	for _, entrant := range t.entrants {
+		standings = append(standings, *entrant)
+	}
+	rank := func(e Entrant) int {
+		switch e.Status {
+		case EntrantChampion:
+			return 2
+		case EntrantActive:
+			return 1
+		}
+		return 0
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		a, b := standings[i], standings[j]
+		if rank(a) != rank(b) {
+			return rank(a) > rank(b)
+		}
+		if a.Round != b.Round {
+			return a.Round > b.Round
+		}
+		if a.Seed != b.Seed {
+			return a.Seed > b.Seed
+		}
+		return a.AccountId < b.AccountId
+	})
+
+	rounds := t.rounds
+	if rounds == nil {
+		rounds = []*TournamentRound{}
+	}
+	message := ServerMessage{
+		Type: TournamentMessage,
+		Payload: TournamentSnapshot{
+			Id:        t.Id,
+			Name:      t.Name,
+			Status:    t.status,
+			TableSize: t.TableSize,
+			Advance:   t.Advance,
+			Champion:  t.champion,
+			Rounds:    rounds,
+			Standings: standings,
+		},
+	}
+	return message.Serialise(jsonCodec)
+}
+
+// Sends the current state to every client following the feed.
+// Must be called with the lock held.
+func (t *Tournament) publish() {
+	if len(t.feed) == 0 {
+		return
+	}
+	bytes, err := t.snapshot()
+	if err != nil {
+		t.logger.Error("failed to serialise tournament", "error", err)
+		return
+	}
+	for client := range t.feed {
+		client.Enqueue(bytes)
+	}
+}
+
+// Adds a client to the feed, sending it the current state.
+func (t *Tournament) Follow(client *Client) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.feed[client] = true
+	bytes, err := t.snapshot()
+	if err == nil {
+		client.Enqueue(bytes)
+	}
+}
+
+func (t *Tournament) Unfollow(client *Client) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.feed, client)
+}
+
+// Tracks running tournaments.
+type TournamentManager struct {
+	lock        sync.RWMutex
+	Tournaments map[string]*Tournament
+}
+
+// Global tournament store.
+var tm = TournamentManager{Tournaments: map[string]*Tournament{}}
+
+func (tm *TournamentManager) Add(t *Tournament) {
+	tm.lock.Lock()
+	defer tm.lock.Unlock()
+	tm.Tournaments[t.Id] = t
+}
+
+func (tm *TournamentManager) Get(id string) (*Tournament, bool) {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+	t, ok := tm.Tournaments[id]
+	return t, ok
+}
+
+// Request body for creating a tournament.
+type CreateTournamentRequest struct {
+	Name      string `json:"name"`
+	TableSize int    `json:"tableSize"`
+	Advance   int    `json:"advance"`
+}
+
+// Reads the account from a bearer token, writing an error if there isn't a valid one.
+func requireAccount(w http.ResponseWriter, r *http.Request) (Account, bool) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	account, err := authenticateAccount(token)
+	if err != nil {
+		http.Error(w, "unauthorised", http.StatusUnauthorized)
+		return Account{}, false
+	}
+	return account, true
+}
+
+// Writes a tournament's current state.
+func writeTournament(w http.ResponseWriter, t *Tournament) {
+	t.lock.Lock()
+	bytes, err := t.snapshot()
+	t.lock.Unlock()
+	if err != nil {
+		http.Error(w, "failed to encode tournament", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+// Creates a tournament at `POST /tournaments`, organised by the account whose token is given.
+func createTournamentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	account, ok := requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	request := CreateTournamentRequest{TableSize: DefaultTableSize, Advance: 1}
+	if err := decodeBody(r, jsonCodec, &request); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if request.TableSize < MinTableSize || request.TableSize > game.MaxPlayers {
+		http.Error(w, "table size must be between 2 and 6", http.StatusBadRequest)
+		return
+	}
+	if request.Advance < 1 || request.Advance >= request.TableSize {
+		http.Error(w, "advance must be at least 1 and less than the table size", http.StatusBadRequest)
+		return
+	}
+
+	t := NewTournament(request.Name, account.Id, request.TableSize, request.Advance)
+	tm.Add(t)
+	t.logger.Info("tournament created", "organiser", account.Id)
+	writeTournament(w, t)
+}
+
+// Looks up the tournament in the path, writing an error if it doesn't exist.
+func tournamentFromPath(w http.ResponseWriter, r *http.Request) (*Tournament, bool) {
+	t, ok := tm.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "tournament not found", http.StatusNotFound)
+	}
+	return t, ok
+}
+
+// Serves a tournament's bracket and standings at `GET /tournaments/{id}/standings`.
+func standingsHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	writeTournament(w, t)
+}
+
+// Registers the account whose token is given at `POST /tournaments/{id}/register`.
+func registerEntrantHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	account, ok := requireAccount(w, r)
+	if !ok {
+		return
+	}
+	stats, err := accounts.Stats(account.Id)
+	if err != nil {
+		slog.Error("failed to read stats", "error", err)
+		http.Error(w, "failed to read rating", http.StatusInternalServerError)
+		return
+	}
+	if err := t.Register(account, stats.Rating); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeTournament(w, t)
+}
+
+// Starts a tournament at `POST /tournaments/{id}/start`. Only the organiser can start it.
+func startTournamentHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	account, ok := requireAccount(w, r)
+	if !ok {
+		return
+	}
+	if account.Id != t.OrganiserId {
+		http.Error(w, "only the organiser can start the tournament", http.StatusForbidden)
+		return
+	}
+	if err := t.Start(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeTournament(w, t)
+}
+
+// Returns the game and session token for the account's table in the current round,
+// at `GET /tournaments/{id}/seat`.
+func seatHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "method not permitted", http.StatusMethodNotAllowed)
+		return
+	}
+	account, ok := requireAccount(w, r)
+	if !ok {
+		return
+	}
+	seat, err := t.Seat(account.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, seat)
+}
+
+// Streams a `tournament` message holding the tournament's state whenever it changes,
+// at `/tournaments/{id}/feed`.
+func tournamentFeedHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := tournamentFromPath(w, r)
+	if !ok {
+		return
+	}
+	conn, err := upgrade(w, r, nil)
+	if err != nil {
+		slog.Warn("websocket upgrade failed", "error", err)
+		return
+	}
+
+	client := NewClient(websocketTransport{conn}, "", t.logger)
+	go client.HandleMessages()
+	t.Follow(client)
+	defer func() {
+		t.Unfollow(client)
+		client.Close()
+	}()
+
+	// Followers send nothing, so a read only returns when they leave.
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// Adds the tournament routes to `api`, and the standings feed to `sockets`. Tournaments are
+// played by accounts, so no routes are added if accounts are disabled.
+func registerTournamentRoutes(api *http.ServeMux, sockets *http.ServeMux) {
+	if accounts == nil {
+		return
+	}
+	api.Handle("/tournaments", http.HandlerFunc(createTournamentHandler))
+	api.Handle("/tournaments/{id}/standings", http.HandlerFunc(standingsHandler))
+	api.Handle("/tournaments/{id}/register", http.HandlerFunc(registerEntrantHandler))
+	api.Handle("/tournaments/{id}/start", http.HandlerFunc(startTournamentHandler))
+	api.Handle("/tournaments/{id}/seat", http.HandlerFunc(seatHandler))
+	sockets.Handle("/tournaments/{id}/feed", http.HandlerFunc(tournamentFeedHandler))
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPlanRound(t *testing.T) {
+	players := func(n int) []string {
+		ids := []string{}
+		for i := range n {
+			ids = append(ids, fmt.Sprint(i))
+		}
+		return ids
+	}
+
+	cases := []struct {
+		players   int
+		tableSize int
+		byes      int
+		tables    []int
+	}{
+		{8, 4, 0, []int{4, 4}},
+		{5, 4, 0, []int{3, 2}},
+		{3, 4, 0, []int{3}},
+		{3, 2, 1, []int{2}},
+		{5, 2, 1, []int{2, 2}},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("should seat %d players at tables of %d", c.players, c.tableSize), func(t *testing.T) {
+			byes, tables := planRound(players(c.players), c.tableSize)
+
+			sizes := []int{}
+			for _, table := range tables {
+				sizes = append(sizes, len(table))
+			}
+			if len(byes) != c.byes || fmt.Sprint(sizes) != fmt.Sprint(c.tables) {
+				t.Errorf("expected %d byes and tables %v, got %v and %v", c.byes, c.tables, byes, sizes)
+			}
+		})
+	}
+
+	t.Run("should give byes to the highest seeds", func(t *testing.T) {
+		byes, _ := planRound([]string{"first", "second", "third"}, 2)
+
+		if len(byes) != 1 || byes[0] != "first" {
+			t.Errorf("expected the top seed to get a bye, got %v", byes)
+		}
+	})
+}
+
+func TestTournament(t *testing.T) {
+	create := func(t *testing.T, players int, tableSize int, advance int) *Tournament {
+		initInstanceManager()
+		tournament := NewTournament("Test", "organiser", tableSize, advance)
+		for i := range players {
+			account := Account{Id: fmt.Sprint(i), Profile: Profile{DisplayName: fmt.Sprint("Player ", i)}}
+			tournament.Register(account, float64(2000-i))
+		}
+		t.Cleanup(func() {
+			for _, instance := range im.ListInstances() {
+				im.DeleteInstance(instance.GameId)
+			}
+		})
+		return tournament
+	}
+	setup := func(t *testing.T, players int) *Tournament {
+		return create(t, players, 2, 1)
+	}
+	// Connects an account to its seat at a table, readying it if `ready` is set.
+	join := func(table *TournamentTable, accountId string, ready bool) *Client {
+		playerId := table.seats[accountId]
+		client := NewClient(nil, "Player "+accountId, slog.Default())
+		client.Id = playerId
+		table.instance.Do(func() {
+			table.instance.Game.AddPlayer(playerId, client.Name)
+			table.instance.Clients[playerId] = client
+			table.instance.Ready[playerId] = ready
+		})
+		return client
+	}
+
+	t.Run("should advance table winners until there is a champion", func(t *testing.T) {
+		tournament := setup(t, 4)
+		if err := tournament.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		tables := currentTables(tournament)
+		if len(tables) != 2 {
+			t.Fatalf("expected 2 tables, got %d", len(tables))
+		}
+		for _, table := range tables {
+			if _, ok := im.GetInstance(table.GameId); !ok {
+				t.Errorf("expected a game for each table")
+			}
+			// The lower seed wins each table.
+			tournament.finishTable(table, []string{table.Players[1], table.Players[0]})
+		}
+
+		final := currentTables(tournament)
+		if len(final) != 1 || fmt.Sprint(final[0].Players) != "[2 3]" {
+			t.Fatalf("expected a final between 2 and 3, got %+v", final)
+		}
+		tournament.finishTable(final[0], []string{"3", "2"})
+
+		tournament.lock.Lock()
+		defer tournament.lock.Unlock()
+		if tournament.status != TournamentComplete || tournament.champion != "3" {
+			t.Errorf("expected 3 to be champion, got %s (%s)", tournament.champion, tournament.status)
+		}
+		if tournament.entrants["0"].Status != EntrantEliminated {
+			t.Errorf("expected 0 to be eliminated, got %s", tournament.entrants["0"].Status)
+		}
+	})
+
+	t.Run("should knock someone out at tables smaller than the table size", func(t *testing.T) {
+		tournament := create(t, 4, 3, 2)
+		tournament.Start()
+
+		for round := 1; ; round++ {
+			tables := currentTables(tournament)
+			tournament.lock.Lock()
+			complete := tournament.status == TournamentComplete
+			tournament.lock.Unlock()
+			if complete {
+				break
+			}
+			if round > 3 {
+				t.Fatal("expected a champion within three rounds")
+			}
+			for _, table := range tables {
+				tournament.finishTable(table, slices.Clone(table.Players))
+			}
+		}
+
+		tournament.lock.Lock()
+		defer tournament.lock.Unlock()
+		if tournament.champion == "" {
+			t.Error("expected a champion to be crowned")
+		}
+	})
+
+	t.Run("should advance byes to the next round", func(t *testing.T) {
+		tournament := setup(t, 3)
+		tournament.Start()
+
+		tables := currentTables(tournament)
+		if len(tables) != 1 || fmt.Sprint(tables[0].Players) != "[1 2]" {
+			t.Fatalf("expected 1 and 2 to play while 0 has a bye, got %+v", tables)
+		}
+		tournament.finishTable(tables[0], []string{"2", "1"})
+
+		final := currentTables(tournament)
+		if fmt.Sprint(final[0].Players) != "[0 2]" {
+			t.Errorf("expected the bye to play the winner, got %v", final[0].Players)
+		}
+	})
+
+	t.Run("should forfeit players who don't join their table", func(t *testing.T) {
+		tournament := setup(t, 2)
+		tournament.Start()
+		table := currentTables(tournament)[0]
+
+		// Only the first player takes their seat.
+		join(table, "0", true)
+		tournament.checkNoShows(table)
+
+		tournament.lock.Lock()
+		defer tournament.lock.Unlock()
+		if fmt.Sprint(table.Forfeits) != "[1]" || tournament.champion != "0" {
+			t.Errorf("expected 1 to forfeit to 0, got forfeits %v and champion %s", table.Forfeits, tournament.champion)
+		}
+		if tournament.entrants["1"].Status != EntrantForfeited {
+			t.Errorf("expected 1 to have forfeited, got %s", tournament.entrants["1"].Status)
+		}
+		if _, ok := im.GetInstance(table.GameId); ok {
+			t.Error("expected the abandoned game to be removed")
+		}
+	})
+
+	t.Run("should forfeit players who join but don't ready up", func(t *testing.T) {
+		tournament := setup(t, 2)
+		tournament.Start()
+		table := currentTables(tournament)[0]
+
+		join(table, "0", true)
+		idle := join(table, "1", false)
+		tournament.checkNoShows(table)
+
+		tournament.lock.Lock()
+		defer tournament.lock.Unlock()
+		if fmt.Sprint(table.Forfeits) != "[1]" || tournament.champion != "0" {
+			t.Errorf("expected 1 to forfeit to 0, got forfeits %v and champion %s", table.Forfeits, tournament.champion)
+		}
+		if !idle.Spectator {
+			t.Error("expected the idle player to lose their seat")
+		}
+	})
+
+	t.Run("should start tables without waiting for the owner once the deadline passes", func(t *testing.T) {
+		tournament := create(t, 3, 3, 1)
+		tournament.Start()
+		table := currentTables(tournament)[0]
+
+		join(table, "0", true)
+		join(table, "1", true)
+		tournament.checkNoShows(table)
+
+		var status GameStatus
+		table.instance.Do(func() { status = table.instance.Status })
+		if status != InProgress {
+			t.Errorf("expected the table's game to start, got %s", status)
+		}
+		tournament.lock.Lock()
+		defer tournament.lock.Unlock()
+		if fmt.Sprint(table.Forfeits) != "[2]" || table.Complete {
+			t.Errorf("expected 2 to forfeit while the table plays on, got forfeits %v", table.Forfeits)
+		}
+	})
+
+	t.Run("should not register players once started", func(t *testing.T) {
+		tournament := setup(t, 2)
+		tournament.Start()
+
+		if err := tournament.Register(Account{Id: "late"}, 1500); err != ErrTournamentStarted {
+			t.Errorf("expected registration to be closed, got %v", err)
+		}
+	})
+}
+
+func TestTournamentHandlers(t *testing.T) {
+	passwordCost = bcrypt.MinCost
+	t.Run("should run a tournament over HTTP and publish it to the feed", func(t *testing.T) {
+		store, err := OpenAccountStore(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts = store
+		defer func() {
+			store.Close()
+			accounts = nil
+		}()
+		initInstanceManager()
+
+		// Building the full set of routes also checks none of them conflict.
+		server := httptest.NewServer(NewServeMux(DefaultConfig()))
+		defer server.Close()
+
+		tokens := []string{}
+		for _, name := range []string{"organiser", "player"} {
+			account, _ := store.Create(name, "password1")
+			tokens = append(tokens, sessions.IssueAccount(account.Id))
+		}
+		request := func(method string, path string, token string, body string) *http.Response {
+			req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			return res
+		}
+
+		res := request("POST", "/api/tournaments", tokens[0], `{"name":"Cup","tableSize":2}`)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected tournament to be created, got %d", res.StatusCode)
+		}
+		var tournament *Tournament
+		for _, candidate := range tm.Tournaments {
+			if candidate.Name == "Cup" {
+				tournament = candidate
+			}
+		}
+		base := "/api/tournaments/" + tournament.Id
+
+		feed, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws/tournaments/"+tournament.Id+"/feed", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer feed.Close()
+
+		for _, token := range tokens {
+			if res := request("POST", base+"/register", token, ""); res.StatusCode != http.StatusOK {
+				t.Fatalf("expected to register, got %d", res.StatusCode)
+			}
+		}
+		if res := request("POST", base+"/start", tokens[1], ""); res.StatusCode != http.StatusForbidden {
+			t.Errorf("expected only the organiser to start, got %d", res.StatusCode)
+		}
+		if res := request("POST", base+"/start", tokens[0], ""); res.StatusCode != http.StatusOK {
+			t.Fatalf("expected to start, got %d", res.StatusCode)
+		}
+		if res := request("GET", base+"/seat", tokens[1], ""); res.StatusCode != http.StatusOK {
+			t.Errorf("expected a seat, got %d", res.StatusCode)
+		}
+
+		for {
+			var message struct {
+				Type    ServerMessageType  `json:"type"`
+				Payload TournamentSnapshot `json:"payload"`
+			}
+			if err := feed.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			}
+			if message.Type != TournamentMessage {
+				t.Fatalf("expected tournament messages, got %s", message.Type)
+			}
+			if message.Payload.Status == TournamentRunning {
+				if len(message.Payload.Rounds) != 1 || len(message.Payload.Standings) != 2 {
+					t.Errorf("expected one round with two players, got %+v", message.Payload)
+				}
+				break
+			}
+		}
+
+		for _, table := range currentTables(tournament) {
+			im.DeleteInstance(table.GameId)
+		}
+	})
+}
+
+func currentTables(tournament *Tournament) []*TournamentTable {
+	tournament.lock.Lock()
+	defer tournament.lock.Unlock()
+	return tournament.rounds[len(tournament.rounds)-1].Tables
+}
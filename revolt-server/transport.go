@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Delivers encoded messages to a client.
+type Transport interface {
+	// Writes a single message, which was encoded for a frame of type `frameType`.
+	Write(frameType int, message []byte) error
+	Close() error
+}
+
+// Sends messages as websocket frames.
+type websocketTransport struct {
+	conn *websocket.Conn
+}
+
+func (t websocketTransport) Write(frameType int, message []byte) error {
+	t.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	return t.conn.WriteMessage(frameType, message)
+}
+
+func (t websocketTransport) Close() error {
+	return t.conn.Close()
+}
+
+// Sends messages to a websocket on another node of the cluster, which writes them as frames.
+type busTransport struct {
+	cluster *Cluster
+	node    string
+	conn    string
+}
+
+func (t busTransport) Write(frameType int, message []byte) error {
+	return t.cluster.send(t.node, clusterMessage{Type: deliverMessage, Conn: t.conn, FrameType: frameType, Data: message})
+}
+
+func (t busTransport) Close() error {
+	return t.cluster.send(t.node, clusterMessage{Type: closeMessage, Conn: t.conn})
+}
+
+// Sends messages as Server-Sent Events. Only text encodings can be sent.
+type sseTransport struct {
+	lock       sync.Mutex
+	w          http.ResponseWriter
+	controller *http.ResponseController
+	closed     bool
+}
+
+func newSSETransport(w http.ResponseWriter) *sseTransport {
+	return &sseTransport{w: w, controller: http.NewResponseController(w)}
+}
+
+func (t *sseTransport) Write(frameType int, message []byte) error {
+	if frameType != websocket.TextMessage {
+		return fmt.Errorf("can't send binary messages as events")
+	}
+	return t.write(fmt.Sprintf("data: %s\n\n", message))
+}
+
+// Writes a comment line, which clients ignore, to keep idle connections open through proxies.
+func (t *sseTransport) KeepAlive() error {
+	return t.write(": keepalive\n\n")
+}
+
+func (t *sseTransport) write(event string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return http.ErrHandlerTimeout
+	}
+
+	t.controller.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	_, err := t.w.Write([]byte(event))
+	if err != nil {
+		return err
+	}
+	return t.controller.Flush()
+}
+
+// Stops further writes. The response itself is finished when the handler returns.
+func (t *sseTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.closed = true
+	return nil
+}